@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/greboid/dfo/pkg/processor"
+	"github.com/greboid/dfo/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan [directory|dfo.yaml]",
+	Short: "Print the Containerfile structure with placeholder pins, without any network access",
+	RunE:  runPlan,
+}
+
+var planOnlyStage string
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.Flags().StringVar(&planOnlyStage, "only-stage", "", "Plan stages up to and including the named stage, for use with --target")
+}
+
+func runPlan(_ *cobra.Command, args []string) error {
+	var input string
+	if len(args) > 0 {
+		input = args[0]
+	}
+
+	fs := util.DefaultFS()
+
+	configPath, err := processor.ResolveConfigPath(fs, input)
+	if err != nil {
+		return err
+	}
+
+	content, err := processor.PlanConfig(fs, configPath, planOnlyStage)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(content)
+	return nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/greboid/dfo/pkg/processor"
+	"github.com/greboid/dfo/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	resolveAlpineVersionFlag string
+	resolvePackages          bool
+)
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve [directory|dfo.yaml]",
+	Short: "Show how requested specs resolve without generating any output",
+	RunE:  runResolve,
+}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+	resolveCmd.Flags().StringVar(&resolveAlpineVersionFlag, "alpine-version", "", "Alpine Linux version to resolve packages against (default: auto-detect latest)")
+	resolveCmd.Flags().BoolVar(&resolvePackages, "packages", false, "List each requested apk package with its resolved version and repository")
+}
+
+func runResolve(_ *cobra.Command, args []string) error {
+	if !resolvePackages {
+		return fmt.Errorf("nothing to resolve: pass --packages")
+	}
+
+	var input string
+	if len(args) > 0 {
+		input = args[0]
+	}
+
+	fs := util.DefaultFS()
+
+	configPath, err := processor.ResolveConfigPath(fs, input)
+	if err != nil {
+		return err
+	}
+
+	alpineVersion, err := resolveAlpineVersion(resolveAlpineVersionFlag)
+	if err != nil {
+		return err
+	}
+
+	resolutions, err := processor.ResolvePackages(fs, configPath, alpineClient, alpineVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, resolution := range resolutions {
+		fmt.Printf("%s: %s -> %s=%s (%s)\n", resolution.Stage, resolution.Spec, resolution.Spec, resolution.Version, resolution.Repo)
+	}
+
+	return nil
+}
@@ -4,26 +4,36 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"time"
 
+	"github.com/greboid/dfo/pkg/generator"
 	"github.com/greboid/dfo/pkg/processor"
 	"github.com/greboid/dfo/pkg/util"
 	"github.com/spf13/cobra"
 )
 
 var (
-	singleOutputDir     string
-	singleAlpineVersion string
-	singleGitUser       string
-	singleGitPass       string
-	singleRegistry      string
-	singleStoragePath   string
-	singleStorageDriver string
-	singleIsolation     string
-	singleConcurrency   int
-	singleForceRebuild  bool
-	singlePush          bool
-	singleBuild         bool
-	singleBuiltImages   string
+	singleOutputDir        string
+	singleAlpineVersion    string
+	singleGitUser          string
+	singleGitPass          string
+	singleRegistry         string
+	singleStoragePath      string
+	singleStorageDriver    string
+	singleIsolation        string
+	singleConcurrency      int
+	singleForceRebuild     bool
+	singlePush             bool
+	singleBuild            bool
+	singleBuiltImages      string
+	singleShellOptions     bool
+	singleStrict           bool
+	singleStats            bool
+	singleDigestOnly       bool
+	singleComputeChecksums bool
+	singleAttestation      bool
+	singleOnlyStage        string
+	singleSyntax           string
 )
 
 var singleCmd = &cobra.Command{
@@ -48,6 +58,14 @@ func init() {
 	singleCmd.Flags().BoolVar(&singlePush, "push", false, "Push built image to registry after successful build")
 	singleCmd.Flags().BoolVar(&singleBuild, "build", false, "Build the container using buildah")
 	singleCmd.Flags().StringVar(&singleBuiltImages, "built-images", "", "JSON string of built image digests (format: {\"imagename\":\"digest\"})")
+	singleCmd.Flags().BoolVar(&singleShellOptions, "shell-options", false, "Prefix multi-line RUN commands with set -eux")
+	singleCmd.Flags().BoolVar(&singleStrict, "strict", false, "Treat generation warnings (relative workdirs, clashing downloads, empty stages, ...) as errors")
+	singleCmd.Flags().BoolVar(&singleDigestOnly, "digest-only", false, "Reference base images by digest only, dropping the tag from FROM lines")
+	singleCmd.Flags().BoolVar(&singleComputeChecksums, "compute-checksums", false, "Compute missing download-verify-extract checksums at generate time and record them in the lock file")
+	singleCmd.Flags().BoolVar(&singleAttestation, "attestation", false, "Write a SLSA provenance predicate of the resolved packages and images for use with cosign attest --type slsaprovenance")
+	singleCmd.Flags().StringVar(&singleOnlyStage, "only-stage", "", "Generate stages up to and including the named stage, for use with --target")
+	singleCmd.Flags().StringVar(&singleSyntax, "syntax", "", "Dockerfile frontend to declare in a leading syntax directive (e.g. docker/dockerfile:1)")
+	singleCmd.Flags().BoolVar(&singleStats, "stats", false, "Report resolution counts and timings without writing any output")
 	_ = singleCmd.MarkFlagRequired("registry")
 }
 
@@ -99,12 +117,37 @@ func runSingle(_ *cobra.Command, args []string) error {
 		return buildContainers(cfg, graphResult)
 	}
 
-	result, err := processor.ProcessConfigWithBuiltImages(fs, configPath, singleOutputDir, alpineClient, resolvedVersion, singleGitUser, singleGitPass, singleRegistry, nil, builtImages, nil)
+	var outputFS util.WritableFS = fs
+	if singleStats {
+		outputFS = util.DryRunFS{WritableFS: fs}
+	}
+
+	opts := processor.ProcessOptions{
+		ShellOptions:     singleShellOptions,
+		Strict:           singleStrict,
+		DigestOnly:       singleDigestOnly,
+		ComputeChecksums: singleComputeChecksums,
+		Attestation:      singleAttestation,
+		OnlyStage:        singleOnlyStage,
+		Syntax:           singleSyntax,
+	}
+	result, err := processor.ProcessConfigWithBuiltImages(outputFS, configPath, singleOutputDir, alpineClient, resolvedVersion, singleGitUser, singleGitPass, singleRegistry, nil, builtImages, nil, opts)
 	if err != nil {
 		return fmt.Errorf("failed to process config: %w", err)
 	}
 
 	fmt.Printf("✓ %s\n", result.PackageName)
 
+	if singleStats {
+		printStats(result.Stats)
+	}
+
 	return nil
 }
+
+func printStats(stats generator.Stats) {
+	fmt.Printf("  Versions: %d resolved in %s\n", stats.Versions, stats.VersionsDuration.Round(time.Millisecond))
+	fmt.Printf("  Images:   %d resolved in %s\n", stats.Images, stats.ImagesDuration.Round(time.Millisecond))
+	fmt.Printf("  Packages: %d resolved in %s\n", stats.Packages, stats.PackagesDuration.Round(time.Millisecond))
+	fmt.Printf("  Total:    %s\n", stats.TotalDuration.Round(time.Millisecond))
+}
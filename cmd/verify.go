@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/greboid/dfo/pkg/config"
+	"github.com/greboid/dfo/pkg/images"
+	"github.com/greboid/dfo/pkg/processor"
+	"github.com/greboid/dfo/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyRegistry      string
+	verifyContainerfile string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [directory|dfo.yaml]",
+	Short: "Check that the digests pinned in a generated Containerfile still match their tags",
+	RunE:  runVerify,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVar(&verifyRegistry, "registry", "", "Container registry to use for image resolution (required)")
+	verifyCmd.Flags().StringVar(&verifyContainerfile, "containerfile", "", "Generated Containerfile to verify (default: Containerfile next to the config)")
+	_ = verifyCmd.MarkFlagRequired("registry")
+}
+
+func runVerify(_ *cobra.Command, args []string) error {
+	var input string
+	if len(args) > 0 {
+		input = args[0]
+	}
+
+	fs := util.DefaultFS()
+
+	configPath, err := processor.ResolveConfigPath(fs, input)
+	if err != nil {
+		return err
+	}
+
+	containerfilePath := verifyContainerfile
+	if containerfilePath == "" {
+		containerfilePath = path.Join(path.Dir(configPath), "Containerfile")
+	}
+
+	cfg, err := config.Load(fs, configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	containerfile, err := fs.ReadFile(containerfilePath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", containerfilePath, err)
+	}
+
+	registry := verifyRegistry
+	if cfg.Registry != "" {
+		registry = cfg.Registry
+	}
+	resolver := images.NewResolver(registry, false)
+
+	checks, err := processor.VerifyPinnedDigests(context.Background(), cfg, string(containerfile), func(ctx context.Context, image string) (string, error) {
+		resolved, err := resolver.Resolve(ctx, image)
+		if err != nil {
+			return "", err
+		}
+		return resolved.Digest, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	checked, drifted := 0, 0
+	for _, check := range checks {
+		if check.Skipped != "" {
+			fmt.Printf("- %s (%s): skipped, %s\n", check.Stage, check.Image, check.Skipped)
+			continue
+		}
+		checked++
+		if check.Drifted() {
+			drifted++
+			fmt.Printf("✗ %s (%s): pinned %s, current %s\n", check.Stage, check.Image, check.Pinned, check.Current)
+			continue
+		}
+		fmt.Printf("✓ %s (%s): %s\n", check.Stage, check.Image, check.Pinned)
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d of %d pinned digest(s) are stale; regenerate %s", drifted, checked, containerfilePath)
+	}
+
+	return nil
+}
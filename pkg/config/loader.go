@@ -4,22 +4,40 @@ import (
 	"bytes"
 	"fmt"
 	"io/fs"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/greboid/dfo/pkg/packages"
 	"github.com/greboid/dfo/pkg/templates"
 	"gopkg.in/yaml.v3"
 )
 
-func Load(fs fs.ReadFileFS, path string) (*BuildConfig, error) {
-	data, err := fs.ReadFile(path)
+func Load(fs fs.ReadFileFS, configPath string) (*BuildConfig, error) {
+	data, err := fs.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
 
-	return Parse(data)
+	return parse(data, func(config *BuildConfig) error {
+		return loadExternalFiles(fs, path.Dir(configPath), config)
+	})
 }
 
 func Parse(data []byte) (*BuildConfig, error) {
+	return parse(data, func(config *BuildConfig) error {
+		if config.VersionsFile != "" || config.PackagesFile != "" {
+			return fmt.Errorf("versions-file and packages-file require loading the config from a path")
+		}
+		return nil
+	})
+}
+
+func parse(data []byte, loadExternal func(*BuildConfig) error) (*BuildConfig, error) {
 	var config BuildConfig
 
 	decoder := yaml.NewDecoder(bytes.NewReader(data))
@@ -33,6 +51,10 @@ func Parse(data []byte) (*BuildConfig, error) {
 		return nil, err
 	}
 
+	if err := loadExternal(&config); err != nil {
+		return nil, err
+	}
+
 	if err := Validate(&config); err != nil {
 		return nil, err
 	}
@@ -40,6 +62,74 @@ func Parse(data []byte) (*BuildConfig, error) {
 	return &config, nil
 }
 
+func loadExternalFiles(fs fs.ReadFileFS, baseDir string, config *BuildConfig) error {
+	if config.VersionsFile != "" {
+		var versions map[string]string
+		if err := readExternalFile(fs, baseDir, config.VersionsFile, &versions); err != nil {
+			return fmt.Errorf("loading versions-file: %w", err)
+		}
+		if err := mergeVersions(config, versions); err != nil {
+			return fmt.Errorf("loading versions-file: %w", err)
+		}
+	}
+
+	if config.PackagesFile != "" {
+		var stagePackages map[string][]string
+		if err := readExternalFile(fs, baseDir, config.PackagesFile, &stagePackages); err != nil {
+			return fmt.Errorf("loading packages-file: %w", err)
+		}
+		if err := mergeStagePackages(config, stagePackages); err != nil {
+			return fmt.Errorf("loading packages-file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func readExternalFile(fs fs.ReadFileFS, baseDir, filePath string, out any) error {
+	if !path.IsAbs(filePath) {
+		filePath = path.Join(baseDir, filePath)
+	}
+
+	data, err := fs.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", filePath, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("parsing %s: %w", filePath, err)
+	}
+	return nil
+}
+
+func mergeVersions(config *BuildConfig, versions map[string]string) error {
+	if len(versions) > 0 && config.Versions == nil {
+		config.Versions = make(map[string]string, len(versions))
+	}
+	for key, value := range versions {
+		if _, exists := config.Versions[key]; exists {
+			return fmt.Errorf("version %q is also defined in the config", key)
+		}
+		config.Versions[key] = value
+	}
+	return nil
+}
+
+func mergeStagePackages(config *BuildConfig, stagePackages map[string][]string) error {
+	for stageName, pkgs := range stagePackages {
+		index := slices.IndexFunc(config.Stages, func(stage Stage) bool {
+			return stage.Name == stageName
+		})
+		if index == -1 {
+			return fmt.Errorf("unknown stage %q", stageName)
+		}
+		config.Stages[index].Environment.Packages = append(config.Stages[index].Environment.Packages, pkgs...)
+	}
+	return nil
+}
+
 func expandTemplates(config *BuildConfig) error {
 	var expandedStages []Stage
 
@@ -62,12 +152,27 @@ func expandTemplates(config *BuildConfig) error {
 
 		templateStages := convertAndNameTemplateStages(&templateResult, stage, i)
 		expandedStages = append(expandedStages, templateStages...)
+		mergeTemplateLabels(config, templateResult.Labels)
 	}
 
 	config.Stages = expandedStages
 	return nil
 }
 
+func mergeTemplateLabels(config *BuildConfig, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	if config.Package.Labels == nil {
+		config.Package.Labels = make(map[string]string, len(labels))
+	}
+	for key, value := range labels {
+		if _, ok := config.Package.Labels[key]; !ok {
+			config.Package.Labels[key] = value
+		}
+	}
+}
+
 func validateTemplateUsage(stage *Stage, index int) error {
 	if !stage.Environment.IsEmpty() {
 		return fmt.Errorf("stage %d: cannot specify both 'template' and 'environment'", index)
@@ -75,6 +180,9 @@ func validateTemplateUsage(stage *Stage, index int) error {
 	if len(stage.Pipeline) > 0 {
 		return fmt.Errorf("stage %d: cannot specify both 'template' and 'pipeline'", index)
 	}
+	if len(stage.Raw) > 0 {
+		return fmt.Errorf("stage %d: cannot specify both 'template' and 'raw'", index)
+	}
 
 	if err := templates.ValidateTemplateParams(stage.Template, stage.With); err != nil {
 		return fmt.Errorf("stage %d with template %q: %w", index, stage.Template, err)
@@ -115,6 +223,7 @@ func convertAndNameTemplateStages(result *templates.TemplateResult, originalStag
 
 	for j, stageResult := range result.Stages {
 		newStage := convertStageResult(stageResult)
+		newStage.DependsOn = slices.Clone(originalStage.DependsOn)
 
 		if stageResult.Name != "" {
 			newStage.Name = stageResult.Name
@@ -160,6 +269,8 @@ func convertStageResult(stageResult templates.StageResult) Stage {
 			Expose:         stageResult.Environment.Expose,
 			Entrypoint:     stageResult.Environment.Entrypoint,
 			Cmd:            stageResult.Environment.Cmd,
+			Volume:         stageResult.Environment.Volume,
+			StopSignal:     stageResult.Environment.StopSignal,
 		},
 		Pipeline: make([]PipelineStep, len(stageResult.Pipeline)),
 	}
@@ -176,6 +287,7 @@ func convertStageResult(stageResult templates.StageResult) Stage {
 				From:      step.Copy.From,
 				To:        step.Copy.To,
 				Chown:     step.Copy.Chown,
+				Chmod:     step.Copy.Chmod,
 			}
 		}
 		stage.Pipeline[i] = pipelineStep
@@ -184,19 +296,47 @@ func convertStageResult(stageResult templates.StageResult) Stage {
 	return stage
 }
 
+var packageNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
 func Validate(config *BuildConfig) error {
 	if config.Package.Name == "" {
 		return fmt.Errorf("package.name is required")
 	}
+	if !packageNamePattern.MatchString(config.Package.Name) {
+		return fmt.Errorf("package.name %q is not a valid image name: use lowercase letters, digits and separators (., _, __, -) between them", config.Package.Name)
+	}
+
+	if config.ApkArch != "" {
+		if err := packages.ValidateArch(config.ApkArch); err != nil {
+			return fmt.Errorf("apk-arch: %w", err)
+		}
+	}
+
+	if err := validateApkSnapshot(config); err != nil {
+		return err
+	}
 
 	if len(config.Stages) == 0 {
 		return fmt.Errorf("at least one stage is required in the 'stages' array")
 	}
 
-	for _, stage := range config.Stages {
+	for i, stage := range config.Stages {
 		if err := validateStage(stage); err != nil {
 			return err
 		}
+		if stage.Environment.Init != "" && i != len(config.Stages)-1 {
+			return fmt.Errorf("stage %q: environment.init is only supported on the final stage", stage.Name)
+		}
+		if stage.Environment.DropPrivileges != nil && i != len(config.Stages)-1 {
+			return fmt.Errorf("stage %q: environment.drop-privileges is only supported on the final stage", stage.Name)
+		}
+		if stage.Environment.HealthCheck != nil && i != len(config.Stages)-1 {
+			return fmt.Errorf("stage %q: environment.healthcheck is only supported on the final stage", stage.Name)
+		}
+	}
+
+	if err := validateStageDependencies(config.Stages); err != nil {
+		return err
 	}
 
 	if !config.Environment.IsEmpty() {
@@ -206,6 +346,131 @@ func Validate(config *BuildConfig) error {
 	return nil
 }
 
+func validateApkSnapshot(config *BuildConfig) error {
+	switch {
+	case config.ApkSnapshot == "":
+		if config.ApkSnapshotMirror != "" {
+			return fmt.Errorf("apk-snapshot-mirror requires a dated apk-snapshot")
+		}
+		return nil
+	case isSnapshotDate(config.ApkSnapshot):
+		if config.ApkSnapshotMirror == "" {
+			return fmt.Errorf("apk-snapshot date %q requires apk-snapshot-mirror", config.ApkSnapshot)
+		}
+		return validateMirrorURL("apk-snapshot-mirror", config.ApkSnapshotMirror)
+	case config.ApkSnapshotMirror != "":
+		return fmt.Errorf("apk-snapshot-mirror can only be used with a dated apk-snapshot (YYYY-MM-DD)")
+	default:
+		return validateMirrorURL("apk-snapshot", config.ApkSnapshot)
+	}
+}
+
+func validateMirrorURL(field, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %q must be an http(s) URL or a date (YYYY-MM-DD) of an Alpine mirror snapshot", field, value)
+	}
+	return nil
+}
+
+func isSnapshotDate(value string) bool {
+	_, err := time.Parse(time.DateOnly, value)
+	return err == nil
+}
+
+// ApkSnapshotURL returns the mirror that apk-snapshot points at, resolving a
+// date to its directory under apk-snapshot-mirror.
+func (c *BuildConfig) ApkSnapshotURL() string {
+	if isSnapshotDate(c.ApkSnapshot) && c.ApkSnapshotMirror != "" {
+		return strings.TrimSuffix(c.ApkSnapshotMirror, "/") + "/" + c.ApkSnapshot
+	}
+	return c.ApkSnapshot
+}
+
+func validateStageDependencies(stages []Stage) error {
+	stageIndex := make(map[string]int, len(stages))
+	for i, stage := range stages {
+		if _, exists := stageIndex[stage.Name]; !exists {
+			stageIndex[stage.Name] = i
+		}
+	}
+
+	dependencies := make(map[string][]string, len(stages))
+	for _, stage := range stages {
+		for _, dep := range stage.DependsOn {
+			if _, ok := stageIndex[dep]; !ok {
+				return fmt.Errorf("stage %q: depends-on references unknown stage %q", stage.Name, dep)
+			}
+		}
+		dependencies[stage.Name] = stageDependencies(stage, stageIndex)
+	}
+
+	if cycle := findStageCycle(stages, dependencies); cycle != nil {
+		return fmt.Errorf("stage dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	for i, stage := range stages {
+		for _, dep := range dependencies[stage.Name] {
+			if stageIndex[dep] > i {
+				return fmt.Errorf("stage %q depends on stage %q, which must be defined before it", stage.Name, dep)
+			}
+		}
+	}
+
+	return nil
+}
+
+func stageDependencies(stage Stage, stageIndex map[string]int) []string {
+	deps := slices.Clone(stage.DependsOn)
+	for _, step := range stage.Pipeline {
+		if step.Copy == nil || step.Copy.FromStage == "" {
+			continue
+		}
+		if _, ok := stageIndex[step.Copy.FromStage]; ok {
+			deps = append(deps, step.Copy.FromStage)
+		}
+	}
+	return deps
+}
+
+func findStageCycle(stages []Stage, dependencies map[string][]string) []string {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(stages))
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range dependencies[name] {
+			switch state[dep] {
+			case visiting:
+				start := slices.Index(path, dep)
+				return append(slices.Clone(path[start:]), dep)
+			case 0:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return nil
+	}
+
+	for _, stage := range stages {
+		if state[stage.Name] == 0 {
+			if cycle := visit(stage.Name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
 func validateStage(stage Stage) error {
 	if stage.Name == "" {
 		return fmt.Errorf("stage name is required")
@@ -224,5 +489,90 @@ func validateStage(stage Stage) error {
 		return fmt.Errorf("stage %q: cannot specify both environment.base-image and environment.external-image", stage.Name)
 	}
 
+	if init := stage.Environment.Init; init != "" {
+		if _, ok := InitSystems[init]; !ok {
+			return fmt.Errorf("stage %q: unsupported init %q (must be one of: %s)", stage.Name, init, strings.Join(slices.Sorted(maps.Keys(InitSystems)), ", "))
+		}
+	}
+
+	if drop := stage.Environment.DropPrivileges; drop != nil {
+		if drop.User == "" {
+			return fmt.Errorf("stage %q: drop-privileges.user is required", stage.Name)
+		}
+		if user := stage.Environment.User; user != "" && user != "root" && user != "0" {
+			return fmt.Errorf("stage %q: environment.user %q conflicts with drop-privileges, which must start as root", stage.Name, user)
+		}
+	}
+
+	if hc := stage.Environment.HealthCheck; hc != nil {
+		if err := validateHealthCheck(hc); err != nil {
+			return fmt.Errorf("stage %q: %w", stage.Name, err)
+		}
+	}
+
+	for _, key := range stage.Environment.Expand {
+		_, isEnv := stage.Environment.Environment[key]
+		_, isArg := stage.Environment.Args[key]
+		if !isEnv && !isArg {
+			return fmt.Errorf("stage %q: environment.expand %q is not an environment variable or arg of the stage", stage.Name, key)
+		}
+	}
+
+	for i, step := range stage.Pipeline {
+		if step.Fetch != nil && step.Fetch.Retries < 0 {
+			return fmt.Errorf("stage %q step %d: fetch.retries must be non-negative", stage.Name, i+1)
+		}
+		if len(step.CacheMounts) > 0 && step.Run == "" {
+			return fmt.Errorf("stage %q step %d: cache-mounts can only be used with run", stage.Name, i+1)
+		}
+		for _, mount := range step.CacheMounts {
+			if !path.IsAbs(mount.Target) {
+				return fmt.Errorf("stage %q step %d: cache-mounts target %q must be an absolute path", stage.Name, i+1, mount.Target)
+			}
+		}
+		if len(step.Secrets) > 0 && step.Run == "" {
+			return fmt.Errorf("stage %q step %d: secrets can only be used with run", stage.Name, i+1)
+		}
+		for _, id := range step.Secrets {
+			if id == "" || strings.ContainsAny(id, ", ") {
+				return fmt.Errorf("stage %q step %d: invalid secret id %q", stage.Name, i+1, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateHealthCheck(hc *HealthCheck) error {
+	if hc.Disable {
+		if len(hc.Test) > 0 || hc.Interval != "" || hc.Timeout != "" || hc.StartPeriod != "" || hc.Retries != 0 {
+			return fmt.Errorf("healthcheck.disable cannot be combined with other healthcheck options")
+		}
+		return nil
+	}
+
+	if len(hc.Test) == 0 {
+		return fmt.Errorf("healthcheck.test is required")
+	}
+	if hc.Retries < 0 {
+		return fmt.Errorf("healthcheck.retries must be non-negative")
+	}
+
+	durations := []struct {
+		name  string
+		value string
+	}{
+		{"interval", hc.Interval},
+		{"timeout", hc.Timeout},
+		{"start-period", hc.StartPeriod},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d.value); err != nil {
+			return fmt.Errorf("healthcheck.%s %q is not a valid duration", d.name, d.value)
+		}
+	}
 	return nil
 }
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"maps"
+	"slices"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestEnvironmentIsEmpty(t *testing.T) {
@@ -80,6 +84,26 @@ func TestEnvironmentIsEmpty(t *testing.T) {
 			env:      Environment{StopSignal: "SIGTERM"},
 			expected: false,
 		},
+		{
+			name:     "with init",
+			env:      Environment{Init: "tini"},
+			expected: false,
+		},
+		{
+			name:     "with drop-privileges",
+			env:      Environment{DropPrivileges: &DropPrivileges{User: "app"}},
+			expected: false,
+		},
+		{
+			name:     "with shell",
+			env:      Environment{Shell: []string{"/bin/bash", "-c"}},
+			expected: false,
+		},
+		{
+			name:     "with healthcheck",
+			env:      Environment{HealthCheck: &HealthCheck{Disable: true}},
+			expected: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -131,6 +155,74 @@ func TestValidate(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "package name with separators",
+			config: &BuildConfig{
+				Package: Package{Name: "team/my_app.v2-beta"},
+				Stages: []Stage{{
+					Name:        "build",
+					Environment: Environment{BaseImage: "alpine"},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "valid apk arch",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				ApkArch: "aarch64",
+				Stages: []Stage{{
+					Name:        "build",
+					Environment: Environment{BaseImage: "alpine"},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "unknown apk arch",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				ApkArch: "arm64",
+				Stages: []Stage{{
+					Name:        "build",
+					Environment: Environment{BaseImage: "alpine"},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "uppercase package name",
+			config: &BuildConfig{
+				Package: Package{Name: "MyApp"},
+				Stages: []Stage{{
+					Name:        "build",
+					Environment: Environment{BaseImage: "alpine"},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "package name with invalid characters",
+			config: &BuildConfig{
+				Package: Package{Name: "my app!"},
+				Stages: []Stage{{
+					Name:        "build",
+					Environment: Environment{BaseImage: "alpine"},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "package name with leading separator",
+			config: &BuildConfig{
+				Package: Package{Name: "-app"},
+				Stages: []Stage{{
+					Name:        "build",
+					Environment: Environment{BaseImage: "alpine"},
+				}},
+			},
+			expectError: true,
+		},
 		{
 			name: "missing stages array",
 			config: &BuildConfig{
@@ -185,6 +277,280 @@ func TestValidate(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "apk snapshot mirror",
+			config: &BuildConfig{
+				Package:     Package{Name: "test-package"},
+				ApkSnapshot: "https://mirror.example.com/alpine/2024-06-01",
+				Stages:      []Stage{{Name: "build", Environment: Environment{BaseImage: "alpine"}}},
+			},
+			expectError: false,
+		},
+		{
+			name: "apk snapshot date without snapshot mirror",
+			config: &BuildConfig{
+				Package:     Package{Name: "test-package"},
+				ApkSnapshot: "2024-06-01",
+				Stages:      []Stage{{Name: "build", Environment: Environment{BaseImage: "alpine"}}},
+			},
+			expectError: true,
+		},
+		{
+			name: "apk snapshot date with snapshot mirror",
+			config: &BuildConfig{
+				Package:           Package{Name: "test-package"},
+				ApkSnapshot:       "2024-06-01",
+				ApkSnapshotMirror: "https://mirror.example.com/alpine",
+				Stages:            []Stage{{Name: "build", Environment: Environment{BaseImage: "alpine"}}},
+			},
+			expectError: false,
+		},
+		{
+			name: "apk snapshot mirror with snapshot URL",
+			config: &BuildConfig{
+				Package:           Package{Name: "test-package"},
+				ApkSnapshot:       "https://mirror.example.com/alpine/2024-06-01",
+				ApkSnapshotMirror: "https://mirror.example.com/alpine",
+				Stages:            []Stage{{Name: "build", Environment: Environment{BaseImage: "alpine"}}},
+			},
+			expectError: true,
+		},
+		{
+			name: "apk snapshot neither date nor URL",
+			config: &BuildConfig{
+				Package:           Package{Name: "test-package"},
+				ApkSnapshot:       "last tuesday",
+				ApkSnapshotMirror: "https://mirror.example.com/alpine",
+				Stages:            []Stage{{Name: "build", Environment: Environment{BaseImage: "alpine"}}},
+			},
+			expectError: true,
+		},
+		{
+			name: "init on final stage",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{
+					{Name: "build", Environment: Environment{BaseImage: "golang"}},
+					{Name: "final", Environment: Environment{BaseImage: "alpine", Init: "tini"}},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "init on non-final stage",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{
+					{Name: "build", Environment: Environment{BaseImage: "golang", Init: "tini"}},
+					{Name: "final", Environment: Environment{BaseImage: "alpine"}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "cache mounts on run step",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine"},
+					Pipeline:    []PipelineStep{{Run: "go build", CacheMounts: []CacheMount{{Target: "/root/.cache/go-build", ID: "go"}}}},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "cache mounts with relative target",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine"},
+					Pipeline:    []PipelineStep{{Run: "go build", CacheMounts: []CacheMount{{Target: "cache"}}}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "cache mounts without run",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine"},
+					Pipeline:    []PipelineStep{{Uses: "create-user", CacheMounts: []CacheMount{{Target: "/cache"}}}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "secrets on run step",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine"},
+					Pipeline:    []PipelineStep{{Run: "git clone", Secrets: []string{"git-credentials"}}},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "secrets with invalid id",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine"},
+					Pipeline:    []PipelineStep{{Run: "git clone", Secrets: []string{"a,target=/etc/passwd"}}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "secrets without run",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine"},
+					Pipeline:    []PipelineStep{{Copy: &CopyStep{From: "a", To: "/a"}, Secrets: []string{"token"}}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "healthcheck on final stage",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", HealthCheck: &HealthCheck{Test: []string{"/app", "--health"}, Interval: "30s", Retries: 3}},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "healthcheck disabled",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", HealthCheck: &HealthCheck{Disable: true}},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "healthcheck without test",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", HealthCheck: &HealthCheck{Interval: "30s"}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "healthcheck disable with test",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", HealthCheck: &HealthCheck{Disable: true, Test: []string{"/app"}}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "healthcheck invalid interval",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", HealthCheck: &HealthCheck{Test: []string{"/app"}, Interval: "often"}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "healthcheck negative retries",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", HealthCheck: &HealthCheck{Test: []string{"/app"}, Retries: -1}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "healthcheck on non-final stage",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{
+					{Name: "build", Environment: Environment{BaseImage: "golang", HealthCheck: &HealthCheck{Disable: true}}},
+					{Name: "final", Environment: Environment{BaseImage: "alpine"}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "drop-privileges on final stage",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", DropPrivileges: &DropPrivileges{User: "app"}},
+				}},
+			},
+			expectError: false,
+		},
+		{
+			name: "drop-privileges without user",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", DropPrivileges: &DropPrivileges{}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "drop-privileges with non-root user",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", User: "app", DropPrivileges: &DropPrivileges{User: "app"}},
+				}},
+			},
+			expectError: true,
+		},
+		{
+			name: "drop-privileges on non-final stage",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{
+					{Name: "build", Environment: Environment{BaseImage: "golang", DropPrivileges: &DropPrivileges{User: "app"}}},
+					{Name: "final", Environment: Environment{BaseImage: "alpine"}},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "unsupported init",
+			config: &BuildConfig{
+				Package: Package{Name: "test-package"},
+				Stages: []Stage{{
+					Name:        "final",
+					Environment: Environment{BaseImage: "alpine", Init: "s6"},
+				}},
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -197,6 +563,104 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestApkSnapshotURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   BuildConfig
+		expected string
+	}{
+		{
+			name:     "mirror URL",
+			config:   BuildConfig{ApkSnapshot: "https://mirror.example.com/alpine/2024-06-01"},
+			expected: "https://mirror.example.com/alpine/2024-06-01",
+		},
+		{
+			name:     "date under snapshot mirror",
+			config:   BuildConfig{ApkSnapshot: "2024-06-01", ApkSnapshotMirror: "https://mirror.example.com/alpine/"},
+			expected: "https://mirror.example.com/alpine/2024-06-01",
+		},
+		{
+			name:     "unset",
+			config:   BuildConfig{},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.config.ApkSnapshotURL(); result != tt.expected {
+				t.Errorf("ApkSnapshotURL() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateStageDependencies(t *testing.T) {
+	stage := func(name string, dependsOn ...string) Stage {
+		return Stage{Name: name, Environment: Environment{BaseImage: "alpine"}, DependsOn: dependsOn}
+	}
+	copyFrom := func(name, from string) Stage {
+		s := stage(name)
+		s.Pipeline = []PipelineStep{{Copy: &CopyStep{FromStage: from, From: "/app", To: "/app"}}}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		stages  []Stage
+		wantErr string
+	}{
+		{
+			name:   "dependencies defined first",
+			stages: []Stage{stage("deps"), stage("build", "deps"), stage("final", "deps", "build")},
+		},
+		{
+			name:    "dependency defined later",
+			stages:  []Stage{stage("build", "deps"), stage("deps")},
+			wantErr: `stage "build" depends on stage "deps", which must be defined before it`,
+		},
+		{
+			name:    "unknown dependency",
+			stages:  []Stage{stage("build", "missing")},
+			wantErr: `stage "build": depends-on references unknown stage "missing"`,
+		},
+		{
+			name:    "cycle",
+			stages:  []Stage{stage("a", "c"), stage("b", "a"), stage("c", "b")},
+			wantErr: "stage dependency cycle: a -> c -> b -> a",
+		},
+		{
+			name:    "cycle through from-stage copy",
+			stages:  []Stage{copyFrom("a", "b"), stage("b", "a")},
+			wantErr: "stage dependency cycle: a -> b -> a",
+		},
+		{
+			name:    "from-stage copy of later stage",
+			stages:  []Stage{copyFrom("final", "build"), stage("build")},
+			wantErr: `stage "final" depends on stage "build", which must be defined before it`,
+		},
+		{
+			name:   "from-stage copy of external image",
+			stages: []Stage{copyFrom("final", "golang:1.23")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStageDependencies(tt.stages)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateStageDependencies() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateStageDependencies() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestValidateStage(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -230,6 +694,48 @@ func TestValidateStage(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "expand names an environment variable and an arg",
+			stage: Stage{
+				Name: "build",
+				Environment: Environment{
+					BaseImage:   "alpine",
+					Environment: map[string]string{"PATH": "/app/bin:$PATH"},
+					Args:        map[string]string{"HOME_DIR": "$HOME"},
+					Expand:      []string{"PATH", "HOME_DIR"},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "expand names an unknown key",
+			stage: Stage{
+				Name: "build",
+				Environment: Environment{
+					BaseImage: "alpine",
+					Expand:    []string{"PATH"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "fetch with retries",
+			stage: Stage{
+				Name:        "build",
+				Environment: Environment{BaseImage: "alpine"},
+				Pipeline:    []PipelineStep{{Fetch: &FetchStep{URL: "https://example.com/a", Retries: 3}}},
+			},
+			expectError: false,
+		},
+		{
+			name: "fetch with negative retries",
+			stage: Stage{
+				Name:        "build",
+				Environment: Environment{BaseImage: "alpine"},
+				Pipeline:    []PipelineStep{{Fetch: &FetchStep{URL: "https://example.com/a", Retries: -1}}},
+			},
+			expectError: true,
+		},
 		{
 			name: "stage with neither image",
 			stage: Stage{
@@ -279,3 +785,149 @@ func TestValidateStage(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadExternalFiles(t *testing.T) {
+	const base = `package:
+  name: app
+versions:
+  alpine: "3.20"
+stages:
+  - name: build
+    environment:
+      base-image: alpine
+      packages:
+        - git
+`
+	tests := []struct {
+		name         string
+		config       string
+		files        fstest.MapFS
+		wantErr      string
+		wantVersions map[string]string
+		wantPackages []string
+	}{
+		{
+			name:   "versions file is merged",
+			config: base + "versions-file: versions.yaml\n",
+			files: fstest.MapFS{
+				"project/versions.yaml": {Data: []byte("go: \"1.22\"\n")},
+			},
+			wantVersions: map[string]string{"alpine": "3.20", "go": "1.22"},
+			wantPackages: []string{"git"},
+		},
+		{
+			name:   "packages file is merged per stage",
+			config: base + "packages-file: pins/packages.yaml\n",
+			files: fstest.MapFS{
+				"project/pins/packages.yaml": {Data: []byte("build:\n  - curl\n  - make\n")},
+			},
+			wantVersions: map[string]string{"alpine": "3.20"},
+			wantPackages: []string{"git", "curl", "make"},
+		},
+		{
+			name:   "duplicate version",
+			config: base + "versions-file: versions.yaml\n",
+			files: fstest.MapFS{
+				"project/versions.yaml": {Data: []byte("alpine: \"3.19\"\n")},
+			},
+			wantErr: `version "alpine" is also defined in the config`,
+		},
+		{
+			name:   "unknown stage in packages file",
+			config: base + "packages-file: packages.yaml\n",
+			files: fstest.MapFS{
+				"project/packages.yaml": {Data: []byte("missing:\n  - curl\n")},
+			},
+			wantErr: `unknown stage "missing"`,
+		},
+		{
+			name:    "missing file",
+			config:  base + "versions-file: versions.yaml\n",
+			files:   fstest.MapFS{},
+			wantErr: "reading project/versions.yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.files["project/dfo.yaml"] = &fstest.MapFile{Data: []byte(tt.config)}
+
+			cfg, err := Load(tt.files, "project/dfo.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if !maps.Equal(cfg.Versions, tt.wantVersions) {
+				t.Errorf("Versions = %v, want %v", cfg.Versions, tt.wantVersions)
+			}
+			if !slices.Equal(cfg.Stages[0].Environment.Packages, tt.wantPackages) {
+				t.Errorf("Packages = %v, want %v", cfg.Stages[0].Environment.Packages, tt.wantPackages)
+			}
+		})
+	}
+}
+
+func TestParseRejectsExternalFiles(t *testing.T) {
+	_, err := Parse([]byte("package:\n  name: app\nversions-file: versions.yaml\nstages:\n  - name: build\n    environment:\n      base-image: alpine\n"))
+	if err == nil {
+		t.Error("Parse() expected error for versions-file without a config path")
+	}
+}
+
+func TestTemplateVolumeAndStopSignal(t *testing.T) {
+	cfg, err := Parse([]byte(`package:
+  name: app
+stages:
+  - template: go-app
+    with:
+      repo: https://github.com/owner/app
+      binary: app
+      stopsignal: SIGINT
+      volumes:
+        - path: /data
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	final := cfg.Stages[len(cfg.Stages)-1]
+	if !slices.Equal(final.Environment.Volume, []string{"/data"}) {
+		t.Errorf("Volume = %v, want [/data]", final.Environment.Volume)
+	}
+	if final.Environment.StopSignal != "SIGINT" {
+		t.Errorf("StopSignal = %q, want %q", final.Environment.StopSignal, "SIGINT")
+	}
+}
+
+func TestTemplateLabels(t *testing.T) {
+	cfg, err := Parse([]byte(`package:
+  name: app
+  labels:
+    org.opencontainers.image.title: custom
+stages:
+  - template: go-app
+    with:
+      repo: https://github.com/owner/app
+      binary: app
+      labels:
+        org.opencontainers.image.source: https://github.com/owner/app
+        org.opencontainers.image.title: app
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	expected := map[string]string{
+		"org.opencontainers.image.source": "https://github.com/owner/app",
+		"org.opencontainers.image.title":  "custom",
+	}
+	if !maps.Equal(cfg.Package.Labels, expected) {
+		t.Errorf("Package.Labels = %v, want %v", cfg.Package.Labels, expected)
+	}
+}
@@ -1,11 +1,21 @@
 package config
 
 type BuildConfig struct {
-	Package     Package           `yaml:"package"`
-	Stages      []Stage           `yaml:"stages,omitempty"`
-	Environment Environment       `yaml:"environment"`
-	Vars        map[string]string `yaml:"vars,omitempty"`
-	Versions    map[string]string `yaml:"versions,omitempty"`
+	Package           Package           `yaml:"package"`
+	Stages            []Stage           `yaml:"stages,omitempty"`
+	Environment       Environment       `yaml:"environment"`
+	Vars              map[string]string `yaml:"vars,omitempty"`
+	Versions          map[string]string `yaml:"versions,omitempty"`
+	VersionsFile      string            `yaml:"versions-file,omitempty"`
+	PackagesFile      string            `yaml:"packages-file,omitempty"`
+	FailFast          bool              `yaml:"fail-fast,omitempty"`
+	Registry          string            `yaml:"registry,omitempty"`
+	ApkArch           string            `yaml:"apk-arch,omitempty"`
+	ApkSnapshot       string            `yaml:"apk-snapshot,omitempty"`
+	ApkSnapshotMirror string            `yaml:"apk-snapshot-mirror,omitempty"`
+	DefaultUser       string            `yaml:"default-user,omitempty"`
+	StageBOM          bool              `yaml:"stage-bom,omitempty"`
+	Hadolint          bool              `yaml:"hadolint,omitempty"`
 }
 
 type Stage struct {
@@ -14,6 +24,10 @@ type Stage struct {
 	With        map[string]any `yaml:"with,omitempty"`
 	Environment Environment    `yaml:"environment,omitempty"`
 	Pipeline    []PipelineStep `yaml:"pipeline,omitempty"`
+	// Raw lines are emitted verbatim at the end of the stage and bypass all validation.
+	Raw             []string `yaml:"raw,omitempty"`
+	BasePassthrough bool     `yaml:"base-passthrough,omitempty"`
+	DependsOn       []string `yaml:"depends-on,omitempty"`
 }
 
 type Package struct {
@@ -30,29 +44,58 @@ type Environment struct {
 	Packages       []string          `yaml:"packages,omitempty"`
 	RootfsPackages []string          `yaml:"rootfs-packages,omitempty"`
 	Environment    map[string]string `yaml:"environment,omitempty"`
+	Expand         []string          `yaml:"expand,omitempty"`
 	WorkDir        string            `yaml:"workdir,omitempty"`
 	User           string            `yaml:"user,omitempty"`
+	Shell          []string          `yaml:"shell,omitempty"`
 	Entrypoint     []string          `yaml:"entrypoint,omitempty"`
 	Cmd            []string          `yaml:"cmd,omitempty"`
 	Expose         []string          `yaml:"expose,omitempty"`
 	Volume         []string          `yaml:"volume,omitempty"`
 	StopSignal     string            `yaml:"stopsignal,omitempty"`
+	Init           string            `yaml:"init,omitempty"`
+	DropPrivileges *DropPrivileges   `yaml:"drop-privileges,omitempty"`
+	HealthCheck    *HealthCheck      `yaml:"healthcheck,omitempty"`
+}
+
+type DropPrivileges struct {
+	User  string   `yaml:"user"`
+	Setup []string `yaml:"setup,omitempty"`
+}
+
+type HealthCheck struct {
+	Test        []string `yaml:"test,omitempty"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	StartPeriod string   `yaml:"start-period,omitempty"`
+	Retries     int      `yaml:"retries,omitempty"`
+	Disable     bool     `yaml:"disable,omitempty"`
 }
 
 type PipelineStep struct {
-	Name      string         `yaml:"name,omitempty"`
-	Uses      string         `yaml:"uses,omitempty"`
-	Run       string         `yaml:"run,omitempty"`
-	BuildDeps []string       `yaml:"build-deps,omitempty"`
-	Fetch     *FetchStep     `yaml:"fetch,omitempty"`
-	Copy      *CopyStep      `yaml:"copy,omitempty"`
-	With      map[string]any `yaml:"with,omitempty"`
+	Name        string         `yaml:"name,omitempty"`
+	Uses        string         `yaml:"uses,omitempty"`
+	Run         string         `yaml:"run,omitempty"`
+	BuildDeps   []string       `yaml:"build-deps,omitempty"`
+	CacheMounts []CacheMount   `yaml:"cache-mounts,omitempty"`
+	Secrets     []string       `yaml:"secrets,omitempty"`
+	FailFast    bool           `yaml:"fail-fast,omitempty"`
+	FinalOnly   bool           `yaml:"final-only,omitempty"`
+	Fetch       *FetchStep     `yaml:"fetch,omitempty"`
+	Copy        *CopyStep      `yaml:"copy,omitempty"`
+	With        map[string]any `yaml:"with,omitempty"`
+}
+
+type CacheMount struct {
+	Target string `yaml:"target"`
+	ID     string `yaml:"id,omitempty"`
 }
 
 type FetchStep struct {
 	URL         string `yaml:"url"`
 	Destination string `yaml:"destination,omitempty"`
 	Extract     bool   `yaml:"extract,omitempty"`
+	Retries     int    `yaml:"retries,omitempty"`
 }
 
 type CopyStep struct {
@@ -60,6 +103,15 @@ type CopyStep struct {
 	From      string `yaml:"from"`
 	To        string `yaml:"to"`
 	Chown     string `yaml:"chown,omitempty"`
+	Chmod     string `yaml:"chmod,omitempty"`
+	Link      bool   `yaml:"link,omitempty"`
+}
+
+func (s Stage) IsEmpty() bool {
+	env := s.Environment
+	env.BaseImage = ""
+	env.ExternalImage = ""
+	return env.IsEmpty() && len(s.Pipeline) == 0 && len(s.Raw) == 0
 }
 
 func (e Environment) IsEmpty() bool {
@@ -71,9 +123,23 @@ func (e Environment) IsEmpty() bool {
 		len(e.Environment) == 0 &&
 		e.WorkDir == "" &&
 		e.User == "" &&
+		len(e.Shell) == 0 &&
 		len(e.Entrypoint) == 0 &&
 		len(e.Cmd) == 0 &&
 		len(e.Expose) == 0 &&
 		len(e.Volume) == 0 &&
-		e.StopSignal == ""
+		e.StopSignal == "" &&
+		e.Init == "" &&
+		e.DropPrivileges == nil &&
+		e.HealthCheck == nil
+}
+
+type InitSystem struct {
+	Package    string
+	Entrypoint []string
+}
+
+var InitSystems = map[string]InitSystem{
+	"tini":      {Package: "tini", Entrypoint: []string{"/sbin/tini", "--"}},
+	"dumb-init": {Package: "dumb-init", Entrypoint: []string{"/usr/bin/dumb-init", "--"}},
 }
@@ -0,0 +1,107 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"strings"
+
+	"github.com/greboid/dfo/pkg/util"
+)
+
+const (
+	attestationBuilderID = "https://github.com/greboid/dfo"
+	attestationSuffix    = ".provenance.json"
+)
+
+// AttestationPredicate is a SLSA v0.2 provenance predicate. Only the predicate
+// is written, as cosign attest --predicate expects: cosign wraps it in an
+// in-toto statement whose subject is the digest of the image being attested,
+// which is not known until the image has been built.
+type AttestationPredicate struct {
+	Builder     AttestationBuilder     `json:"builder"`
+	BuildType   string                 `json:"buildType"`
+	BuildConfig AttestationBuildConfig `json:"buildConfig"`
+	Materials   []AttestationMaterial  `json:"materials"`
+}
+
+// AttestationBuildConfig records the resolved image digests and package
+// versions the Containerfile was generated from.
+type AttestationBuildConfig struct {
+	Images   map[string]string `json:"images,omitempty"`
+	Packages map[string]string `json:"packages,omitempty"`
+}
+
+type AttestationBuilder struct {
+	ID string `json:"id"`
+}
+
+type AttestationMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+func (g *Generator) SetAttestation(enabled bool) {
+	g.attestation = enabled
+}
+
+// attestationPath flattens package names such as org/app so the attestation
+// is written alongside the Containerfile rather than in a subdirectory.
+func (g *Generator) attestationPath() string {
+	name := strings.ReplaceAll(g.config.Package.Name, "/", "-")
+	return path.Join(g.outputDir, name+attestationSuffix)
+}
+
+func (g *Generator) buildAttestation() AttestationPredicate {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	buildConfig := AttestationBuildConfig{
+		Images:   make(map[string]string, len(g.resolvedImages)+len(g.builtImages)),
+		Packages: maps.Clone(g.resolvedPackages),
+	}
+	maps.Copy(buildConfig.Images, g.resolvedImages)
+	maps.Copy(buildConfig.Images, g.builtImages)
+
+	var materials []AttestationMaterial
+	for _, image := range util.SortedKeys(buildConfig.Images) {
+		materials = append(materials, imageMaterial(image, buildConfig.Images[image]))
+	}
+	for _, pkg := range util.SortedKeys(buildConfig.Packages) {
+		materials = append(materials, AttestationMaterial{
+			URI: fmt.Sprintf("pkg:apk/alpine/%s@%s", pkg, buildConfig.Packages[pkg]),
+		})
+	}
+
+	return AttestationPredicate{
+		Builder:     AttestationBuilder{ID: attestationBuilderID},
+		BuildType:   attestationBuilderID,
+		BuildConfig: buildConfig,
+		Materials:   materials,
+	}
+}
+
+func imageMaterial(image, digest string) AttestationMaterial {
+	material := AttestationMaterial{URI: "docker://" + image}
+	if algorithm, value, ok := strings.Cut(digest, ":"); ok {
+		material.Digest = map[string]string{algorithm: value}
+	}
+	return material
+}
+
+func (g *Generator) writeAttestation() error {
+	if !g.attestation {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(g.buildAttestation(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling attestation: %w", err)
+	}
+
+	if err := g.fs.WriteFile(g.attestationPath(), data, filePerms); err != nil {
+		return fmt.Errorf("writing attestation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package generator
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/greboid/dfo/pkg/config"
+	"github.com/greboid/dfo/pkg/util"
+)
+
+func TestGenerateAttestation(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "tools/app"},
+		Stages: []config.Stage{
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Run: "make"}},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	g := New(cfg, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+	g.SetAttestation(true)
+	g.resolvedImages["alpine:3.20"] = "sha256:fedcba9876543210"
+	g.resolvedPackages["musl"] = "1.2.5-r0"
+
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "tools-app.provenance.json"))
+	if err != nil {
+		t.Fatalf("reading attestation: %v", err)
+	}
+
+	// cosign attest --predicate adds the statement and its subject.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("parsing attestation: %v", err)
+	}
+	for _, key := range []string{"_type", "subject", "predicateType", "predicate"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("attestation has statement field %q, want only the predicate", key)
+		}
+	}
+
+	var predicate AttestationPredicate
+	if err := json.Unmarshal(data, &predicate); err != nil {
+		t.Fatalf("parsing attestation: %v", err)
+	}
+
+	if predicate.Builder.ID != attestationBuilderID {
+		t.Errorf("builder.id = %q, want %q", predicate.Builder.ID, attestationBuilderID)
+	}
+
+	expectedImages := map[string]string{
+		"alpine:3.20": "sha256:fedcba9876543210",
+		"base":        "sha256:0123456789abcdef",
+	}
+	if !maps.Equal(predicate.BuildConfig.Images, expectedImages) {
+		t.Errorf("buildConfig.images = %v, want %v", predicate.BuildConfig.Images, expectedImages)
+	}
+	expectedPackages := map[string]string{"musl": "1.2.5-r0"}
+	if !maps.Equal(predicate.BuildConfig.Packages, expectedPackages) {
+		t.Errorf("buildConfig.packages = %v, want %v", predicate.BuildConfig.Packages, expectedPackages)
+	}
+
+	expectedMaterials := []AttestationMaterial{
+		{URI: "docker://alpine:3.20", Digest: map[string]string{"sha256": "fedcba9876543210"}},
+		{URI: "docker://base", Digest: map[string]string{"sha256": "0123456789abcdef"}},
+		{URI: "pkg:apk/alpine/musl@1.2.5-r0"},
+	}
+	materialsEqual := slices.EqualFunc(predicate.Materials, expectedMaterials, func(a, b AttestationMaterial) bool {
+		return a.URI == b.URI && maps.Equal(a.Digest, b.Digest)
+	})
+	if !materialsEqual {
+		t.Errorf("materials = %+v, want %+v", predicate.Materials, expectedMaterials)
+	}
+}
+
+func TestGenerateWithoutAttestation(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Run: "make"}},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	g := New(cfg, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "app.provenance.json")); !os.IsNotExist(err) {
+		t.Errorf("attestation written without SetAttestation: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/greboid/dfo/pkg/util"
+)
+
+const (
+	lockFilename            = "dfo.lock"
+	checksumDownloadTimeout = 5 * time.Minute
+)
+
+var checksumHTTPClient = &http.Client{Timeout: checksumDownloadTimeout}
+
+type Downloader func(url string) (io.ReadCloser, error)
+
+type LockFile struct {
+	Version   int               `json:"version"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+func httpDownload(url string) (io.ReadCloser, error) {
+	resp, err := checksumHTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (g *Generator) SetComputeChecksums(enabled bool) {
+	g.computeChecksums = enabled
+}
+
+func (g *Generator) SetDownloader(downloader Downloader) {
+	g.downloader = downloader
+}
+
+func (g *Generator) withComputedChecksum(pipelineName string, with map[string]any) (map[string]any, error) {
+	if !g.computeChecksums || g.plan || pipelineName != "download-verify-extract" {
+		return with, nil
+	}
+	for _, key := range []string{"checksum", "checksum-url", "checksums"} {
+		if _, ok := with[key]; ok {
+			return with, nil
+		}
+	}
+	if algorithm, ok := with["checksum-algorithm"].(string); ok && algorithm != "sha256" {
+		return with, nil
+	}
+
+	rawURL, ok := with["url"].(string)
+	if !ok {
+		return with, nil
+	}
+	url, err := util.ExpandVarsStrict(rawURL, g.buildVarsMap(), "")
+	if err != nil {
+		return nil, fmt.Errorf("expanding url for checksum: %w", err)
+	}
+
+	checksum, err := g.computeChecksum(url)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]any, len(with)+1)
+	for key, value := range with {
+		result[key] = value
+	}
+	result["checksum"] = checksum
+	return result, nil
+}
+
+func (g *Generator) computeChecksum(url string) (string, error) {
+	if err := g.loadLockFile(); err != nil {
+		return "", err
+	}
+
+	if checksum, ok := g.lockFile.Checksums[url]; ok {
+		slog.Debug("using locked checksum", "url", url, "checksum", checksum)
+		return checksum, nil
+	}
+
+	slog.Info("computing checksum", "url", url)
+
+	downloader := g.downloader
+	if downloader == nil {
+		downloader = httpDownload
+	}
+
+	body, err := downloader(url)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s for checksum: %w", url, err)
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", url, err)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	g.lockFile.Checksums[url] = checksum
+	g.lockFileDirty = true
+	return checksum, nil
+}
+
+func (g *Generator) lockFilePath() string {
+	return path.Join(g.outputDir, lockFilename)
+}
+
+func (g *Generator) loadLockFile() error {
+	if g.lockFile != nil {
+		return nil
+	}
+
+	g.lockFile = &LockFile{Version: 1, Checksums: make(map[string]string)}
+
+	data, err := g.fs.ReadFile(g.lockFilePath())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("reading lock file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, g.lockFile); err != nil {
+		return fmt.Errorf("parsing lock file: %w", err)
+	}
+	if g.lockFile.Checksums == nil {
+		g.lockFile.Checksums = make(map[string]string)
+	}
+	return nil
+}
+
+func (g *Generator) saveLockFile() error {
+	if g.lockFile == nil || !g.lockFileDirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(g.lockFile, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling lock file: %w", err)
+	}
+
+	if err := g.fs.WriteFile(g.lockFilePath(), data, filePerms); err != nil {
+		return fmt.Errorf("writing lock file: %w", err)
+	}
+
+	g.lockFileDirty = false
+	return nil
+}
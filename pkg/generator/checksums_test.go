@@ -0,0 +1,190 @@
+package generator
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greboid/dfo/pkg/config"
+	"github.com/greboid/dfo/pkg/util"
+)
+
+const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestComputedChecksums(t *testing.T) {
+	tests := []struct {
+		name          string
+		lock          string
+		with          map[string]any
+		expected      string
+		notExpected   string
+		expectedCalls int
+	}{
+		{
+			name:          "computes missing checksum",
+			with:          map[string]any{"url": "https://example.com/app.tar.gz", "destination": "/tmp/app.tar.gz"},
+			expected:      helloSHA256,
+			expectedCalls: 1,
+		},
+		{
+			name:          "uses locked checksum",
+			lock:          `{"version":1,"checksums":{"https://example.com/app.tar.gz":"locked"}}`,
+			with:          map[string]any{"url": "https://example.com/app.tar.gz", "destination": "/tmp/app.tar.gz"},
+			expected:      "locked",
+			expectedCalls: 0,
+		},
+		{
+			name:          "explicit checksum is kept",
+			with:          map[string]any{"url": "https://example.com/app.tar.gz", "destination": "/tmp/app.tar.gz", "checksum": "explicit"},
+			expected:      "explicit",
+			notExpected:   helloSHA256,
+			expectedCalls: 0,
+		},
+		{
+			name:          "checksums list is kept",
+			with:          map[string]any{"url": "https://example.com/app.tar.gz", "destination": "/tmp/app.tar.gz", "checksums": []any{"a", "b"}},
+			notExpected:   helloSHA256,
+			expectedCalls: 0,
+		},
+		{
+			name:          "other algorithms are skipped",
+			with:          map[string]any{"url": "https://example.com/app.tar.gz", "destination": "/tmp/app.tar.gz", "checksum": "b2", "checksum-algorithm": "blake2"},
+			notExpected:   helloSHA256,
+			expectedCalls: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.lock != "" {
+				if err := os.WriteFile(filepath.Join(dir, lockFilename), []byte(tt.lock), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			calls := 0
+			g := New(&config.BuildConfig{}, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+			g.SetComputeChecksums(true)
+			g.SetDownloader(func(string) (io.ReadCloser, error) {
+				calls++
+				return io.NopCloser(strings.NewReader("hello")), nil
+			})
+
+			result, err := g.withComputedChecksum("download-verify-extract", tt.with)
+			if err != nil {
+				t.Fatalf("withComputedChecksum() error = %v", err)
+			}
+			if tt.expected != "" && result["checksum"] != tt.expected {
+				t.Errorf("checksum = %v, want %q", result["checksum"], tt.expected)
+			}
+			if tt.notExpected != "" && result["checksum"] == tt.notExpected {
+				t.Errorf("checksum = %v, should not be %q", result["checksum"], tt.notExpected)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("downloader called %d times, want %d", calls, tt.expectedCalls)
+			}
+		})
+	}
+}
+
+func TestComputedChecksumsWithChecksumsList(t *testing.T) {
+	g := New(&config.BuildConfig{}, t.TempDir(), util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetComputeChecksums(true)
+	g.SetDownloader(func(string) (io.ReadCloser, error) {
+		t.Fatal("downloader should not be called")
+		return nil, nil
+	})
+
+	result, err := g.runPipeline(config.PipelineStep{
+		Uses: "download-verify-extract",
+		With: map[string]any{
+			"url":         "https://example.com/app.tar.gz",
+			"destination": "/tmp/app.tar.gz",
+			"checksums":   []any{"abc123", "def456"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("runPipeline() error = %v", err)
+	}
+	if content := result.Steps[0].Content; !strings.Contains(content, `echo "def456  /tmp/app.tar.gz" | sha256sum -c`) {
+		t.Errorf("content missing checksums verification:\n%s", content)
+	}
+}
+
+func TestComputedChecksumsDisabled(t *testing.T) {
+	g := New(&config.BuildConfig{}, t.TempDir(), util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetDownloader(func(string) (io.ReadCloser, error) {
+		t.Fatal("downloader should not be called")
+		return nil, nil
+	})
+
+	with := map[string]any{"url": "https://example.com/app.tar.gz", "destination": "/tmp/app.tar.gz"}
+	result, err := g.withComputedChecksum("download-verify-extract", with)
+	if err != nil {
+		t.Fatalf("withComputedChecksum() error = %v", err)
+	}
+	if _, ok := result["checksum"]; ok {
+		t.Errorf("withComputedChecksum() = %v, want no checksum", result)
+	}
+}
+
+func TestComputedChecksumsLockFile(t *testing.T) {
+	dir := t.TempDir()
+	g := New(&config.BuildConfig{}, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetComputeChecksums(true)
+	g.SetDownloader(func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("hello")), nil
+	})
+
+	if _, err := g.computeChecksum("https://example.com/app.tar.gz"); err != nil {
+		t.Fatalf("computeChecksum() error = %v", err)
+	}
+	if err := g.saveLockFile(); err != nil {
+		t.Fatalf("saveLockFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, lockFilename))
+	if err != nil {
+		t.Fatalf("reading lock file: %v", err)
+	}
+	if !strings.Contains(string(data), helloSHA256) {
+		t.Errorf("lock file = %s, want to contain %s", data, helloSHA256)
+	}
+}
+
+func TestComputedChecksumsDownloadError(t *testing.T) {
+	g := New(&config.BuildConfig{}, t.TempDir(), util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetComputeChecksums(true)
+	g.SetDownloader(func(string) (io.ReadCloser, error) {
+		return nil, errors.New("boom")
+	})
+
+	_, err := g.computeChecksum("https://example.com/app.tar.gz")
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("computeChecksum() error = %v, want to contain boom", err)
+	}
+}
+
+func TestHTTPDownloadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	original := checksumHTTPClient
+	checksumHTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+	defer func() { checksumHTTPClient = original }()
+
+	if _, err := httpDownload(server.URL); err == nil {
+		t.Error("httpDownload() expected timeout error from stalled server")
+	}
+}
@@ -2,13 +2,17 @@ package generator
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"path"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/greboid/dfo/pkg/config"
 	"github.com/greboid/dfo/pkg/images"
@@ -21,30 +25,72 @@ import (
 const (
 	dirPerms  = 0755
 	filePerms = 0644
+
+	defaultFetchDestination  = "/tmp/download"
+	bomHashPlaceholder       = "@DFO_BOM_HASH@"
+	dropPrivilegesEntrypoint = "/usr/local/bin/dfo-entrypoint"
+	buildkitSyntax           = "docker/dockerfile:1"
+	sharedBuildDepsGroup     = ".shared-build-deps"
 )
 
+var dropPrivilegesPackages = []string{"busybox", "su-exec"}
+
+type Stats struct {
+	Versions         int
+	Images           int
+	Packages         int
+	VersionsDuration time.Duration
+	ImagesDuration   time.Duration
+	PackagesDuration time.Duration
+	TotalDuration    time.Duration
+}
+
 type Generator struct {
 	config           *config.BuildConfig
 	outputDir        string
 	outputFilename   string
+	syntaxDirective  string
+	runIndent        string
 	fs               util.WritableFS
 	resolver         *packages.Resolver
 	versionResolver  *versions.Resolver
 	imageResolver    *images.Resolver
 	resolvedVersions map[string]versions.VersionMetadata
 	resolvedPackages map[string]string
+	packageStages    map[string][]string
+	currentStage     string
 	resolvedImages   map[string]string
+	seenImages       map[string]bool
 	builtImages      map[string]string
 	localImageNames  map[string]bool
+	shellOptions     bool
+	strict           bool
+	digestOnly       bool
+	computeChecksums bool
+	attestation      bool
+	plan             bool
+	onlyStage        string
+	downloader       Downloader
+	lockFile         *LockFile
+	lockFileDirty    bool
+	stats            Stats
 	mu               sync.Mutex
 }
 
 func New(cfg *config.BuildConfig, outputDir string, fs util.WritableFS, alpineClient *packages.AlpineClient, alpineVersion, gitUser, gitPass, registry string, sharedImageResolver *images.Resolver) *Generator {
 	resolver := packages.NewResolver(alpineClient, alpineVersion)
+	if cfg.ApkArch != "" {
+		resolver.SetArch(cfg.ApkArch)
+	}
+	if cfg.ApkSnapshot != "" {
+		resolver.SetSnapshot(cfg.ApkSnapshotURL())
+	}
 	versionResolver := versions.New(context.Background(), gitUser, gitPass)
 
 	var imageResolver *images.Resolver
-	if sharedImageResolver != nil {
+	if cfg.Registry != "" && cfg.Registry != registry {
+		imageResolver = images.NewResolver(cfg.Registry, false)
+	} else if sharedImageResolver != nil {
 		imageResolver = sharedImageResolver
 	} else {
 		imageResolver = images.NewResolver(registry, false)
@@ -54,29 +100,64 @@ func New(cfg *config.BuildConfig, outputDir string, fs util.WritableFS, alpineCl
 		config:           cfg,
 		outputDir:        outputDir,
 		outputFilename:   "Containerfile",
+		runIndent:        util.RunIndent,
 		fs:               fs,
 		resolver:         resolver,
 		versionResolver:  versionResolver,
 		imageResolver:    imageResolver,
 		resolvedVersions: make(map[string]versions.VersionMetadata),
 		resolvedPackages: make(map[string]string),
+		packageStages:    make(map[string][]string),
 		resolvedImages:   make(map[string]string),
+		seenImages:       make(map[string]bool),
 		builtImages:      make(map[string]string),
 		localImageNames:  make(map[string]bool),
 	}
 }
 
-func buildFetchCommand(url, dest string, extract bool) string {
+func buildFetchCommand(url, dest string, extract bool, retries int) string {
+	curl := "curl " + util.CurlFlags(retries)
 	if extract {
-		return util.WrapRun(fmt.Sprintf("curl -fsSL %q | tar -xz -C %q", url, dest))
+		return util.WrapRun(fmt.Sprintf("%s %q | tar -xz -C %q", curl, url, dest))
 	}
-	return util.WrapRun(fmt.Sprintf("curl -fsSL -o %s %q", dest, url))
+	return util.WrapRun(fmt.Sprintf("%s -o %s %q", curl, dest, url))
 }
 
 func (g *Generator) SetOutputFilename(filename string) {
 	g.outputFilename = filename
 }
 
+func (g *Generator) SetSyntaxDirective(syntax string) {
+	g.syntaxDirective = syntax
+}
+
+func (g *Generator) SetRunIndent(indent string) {
+	g.runIndent = indent
+}
+
+func (g *Generator) indent() string {
+	if g.runIndent == "" {
+		return util.RunIndent
+	}
+	return g.runIndent
+}
+
+func (g *Generator) SetShellOptions(enabled bool) {
+	g.shellOptions = enabled
+}
+
+func (g *Generator) SetStrict(strict bool) {
+	g.strict = strict
+}
+
+func (g *Generator) SetDigestOnly(digestOnly bool) {
+	g.digestOnly = digestOnly
+}
+
+func (g *Generator) SetOnlyStage(name string) {
+	g.onlyStage = name
+}
+
 func (g *Generator) SetBuiltImages(builtImages map[string]string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -112,6 +193,13 @@ func (g *Generator) resolveVersions() error {
 	semaphore := make(chan struct{}, maxConcurrency)
 	var wg sync.WaitGroup
 
+	if g.plan {
+		for key := range g.config.Versions {
+			g.resolvedVersions[key] = plannedVersion()
+		}
+		return nil
+	}
+
 	for key, value := range g.config.Versions {
 		wg.Go(func() {
 			semaphore <- struct{}{}
@@ -138,7 +226,40 @@ func (g *Generator) resolveVersions() error {
 	return nil
 }
 
+func (g *Generator) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := g.stats
+	stats.Versions = len(g.resolvedVersions)
+	stats.Packages = len(g.resolvedPackages)
+	stats.Images = len(g.seenImages)
+	return stats
+}
+
+func (g *Generator) BOM() map[string]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.collectBOMEntries()
+}
+
 func (g *Generator) resolveImage(imageName string) (*images.ResolvedImage, error) {
+	start := time.Now()
+	resolved, err := g.lookupImage(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	g.seenImages[resolved.FullRef] = true
+	g.stats.ImagesDuration += time.Since(start)
+	g.mu.Unlock()
+
+	return resolved, nil
+}
+
+func (g *Generator) lookupImage(imageName string) (*images.ResolvedImage, error) {
 	if resolved, ok := g.tryGetBuiltImage(imageName); ok {
 		return resolved, nil
 	}
@@ -226,6 +347,10 @@ func (g *Generator) validateLocalImageStatus(imageName string) error {
 }
 
 func (g *Generator) resolveExternalImage(imageName string) (*images.ResolvedImage, error) {
+	if g.plan {
+		return plannedImage(imageName), nil
+	}
+
 	slog.Debug("Resolving external image from registry", "image", imageName)
 
 	resolved, err := g.imageResolver.Resolve(context.Background(), imageName)
@@ -233,6 +358,10 @@ func (g *Generator) resolveExternalImage(imageName string) (*images.ResolvedImag
 		return nil, fmt.Errorf("resolving external image %q from registry: %w", imageName, err)
 	}
 
+	g.mu.Lock()
+	g.resolvedImages[imageName] = resolved.Digest
+	g.mu.Unlock()
+
 	return resolved, nil
 }
 
@@ -264,20 +393,74 @@ func (g *Generator) resolvePackages(pkgSpecs []string) ([]packages.ResolvedPacka
 		return nil, fmt.Errorf("parsing package specs: %w", err)
 	}
 
-	resolved, err := g.resolver.Resolve(specs)
-	if err != nil {
-		return nil, err
+	start := time.Now()
+	var resolved []packages.ResolvedPackage
+	if g.plan {
+		resolved = plannedPackages(specs)
+	} else {
+		resolved, err = g.resolver.Resolve(specs)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	g.mu.Lock()
+	g.stats.PackagesDuration += time.Since(start)
 	for _, pkg := range resolved {
 		g.resolvedPackages[pkg.Name] = pkg.Version
+		if g.currentStage != "" && !slices.Contains(g.packageStages[pkg.Name], g.currentStage) {
+			g.packageStages[pkg.Name] = append(g.packageStages[pkg.Name], g.currentStage)
+		}
 	}
 	g.mu.Unlock()
 
 	return resolved, nil
 }
 
+type PackageResolution struct {
+	Stage   string
+	Spec    string
+	Version string
+	Repo    string
+}
+
+func (g *Generator) ResolvePackageSpecs() ([]PackageResolution, error) {
+	var result []PackageResolution
+	for _, stage := range g.config.Stages {
+		specs := append(slices.Clone(stage.Environment.Packages), stage.Environment.RootfsPackages...)
+		common, byArch, err := packages.GroupByArch(specs)
+		if err != nil {
+			return nil, fmt.Errorf("stage %q: %w", stage.Name, err)
+		}
+		requested := append(common, byArch[g.resolver.Arch()]...)
+		if len(requested) == 0 {
+			continue
+		}
+
+		resolved, err := g.resolvePackages(requested)
+		if err != nil {
+			return nil, fmt.Errorf("stage %q: resolving packages: %w", stage.Name, err)
+		}
+		result = append(result, matchRequestedPackages(stage.Name, requested, resolved)...)
+	}
+	return result, nil
+}
+
+func matchRequestedPackages(stage string, requested []string, resolved []packages.ResolvedPackage) []PackageResolution {
+	byName := make(map[string]packages.ResolvedPackage, len(resolved))
+	for _, pkg := range resolved {
+		byName[pkg.Name] = pkg
+	}
+
+	result := make([]PackageResolution, 0, len(requested))
+	for _, spec := range requested {
+		name, _, _ := strings.Cut(spec, "=")
+		pkg := byName[name]
+		result = append(result, PackageResolution{Stage: stage, Spec: spec, Version: pkg.Version, Repo: pkg.Repo})
+	}
+	return result
+}
+
 func (g *Generator) resolveAndFormatPackages(pkgSpecs []string, firstIndent bool, indent string) (string, error) {
 	resolved, err := g.resolvePackages(pkgSpecs)
 	if err != nil {
@@ -300,12 +483,22 @@ func (g *Generator) resolveAndFormatPackages(pkgSpecs []string, firstIndent bool
 }
 
 func (g *Generator) Generate() error {
+	start := time.Now()
+	defer func() {
+		g.mu.Lock()
+		g.stats.TotalDuration = time.Since(start)
+		g.mu.Unlock()
+	}()
+
 	if err := g.resolveVersions(); err != nil {
 		return fmt.Errorf("resolving versions: %w", err)
 	}
+	g.mu.Lock()
+	g.stats.VersionsDuration = time.Since(start)
+	g.mu.Unlock()
 
-	if err := g.validateVariableReferences(); err != nil {
-		return fmt.Errorf("variable validation: %w", err)
+	if err := g.validate(); err != nil {
+		return err
 	}
 
 	if err := g.fs.MkdirAll(g.outputDir, dirPerms); err != nil {
@@ -316,13 +509,65 @@ func (g *Generator) Generate() error {
 		return fmt.Errorf("generating Dockerfile: %w", err)
 	}
 
+	if err := g.saveLockFile(); err != nil {
+		return fmt.Errorf("saving lock file: %w", err)
+	}
+
+	if err := g.writeAttestation(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (g *Generator) validate() error {
+	if g.onlyStage != "" && !slices.ContainsFunc(g.config.Stages, func(stage config.Stage) bool { return stage.Name == g.onlyStage }) {
+		return fmt.Errorf("only-stage %q does not match any stage", g.onlyStage)
+	}
+
+	if err := g.validateVariableReferences(); err != nil {
+		return fmt.Errorf("variable validation: %w", err)
+	}
+
+	if err := g.validateWorkDirs(); err != nil {
+		return fmt.Errorf("workdir validation: %w", err)
+	}
+
+	if err := g.validateFetchDestinations(); err != nil {
+		return fmt.Errorf("fetch validation: %w", err)
+	}
+
+	if err := g.validateEmptyStages(); err != nil {
+		return fmt.Errorf("stage validation: %w", err)
+	}
+
+	if err := g.validateScratchFinalStage(); err != nil {
+		return fmt.Errorf("stage validation: %w", err)
+	}
+
+	if err := g.validateCopyTrailingSlashes(); err != nil {
+		return fmt.Errorf("copy validation: %w", err)
+	}
+
 	return nil
 }
 
 func (g *Generator) validateVariableReferences() error {
 	vars := g.buildVarsMap()
 
+	for _, key := range util.SortedKeys(g.config.Package.Labels) {
+		if err := util.ValidateVariableReferences(g.config.Package.Labels[key], vars, fmt.Sprintf("label %q", key)); err != nil {
+			return err
+		}
+	}
+
 	for _, stage := range g.config.Stages {
+		for _, key := range util.SortedKeys(stage.Environment.Args) {
+			if err := util.ValidateVariableReferences(stage.Environment.Args[key], vars, fmt.Sprintf("stage %q arg %q", stage.Name, key)); err != nil {
+				return err
+			}
+		}
+
 		for i, step := range stage.Pipeline {
 			stepContext := fmt.Sprintf("stage %q step %d", stage.Name, i+1)
 			if step.Name != "" {
@@ -345,34 +590,214 @@ func (g *Generator) validateVariableReferences() error {
 	return nil
 }
 
+func (g *Generator) validateWorkDirs() error {
+	vars := g.buildVarsMap()
+
+	for _, stage := range g.config.Stages {
+		if err := g.validateWorkDir(stage.Environment.WorkDir, vars, fmt.Sprintf("stage %q", stage.Name)); err != nil {
+			return err
+		}
+
+		for i, step := range stage.Pipeline {
+			workdir, ok := step.With["workdir"].(string)
+			if !ok {
+				continue
+			}
+			stepContext := fmt.Sprintf("stage %q step %d", stage.Name, i+1)
+			if step.Name != "" {
+				stepContext = fmt.Sprintf("stage %q step %q", stage.Name, step.Name)
+			}
+			if err := g.validateWorkDir(workdir, vars, stepContext); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *Generator) validateWorkDir(workdir string, vars map[string]string, context string) error {
+	if workdir == "" {
+		return nil
+	}
+	expanded := util.ExpandVars(workdir, vars)
+	if path.IsAbs(expanded) || strings.HasPrefix(expanded, "$") {
+		return nil
+	}
+	return g.warn("%s: workdir %q is not an absolute path", context, workdir)
+}
+
+func (g *Generator) validateFetchDestinations() error {
+	vars := g.buildVarsMap()
+
+	for _, stage := range g.config.Stages {
+		seen := make(map[string]string)
+		for i, step := range stage.Pipeline {
+			dest, ok := fetchDestination(step)
+			if !ok {
+				continue
+			}
+			dest = path.Clean(util.ExpandVars(dest, vars))
+
+			stepContext := fmt.Sprintf("step %d", i+1)
+			if step.Name != "" {
+				stepContext = fmt.Sprintf("step %q", step.Name)
+			}
+			if previous, exists := seen[dest]; exists {
+				if err := g.warn("stage %q: %s and %s both download to %q", stage.Name, previous, stepContext, dest); err != nil {
+					return err
+				}
+				continue
+			}
+			seen[dest] = stepContext
+		}
+	}
+	return nil
+}
+
+func (g *Generator) validateEmptyStages() error {
+	for i, stage := range g.config.Stages {
+		if stage.BasePassthrough || !stage.IsEmpty() {
+			continue
+		}
+		if i == len(g.config.Stages)-1 && len(g.config.Package.Labels) > 0 {
+			continue
+		}
+		if err := g.warn("stage %q: stage adds nothing beyond its FROM line (set base-passthrough: true if this is intended)", stage.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) validateScratchFinalStage() error {
+	if len(g.config.Stages) == 0 {
+		return nil
+	}
+	stage := g.config.Stages[len(g.config.Stages)-1]
+	if stage.Environment.ExternalImage != "scratch" || len(stage.Environment.Entrypoint) == 0 {
+		return nil
+	}
+
+	binary := stage.Environment.Entrypoint[0]
+	for _, step := range stage.Pipeline {
+		if step.Copy != nil && copyProvides(step.Copy.To, binary) {
+			return nil
+		}
+	}
+	for _, line := range stage.Raw {
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "COPY ") {
+			return nil
+		}
+	}
+
+	return g.warn("stage %q: scratch stage entrypoint %q is not provided by any copy step", stage.Name, binary)
+}
+
+func copyProvides(destination, binary string) bool {
+	destination = path.Clean(destination)
+	return destination == "/" || destination == binary || strings.HasPrefix(binary, destination+"/")
+}
+
+func (g *Generator) validateCopyTrailingSlashes() error {
+	for _, stage := range g.config.Stages {
+		for _, step := range stage.Pipeline {
+			if step.Copy == nil {
+				continue
+			}
+			// Copying a file into a directory ("/main" to "/usr/bin/") is fine;
+			// only a directory source with a file-looking destination is suspect.
+			if !strings.HasSuffix(step.Copy.From, "/") || strings.HasSuffix(step.Copy.To, "/") {
+				continue
+			}
+			if err := g.warn("stage %q: copy from directory %q to %q: destination has no trailing slash", stage.Name, step.Copy.From, step.Copy.To); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fetchDestination(step config.PipelineStep) (string, bool) {
+	if step.Fetch != nil {
+		if step.Fetch.Destination == "" {
+			return defaultFetchDestination, true
+		}
+		return step.Fetch.Destination, true
+	}
+	if step.Uses == "download-verify-extract" {
+		dest, ok := step.With["destination"].(string)
+		return dest, ok && dest != ""
+	}
+	return "", false
+}
+
+func (g *Generator) warn(format string, args ...any) error {
+	if g.strict {
+		return fmt.Errorf(format, args...)
+	}
+	slog.Warn(fmt.Sprintf(format, args...), "package", g.config.Package.Name)
+	return nil
+}
+
 func (g *Generator) generateDockerfile() error {
+	content, err := g.renderDockerfile()
+	if err != nil {
+		return err
+	}
+
+	outputPath := path.Join(g.outputDir, g.outputFilename)
+	if err := g.fs.WriteFile(outputPath, []byte(content), filePerms); err != nil {
+		return fmt.Errorf("writing %s: %w", g.outputFilename, err)
+	}
+
+	return nil
+}
+
+func (g *Generator) renderDockerfile() (string, error) {
 	var b strings.Builder
 	b.Grow(4096)
 
 	for i, stage := range g.config.Stages {
 		isFinalStage := i == len(g.config.Stages)-1
+		g.setCurrentStage(stage.Name)
 		stageContent, err := g.generateStage(stage, isFinalStage)
 		if err != nil {
-			return fmt.Errorf("generating stage %q: %w", stage.Name, err)
+			return "", fmt.Errorf("generating stage %q: %w", stage.Name, err)
 		}
 		b.WriteString(stageContent)
+		if isFinalStage {
+			b.WriteString(g.generateBOMHashLabel())
+		}
 		b.WriteString("\n")
+		if stage.Name == g.onlyStage {
+			break
+		}
 	}
+	g.setCurrentStage("")
 
 	var output strings.Builder
+	if syntax := g.syntax(b.String()); syntax != "" {
+		output.WriteString(fmt.Sprintf("# syntax=%s\n", syntax))
+	}
 	bom := g.generateBOM()
 	if bom != "" {
 		output.WriteString(bom)
+		output.WriteString(g.generateStageBOM())
 		output.WriteString("\n")
 	}
-	output.WriteString(b.String())
+	output.WriteString(strings.ReplaceAll(b.String(), bomHashPlaceholder, g.bomHash()))
 
-	outputPath := path.Join(g.outputDir, g.outputFilename)
-	if err := g.fs.WriteFile(outputPath, []byte(output.String()), filePerms); err != nil {
-		return fmt.Errorf("writing %s: %w", g.outputFilename, err)
-	}
+	return output.String(), nil
+}
 
-	return nil
+func (g *Generator) syntax(content string) string {
+	if g.syntaxDirective != "" {
+		return g.syntaxDirective
+	}
+	if usesBuildkitFeatures(content) {
+		return buildkitSyntax
+	}
+	return ""
 }
 
 func (g *Generator) generateStage(stage config.Stage, isFinalStage bool) (string, error) {
@@ -391,10 +816,11 @@ func (g *Generator) generateStage(stage config.Stage, isFinalStage bool) (string
 			return "", fmt.Errorf("resolving base image: %w", err)
 		}
 
+		imageRef := g.imageRef(resolvedImage)
 		if isFinalStage {
-			b.WriteString(fmt.Sprintf("FROM %s\n\n", resolvedImage.FullRef))
+			b.WriteString(fmt.Sprintf("FROM %s\n\n", imageRef))
 		} else {
-			b.WriteString(fmt.Sprintf("FROM %s AS %s\n\n", resolvedImage.FullRef, stage.Name))
+			b.WriteString(fmt.Sprintf("FROM %s AS %s\n\n", imageRef, stage.Name))
 		}
 	}
 
@@ -403,6 +829,7 @@ func (g *Generator) generateStage(stage config.Stage, isFinalStage bool) (string
 		return "", err
 	}
 	b.WriteString(content)
+	b.WriteString(g.generateRawSection(stage.Raw))
 
 	return b.String(), nil
 }
@@ -411,6 +838,8 @@ func (g *Generator) generateStageContent(env config.Environment, pipeline []conf
 	var b strings.Builder
 	b.Grow(1024)
 
+	env = withInit(withDropPrivileges(env))
+
 	b.WriteString(g.generateArgsSection(env))
 	b.WriteString(g.generateLabelsSection(env, isFinalStage))
 	b.WriteString(g.generateEnvSection(env))
@@ -421,7 +850,14 @@ func (g *Generator) generateStageContent(env config.Environment, pipeline []conf
 
 	b.WriteString(g.generateWorkDirSection(env))
 
-	if err := g.appendPipelineSections(pipeline, &b); err != nil {
+	wrapper, err := generateDropPrivilegesWrapper(env.DropPrivileges)
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(wrapper)
+	b.WriteString(util.FormatDockerfileArray("SHELL", env.Shell))
+
+	if err := g.appendPipelineSections(pipeline, isFinalStage, &b); err != nil {
 		return "", err
 	}
 
@@ -429,13 +865,95 @@ func (g *Generator) generateStageContent(env config.Environment, pipeline []conf
 	return b.String(), nil
 }
 
+func withInit(env config.Environment) config.Environment {
+	initSystem, ok := config.InitSystems[env.Init]
+	if !ok {
+		return env
+	}
+	if !slices.Contains(env.Packages, initSystem.Package) {
+		env.Packages = append(slices.Clone(env.Packages), initSystem.Package)
+	}
+	env.Entrypoint = append(slices.Clone(initSystem.Entrypoint), env.Entrypoint...)
+	return env
+}
+
+func withDropPrivileges(env config.Environment) config.Environment {
+	if env.DropPrivileges == nil {
+		return env
+	}
+	packages := slices.Clone(env.Packages)
+	for _, pkg := range dropPrivilegesPackages {
+		if !slices.Contains(packages, pkg) {
+			packages = append(packages, pkg)
+		}
+	}
+	env.Packages = packages
+	env.Entrypoint = append([]string{dropPrivilegesEntrypoint}, env.Entrypoint...)
+	return env
+}
+
+func generateDropPrivilegesWrapper(drop *config.DropPrivileges) (string, error) {
+	if drop == nil {
+		return "", nil
+	}
+
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\nset -e\n")
+	for _, line := range drop.Setup {
+		script.WriteString(line + "\n")
+	}
+	script.WriteString(fmt.Sprintf("exec su-exec %s \"$@\"\n", drop.User))
+
+	result, err := pipelines.WriteFile(map[string]any{
+		"path":    dropPrivilegesEntrypoint,
+		"content": script.String(),
+		"mode":    "0755",
+	})
+	if err != nil {
+		return "", fmt.Errorf("writing drop-privileges entrypoint: %w", err)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Entrypoint wrapper that drops privileges with su-exec\n")
+	for _, step := range result.Steps {
+		b.WriteString(step.Content)
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+func (g *Generator) imageRef(resolved *images.ResolvedImage) string {
+	if g.digestOnly {
+		return util.DigestOnlyRef(resolved.FullRef)
+	}
+	return resolved.FullRef
+}
+
+func (g *Generator) generateRawSection(raw []string) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, line := range raw {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
 func (g *Generator) generateArgsSection(env config.Environment) string {
 	if len(env.Args) == 0 {
 		return ""
 	}
+	vars := g.buildVarsMap()
 	var b strings.Builder
 	for _, key := range util.SortedKeys(env.Args) {
-		b.WriteString(fmt.Sprintf("ARG %s=\"%s\"\n", key, env.Args[key]))
+		quote := util.QuoteDirectiveValue
+		if slices.Contains(env.Expand, key) {
+			quote = util.QuoteExpandableValue
+		}
+		b.WriteString(fmt.Sprintf("ARG %s=%s\n", key, quote(util.ExpandVars(env.Args[key], vars))))
 	}
 	b.WriteString("\n")
 	return b.String()
@@ -445,14 +963,19 @@ func (g *Generator) generateLabelsSection(env config.Environment, isFinalStage b
 	if len(g.config.Package.Labels) == 0 || !isFinalStage {
 		return ""
 	}
-	return util.FormatMapDirectives("LABEL", g.config.Package.Labels)
+	vars := g.buildVarsMap()
+	labels := make(map[string]string, len(g.config.Package.Labels))
+	for key, value := range g.config.Package.Labels {
+		labels[key] = util.ExpandVars(value, vars)
+	}
+	return util.FormatMapDirectives("LABEL", labels, nil)
 }
 
 func (g *Generator) generateEnvSection(env config.Environment) string {
 	if len(env.Environment) == 0 {
 		return ""
 	}
-	return util.FormatMapDirectives("ENV", env.Environment)
+	return util.FormatMapDirectives("ENV", env.Environment, env.Expand)
 }
 
 func (g *Generator) appendPackageSections(env config.Environment, b *strings.Builder) error {
@@ -479,12 +1002,54 @@ func (g *Generator) generateWorkDirSection(env config.Environment) string {
 	return fmt.Sprintf("WORKDIR %s\n\n", env.WorkDir)
 }
 
-func (g *Generator) appendPipelineSections(pipeline []config.PipelineStep, b *strings.Builder) error {
+func (g *Generator) appendPipelineSections(pipeline []config.PipelineStep, isFinalStage bool, b *strings.Builder) error {
+	var steps []config.PipelineStep
 	for _, step := range pipeline {
-		stepContent, err := g.generatePipelineStep(step)
+		if step.FinalOnly && !isFinalStage {
+			continue
+		}
+		steps = append(steps, step)
+	}
+
+	results := make([]*pipelines.PipelineResult, len(steps))
+	for i, step := range steps {
+		if step.Uses == "" {
+			continue
+		}
+		result, err := g.runPipeline(step)
 		if err != nil {
 			return err
 		}
+		results[i] = &result
+	}
+
+	groups := sharedBuildDepGroups(steps, results)
+
+	for i, step := range steps {
+		var shared []string
+		for _, group := range groups {
+			if i == group.start {
+				install, err := g.virtualDepsInstall(sharedBuildDepsGroup, group.deps)
+				if err != nil {
+					return err
+				}
+				b.WriteString("# Install shared build dependencies\n")
+				b.WriteString(install)
+			}
+			if i >= group.start && i <= group.end {
+				shared = group.deps
+			}
+		}
+
+		var stepContent string
+		if results[i] != nil {
+			stepContent = g.formatPipelineResult(results[i], step.BuildDeps, shared, step.Uses)
+		} else {
+			var err error
+			if stepContent, err = g.generatePipelineStep(step); err != nil {
+				return err
+			}
+		}
 		if stepContent != "" {
 			if step.Name != "" {
 				b.WriteString(fmt.Sprintf("# %s\n", step.Name))
@@ -492,10 +1057,72 @@ func (g *Generator) appendPipelineSections(pipeline []config.PipelineStep, b *st
 			b.WriteString(stepContent)
 			b.WriteString("\n")
 		}
+
+		for _, group := range groups {
+			if i == group.end {
+				b.WriteString(fmt.Sprintf("RUN apk del --no-network %s\n\n", sharedBuildDepsGroup))
+			}
+		}
 	}
 	return nil
 }
 
+type buildDepGroup struct {
+	start int
+	end   int
+	deps  []string
+}
+
+// sharedBuildDepGroups finds build deps needed by more than one step in a run
+// of adjacent pipeline steps, so they can be installed once for the whole run
+// instead of once per step.
+func sharedBuildDepGroups(steps []config.PipelineStep, results []*pipelines.PipelineResult) []buildDepGroup {
+	var groups []buildDepGroup
+	for start := 0; start < len(steps); {
+		if results[start] == nil {
+			start++
+			continue
+		}
+		end := start
+		for end+1 < len(steps) && results[end+1] != nil {
+			end++
+		}
+
+		counts := make(map[string]int)
+		var order []string
+		for i := start; i <= end; i++ {
+			for _, dep := range mergeDeps(results[i].BuildDeps, steps[i].BuildDeps) {
+				if counts[dep] == 0 {
+					order = append(order, dep)
+				}
+				counts[dep]++
+			}
+		}
+
+		group := buildDepGroup{start: -1}
+		for _, dep := range order {
+			if counts[dep] > 1 {
+				group.deps = append(group.deps, dep)
+			}
+		}
+		for i := start; i <= end && len(group.deps) > 0; i++ {
+			deps := mergeDeps(results[i].BuildDeps, steps[i].BuildDeps)
+			if slices.ContainsFunc(deps, func(dep string) bool { return slices.Contains(group.deps, dep) }) {
+				if group.start == -1 {
+					group.start = i
+				}
+				group.end = i
+			}
+		}
+		if len(group.deps) > 0 {
+			groups = append(groups, group)
+		}
+
+		start = end + 1
+	}
+	return groups
+}
+
 func (g *Generator) generateMetadataSections(env config.Environment) string {
 	var b strings.Builder
 
@@ -512,54 +1139,173 @@ func (g *Generator) generateMetadataSections(env config.Environment) string {
 		b.WriteString(fmt.Sprintf("STOPSIGNAL %s\n\n", env.StopSignal))
 	}
 
-	if env.User != "" {
-		b.WriteString(fmt.Sprintf("USER %s\n\n", env.User))
+	user := env.User
+	// The drop-privileges entrypoint has to start as root to call su-exec.
+	if user == "" && env.DropPrivileges == nil {
+		user = g.config.DefaultUser
+	}
+	if user != "" {
+		b.WriteString(fmt.Sprintf("USER %s\n\n", user))
 	}
 
 	b.WriteString(util.FormatDockerfileArray("ENTRYPOINT", env.Entrypoint))
 	b.WriteString(util.FormatDockerfileArray("CMD", env.Cmd))
+	b.WriteString(generateHealthCheckSection(env.HealthCheck))
 
 	return b.String()
 }
 
+func generateHealthCheckSection(hc *config.HealthCheck) string {
+	if hc == nil {
+		return ""
+	}
+	if hc.Disable {
+		return "HEALTHCHECK NONE\n\n"
+	}
+
+	directive := "HEALTHCHECK"
+	if hc.Interval != "" {
+		directive += " --interval=" + hc.Interval
+	}
+	if hc.Timeout != "" {
+		directive += " --timeout=" + hc.Timeout
+	}
+	if hc.StartPeriod != "" {
+		directive += " --start-period=" + hc.StartPeriod
+	}
+	if hc.Retries > 0 {
+		directive += fmt.Sprintf(" --retries=%d", hc.Retries)
+	}
+	return util.FormatDockerfileArray(directive+" CMD", hc.Test)
+}
+
 func (g *Generator) generatePackageInstallForEnv(env config.Environment) (string, error) {
 	var b strings.Builder
 	b.Grow(512)
 
-	b.WriteString("# Install packages\n")
-	b.WriteString("RUN set -eux; \\\n")
-	b.WriteString("    apk add --no-cache \\\n")
-
-	pkgStr, err := g.resolveAndFormatPackages(env.Packages, true, "        ")
+	common, byArch, err := packages.GroupByArch(env.Packages)
 	if err != nil {
 		return "", fmt.Errorf("resolving packages: %w", err)
 	}
-	b.WriteString(pkgStr)
-	b.WriteString("\n")
-	b.WriteString("    ;\n")
+
+	if len(common) > 0 {
+		b.WriteString("# Install packages\n")
+		b.WriteString(g.hadolintIgnore())
+		b.WriteString("RUN set -eux; \\\n")
+		b.WriteString(g.indent() + g.apkAdd() + " \\\n")
+
+		pkgStr, err := g.resolveAndFormatPackages(common, true, g.indent()+g.indent())
+		if err != nil {
+			return "", fmt.Errorf("resolving packages: %w", err)
+		}
+		b.WriteString(pkgStr)
+		b.WriteString("\n")
+		b.WriteString(g.indent() + ";\n")
+	}
+
+	if len(byArch) == 0 {
+		return b.String(), nil
+	}
+
+	if len(common) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("ARG TARGETARCH\n")
+	for _, arch := range util.SortedKeys(byArch) {
+		pkgStr, err := g.resolveAndFormatPackages(byArch[arch], true, g.indent()+g.indent())
+		if err != nil {
+			return "", fmt.Errorf("resolving %s packages: %w", arch, err)
+		}
+		b.WriteString("\n")
+		b.WriteString(g.formatArchPackageInstall(arch, pkgStr))
+	}
 
 	return b.String(), nil
 }
 
+// apkAdd returns the apk add invocation for installs, pointing it at the apk
+// snapshot repositories when one is configured.
+func (g *Generator) apkAdd() string {
+	if g.resolver == nil {
+		return "apk add --no-cache"
+	}
+	if flags := g.resolver.RepositoryFlags(); flags != "" {
+		return "apk add --no-cache " + flags
+	}
+	return "apk add --no-cache"
+}
+
+func (g *Generator) formatArchPackageInstall(arch, pkgStr string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# Install %s packages\n", arch))
+	b.WriteString(g.hadolintIgnore())
+	b.WriteString(fmt.Sprintf("RUN if [ \"$TARGETARCH\" = %q ]; then \\\n", arch))
+	b.WriteString(g.indent() + g.apkAdd() + " \\\n")
+	b.WriteString(pkgStr)
+	b.WriteString("\n")
+	b.WriteString(g.indent() + "; fi\n")
+	return b.String()
+}
+
 func (g *Generator) generateRootfsPackageInstallForEnv(env config.Environment) string {
 	var b strings.Builder
 	b.Grow(512)
 
-	b.WriteString("# Install packages into rootfs\n")
-
-	resolved, err := g.resolvePackages(env.RootfsPackages)
+	common, byArch, err := packages.GroupByArch(env.RootfsPackages)
 	if err != nil {
 		b.WriteString(fmt.Sprintf("# Error resolving packages: %v\n", err))
 		return b.String()
 	}
 
-	b.WriteString("RUN \\\n")
-	for _, pkg := range resolved {
-		b.WriteString(fmt.Sprintf("    apk add --no-cache %s=%s; \\\n", pkg.Name, pkg.Version))
-		b.WriteString(fmt.Sprintf("    apk info -qL %s | rsync -aq --files-from=- / /rootfs/; \\\n", pkg.Name))
+	if len(common) > 0 {
+		b.WriteString("# Install packages into rootfs\n")
+		commands, err := g.rootfsInstallCommands(common)
+		if err != nil {
+			b.WriteString(fmt.Sprintf("# Error resolving packages: %v\n", err))
+			return b.String()
+		}
+		b.WriteString(g.hadolintIgnore())
+		b.WriteString("RUN \\\n")
+		b.WriteString(strings.TrimSuffix(commands, "; \\\n") + "\n")
+	}
+
+	if len(byArch) == 0 {
+		return b.String()
+	}
+
+	if len(common) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("ARG TARGETARCH\n")
+	for _, arch := range util.SortedKeys(byArch) {
+		commands, err := g.rootfsInstallCommands(byArch[arch])
+		if err != nil {
+			b.WriteString(fmt.Sprintf("# Error resolving %s packages: %v\n", arch, err))
+			return b.String()
+		}
+		b.WriteString("\n")
+		b.WriteString(fmt.Sprintf("# Install %s packages into rootfs\n", arch))
+		b.WriteString(g.hadolintIgnore())
+		b.WriteString(fmt.Sprintf("RUN if [ \"$TARGETARCH\" = %q ]; then \\\n", arch))
+		b.WriteString(commands)
+		b.WriteString(g.indent() + "fi\n")
+	}
+
+	return b.String()
+}
+
+func (g *Generator) rootfsInstallCommands(pkgSpecs []string) (string, error) {
+	resolved, err := g.resolvePackages(pkgSpecs)
+	if err != nil {
+		return "", err
 	}
 
-	return b.String()[:b.Len()-3] + "\n"
+	var b strings.Builder
+	for _, pkg := range resolved {
+		b.WriteString(fmt.Sprintf("%s%s %s=%s; \\\n", g.indent(), g.apkAdd(), pkg.Name, pkg.Version))
+		b.WriteString(fmt.Sprintf("%sapk info -qL %s | rsync -aq --files-from=- / /rootfs/; \\\n", g.indent(), pkg.Name))
+	}
+	return b.String(), nil
 }
 
 func (g *Generator) generatePipelineStep(step config.PipelineStep) (string, error) {
@@ -578,12 +1324,13 @@ func (g *Generator) generatePipelineStep(step config.PipelineStep) (string, erro
 		vars := g.buildVarsMap()
 		run := util.ExpandVars(step.Run, vars)
 
+		separator := g.runSeparator(step)
 		if len(step.BuildDeps) > 0 {
-			b.WriteString(g.generateRunWithBuildDeps(run, step.BuildDeps))
+			b.WriteString(g.generateRunWithBuildDeps(run, step.BuildDeps, separator))
 		} else {
-			b.WriteString(g.formatRunCommand(run))
+			b.WriteString(g.formatRunCommand(run, separator))
 		}
-		return b.String(), nil
+		return withRunMounts(b.String(), step), nil
 	}
 
 	if step.Fetch != nil {
@@ -599,6 +1346,12 @@ func (g *Generator) generatePipelineStep(step config.PipelineStep) (string, erro
 		if step.Copy.Chown != "" {
 			copyCmd += fmt.Sprintf(" --chown=%s", step.Copy.Chown)
 		}
+		if step.Copy.Chmod != "" {
+			copyCmd += fmt.Sprintf(" --chmod=%s", step.Copy.Chmod)
+		}
+		if step.Copy.Link {
+			copyCmd += " --link"
+		}
 		b.WriteString(fmt.Sprintf("%s %s %s\n", copyCmd, step.Copy.From, step.Copy.To))
 		return b.String(), nil
 	}
@@ -606,26 +1359,82 @@ func (g *Generator) generatePipelineStep(step config.PipelineStep) (string, erro
 	return "", nil
 }
 
-func (g *Generator) generateRunWithBuildDeps(runCmd string, buildDeps []string) string {
+// withRunMounts adds the step's cache and secret mounts to its RUN instruction.
+// Secrets are referenced by id only: the value is supplied at build time with
+// --secret id=<id> and is readable at /run/secrets/<id> without entering a layer.
+func withRunMounts(content string, step config.PipelineStep) string {
+	if !hasRunMounts(step) {
+		return content
+	}
+	var flags strings.Builder
+	for _, mount := range step.CacheMounts {
+		flags.WriteString("--mount=type=cache,target=" + mount.Target)
+		if mount.ID != "" {
+			flags.WriteString(",id=" + mount.ID)
+		}
+		flags.WriteString(" ")
+	}
+	for _, id := range step.Secrets {
+		flags.WriteString("--mount=type=secret,id=" + id + " ")
+	}
+	return strings.Replace(content, "RUN ", "RUN "+flags.String(), 1)
+}
+
+func hasRunMounts(step config.PipelineStep) bool {
+	return len(step.CacheMounts) > 0 || len(step.Secrets) > 0
+}
+
+// usesBuildkitFeatures reports whether rendered content relies on Dockerfile
+// syntax that needs a BuildKit frontend: RUN --mount, COPY --link or heredocs.
+// Checking the output rather than the config also catches pipeline steps.
+func usesBuildkitFeatures(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "RUN":
+			if strings.HasPrefix(fields[1], "--mount=") || util.HasHeredoc(line) {
+				return true
+			}
+		case "COPY", "ADD":
+			if slices.Contains(fields, "--link") || util.HasHeredoc(line) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *Generator) runSeparator(step config.PipelineStep) string {
+	if step.FailFast || g.config.FailFast {
+		return util.ShellSeparatorFailFast
+	}
+	return util.ShellSeparator
+}
+
+func (g *Generator) generateRunWithBuildDeps(runCmd string, buildDeps []string, separator string) string {
 	var b strings.Builder
 
-	pkgStr, err := g.resolveAndFormatPackages(buildDeps, true, "  ")
+	pkgStr, err := g.resolveAndFormatPackages(buildDeps, true, g.indent())
 	if err != nil {
 		b.WriteString(fmt.Sprintf("# Error resolving build deps: %v\n", err))
 		return b.String()
 	}
 
-	b.WriteString("RUN apk add --no-cache --virtual .build-deps \\\n")
+	b.WriteString(g.hadolintIgnore())
+	b.WriteString(fmt.Sprintf("RUN %s --virtual .build-deps \\\n", g.apkAdd()))
 	b.WriteString(pkgStr)
 	b.WriteString("\n")
-	b.WriteString("  ; \\\n")
+	b.WriteString(g.indent() + "; \\\n")
 
-	lines := strings.Split(strings.TrimSpace(runCmd), "\n")
+	lines := g.withShellOptions(strings.Split(strings.TrimSpace(runCmd), "\n"))
 	for _, line := range lines {
-		b.WriteString(util.FormatShellLineWithContinuation(line, "  "))
+		b.WriteString(util.FormatShellLineWithSeparator(line, g.indent(), separator))
 	}
 
-	b.WriteString("  apk del --no-network .build-deps\n")
+	b.WriteString(g.indent() + "apk del --no-network .build-deps\n")
 
 	return b.String()
 }
@@ -633,35 +1442,55 @@ func (g *Generator) generateRunWithBuildDeps(runCmd string, buildDeps []string)
 func (g *Generator) generateFetchStep(fetch *config.FetchStep) string {
 	dest := fetch.Destination
 	if dest == "" {
-		dest = "/tmp/download"
+		dest = defaultFetchDestination
 	}
 
 	vars := g.buildVarsMap()
 	url := util.ExpandVars(fetch.URL, vars)
-	return buildFetchCommand(url, dest, fetch.Extract)
+	return buildFetchCommand(url, dest, fetch.Extract, fetch.Retries)
 }
 
 func (g *Generator) generateIncludeCall(step config.PipelineStep) (string, error) {
-	pipeline, err := g.getPipeline(step.Uses, step.Name)
+	result, err := g.runPipeline(step)
 	if err != nil {
 		return "", err
 	}
+	return g.formatPipelineResult(&result, step.BuildDeps, nil, step.Uses), nil
+}
 
-	if err := pipelines.ValidateParams(step.Uses, step.With); err != nil {
-		return "", err
+func (g *Generator) runPipeline(step config.PipelineStep) (pipelines.PipelineResult, error) {
+	pipeline, err := g.getPipeline(step.Uses, step.Name)
+	if err != nil {
+		return pipelines.PipelineResult{}, err
 	}
 
-	expandedWith, err := g.expandPipelineParams(step.With, step.Uses, step.Name)
+	with, err := g.withComputedChecksum(step.Uses, step.With)
 	if err != nil {
-		return "", err
+		return pipelines.PipelineResult{}, err
+	}
+
+	if err := pipelines.ValidateParams(step.Uses, with); err != nil {
+		return pipelines.PipelineResult{}, err
+	}
+
+	expandedWith, err := g.expandPipelineParams(with, step.Uses, step.Name)
+	if err != nil {
+		return pipelines.PipelineResult{}, err
 	}
 
-	result, err := pipeline(expandedWith)
+	expandedWith, err = g.withGitHubRelease(step.Uses, expandedWith)
 	if err != nil {
-		return "", fmt.Errorf("executing pipeline %q: %w", step.Uses, err)
+		return pipelines.PipelineResult{}, err
 	}
 
-	return g.formatPipelineResult(&result, step.BuildDeps, step.Uses), nil
+	result, err := pipeline(g.withBuildInfo(step.Uses, expandedWith))
+	if err != nil {
+		return pipelines.PipelineResult{}, fmt.Errorf("executing pipeline %q: %w", step.Uses, err)
+	}
+	for i := range result.Steps {
+		result.Steps[i].Content = util.ReindentContinuations(result.Steps[i].Content, g.indent())
+	}
+	return result, nil
 }
 
 func (g *Generator) getPipeline(pipelineName, stepName string) (pipelines.Pipeline, error) {
@@ -691,7 +1520,7 @@ func (g *Generator) expandPipelineParams(with map[string]any, pipelineName, step
 	return expandedWith, nil
 }
 
-func (g *Generator) formatPipelineResult(result *pipelines.PipelineResult, buildDeps []string, pipelineName string) string {
+func (g *Generator) formatPipelineResult(result *pipelines.PipelineResult, buildDeps, sharedDeps []string, pipelineName string) string {
 	var stepsContent strings.Builder
 	for _, pipelineStep := range result.Steps {
 		if pipelineStep.Name != "" {
@@ -700,12 +1529,43 @@ func (g *Generator) formatPipelineResult(result *pipelines.PipelineResult, build
 		stepsContent.WriteString(pipelineStep.Content)
 	}
 
-	allBuildDeps := mergeDeps(result.BuildDeps, buildDeps)
+	content := stepsContent.String()
+	allBuildDeps := slices.DeleteFunc(mergeDeps(result.BuildDeps, buildDeps), func(dep string) bool {
+		return slices.Contains(sharedDeps, dep)
+	})
 	if len(allBuildDeps) > 0 {
-		return g.wrapWithBuildDeps(stepsContent.String(), allBuildDeps, pipelineName)
+		content = g.wrapWithBuildDeps(content, allBuildDeps, pipelineName)
+	}
+
+	if len(result.Packages) > 0 {
+		return g.generatePipelinePackages(result.Packages, pipelineName) + content
+	}
+	return content
+}
+
+func (g *Generator) generatePipelinePackages(pkgs []string, pipelineName string) string {
+	pkgStr, err := g.resolveAndFormatPackages(pkgs, true, g.indent())
+	if err != nil {
+		return fmt.Sprintf("# Error resolving %s packages: %v\n", pipelineName, err)
 	}
 
-	return stepsContent.String()
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# Install %s packages\n", pipelineName))
+	b.WriteString(g.hadolintIgnore())
+	b.WriteString(fmt.Sprintf("RUN %s \\\n", g.apkAdd()))
+	b.WriteString(pkgStr)
+	b.WriteString("\n")
+	b.WriteString(g.indent() + ";\n\n")
+	return b.String()
+}
+
+// hadolintIgnore suppresses hadolint's DL3018 on apk installs, which it raises
+// even though dfo resolves and pins every package version.
+func (g *Generator) hadolintIgnore() string {
+	if !g.config.Hadolint {
+		return ""
+	}
+	return "# hadolint ignore=DL3018\n"
 }
 
 func mergeDeps(a, b []string) []string {
@@ -733,18 +1593,12 @@ func (g *Generator) wrapWithBuildDeps(content string, buildDeps []string, pipeli
 
 	virtualName := fmt.Sprintf(".%s-deps", pipelineName)
 
-	pkgStr, err := g.resolveAndFormatPackages(buildDeps, false, "    ")
+	install, err := g.virtualDepsInstall(virtualName, buildDeps)
 	if err != nil {
-		b.WriteString(fmt.Sprintf("# Error resolving build deps: %v\n", err))
 		return content
 	}
 
-	b.WriteString(fmt.Sprintf("RUN apk add --no-cache --virtual %s \\\n", virtualName))
-	b.WriteString("    ")
-	b.WriteString(pkgStr)
-	b.WriteString("\n")
-	b.WriteString("    ;\n\n")
-
+	b.WriteString(install)
 	b.WriteString(content)
 
 	b.WriteString(fmt.Sprintf("RUN apk del --no-network %s\n", virtualName))
@@ -752,7 +1606,23 @@ func (g *Generator) wrapWithBuildDeps(content string, buildDeps []string, pipeli
 	return b.String()
 }
 
-func (g *Generator) formatRunCommand(run string) string {
+func (g *Generator) virtualDepsInstall(virtualName string, deps []string) (string, error) {
+	pkgStr, err := g.resolveAndFormatPackages(deps, false, g.indent())
+	if err != nil {
+		return "", fmt.Errorf("resolving build deps: %w", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(g.hadolintIgnore())
+	b.WriteString(fmt.Sprintf("RUN %s --virtual %s \\\n", g.apkAdd(), virtualName))
+	b.WriteString(g.indent())
+	b.WriteString(pkgStr)
+	b.WriteString("\n")
+	b.WriteString(g.indent() + ";\n\n")
+	return b.String(), nil
+}
+
+func (g *Generator) formatRunCommand(run, separator string) string {
 	lines := strings.Split(run, "\n")
 
 	var nonEmptyLines []string
@@ -772,34 +1642,173 @@ func (g *Generator) formatRunCommand(run string) string {
 		return fmt.Sprintf("RUN %s\n", normalized)
 	}
 
-	var b strings.Builder
-	b.Grow(256)
+	nonEmptyLines = g.withShellOptions(nonEmptyLines)
 
-	for i, line := range nonEmptyLines {
-		if i == 0 {
-			b.WriteString(util.FormatShellLineWithContinuation(line, "RUN "))
-		} else if i < len(nonEmptyLines)-1 {
-			b.WriteString(util.FormatShellLineWithContinuation(line, "    "))
-		} else {
-			normalized, _ := util.NormalizeShellLine(line)
-			b.WriteString(fmt.Sprintf("    %s\n", normalized))
+	return util.FormatRunLines(nonEmptyLines, separator, g.indent())
+}
+
+func (g *Generator) withShellOptions(lines []string) []string {
+	if !g.shellOptions {
+		return lines
+	}
+	for _, line := range lines {
+		normalized, _ := util.NormalizeShellLine(line)
+		if normalized == "" {
+			continue
+		}
+		if strings.HasPrefix(normalized, "set -") || strings.HasPrefix(normalized, "set +") {
+			return lines
 		}
+		break
 	}
+	return append([]string{util.ShellOptions}, lines...)
+}
+
+func (g *Generator) generateBOM() string {
+	jsonBytes := g.bomJSON()
+	if jsonBytes == nil {
+		return ""
+	}
+	return fmt.Sprintf("# BOM: %s\n", string(jsonBytes))
+}
+
+func (g *Generator) setCurrentStage(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.currentStage = name
+}
+
+func (g *Generator) generateStageBOM() string {
+	if !g.config.StageBOM {
+		return ""
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var b strings.Builder
+	for _, stage := range g.config.Stages {
+		entries := make(map[string]string)
+		for pkg, stages := range g.packageStages {
+			if slices.Contains(stages, stage.Name) {
+				entries[fmt.Sprintf("apk:%s", pkg)] = g.resolvedPackages[pkg]
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
 
+		jsonBytes, err := json.Marshal(entries)
+		if err != nil {
+			slog.Warn("failed to generate stage BOM", "stage", stage.Name, "error", err)
+			continue
+		}
+		b.WriteString(fmt.Sprintf("# BOM stage %s: %s\n", stage.Name, jsonBytes))
+	}
 	return b.String()
 }
 
-func (g *Generator) generateBOM() string {
+func (g *Generator) generateBOMHashLabel() string {
+	bomHash := g.bomHash()
+	if bomHash == "" {
+		return ""
+	}
+	return fmt.Sprintf("LABEL dfo.bom-hash=\"%s\"\n", bomHash)
+}
+
+func (g *Generator) bomHash() string {
+	jsonBytes := g.bomJSON()
+	if jsonBytes == nil {
+		return ""
+	}
+	if g.plan {
+		return planDigest
+	}
+	hash := sha256.Sum256(jsonBytes)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+func (g *Generator) withBuildInfo(pipelineName string, with map[string]any) map[string]any {
+	if pipelineName != "build-info" {
+		return with
+	}
+
+	g.mu.Lock()
+	versions := make(map[string]string, len(g.resolvedVersions))
+	for key, metadata := range g.resolvedVersions {
+		versions[key] = metadata.Version
+	}
+	g.mu.Unlock()
+
+	result := make(map[string]any, len(with)+2)
+	for key, value := range with {
+		result[key] = value
+	}
+	result["versions"] = versions
+	result["bom-hash"] = bomHashPlaceholder
+	return result
+}
+
+// withGitHubRelease resolves the release asset for install-github-release at
+// generate time, recording the tag when it was resolved from the latest one.
+func (g *Generator) withGitHubRelease(pipelineName string, with map[string]any) (map[string]any, error) {
+	if pipelineName != "install-github-release" {
+		return with, nil
+	}
+
+	repo, _ := with["repo"].(string)
+	ownerRepo, err := pipelines.GitHubRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+	tag, _ := with["tag"].(string)
+	assetPattern, _ := with["asset-pattern"].(string)
+	checksumPattern, _ := with["checksum-pattern"].(string)
+	if checksumPattern == "" {
+		checksumPattern = pipelines.DefaultChecksumPattern
+	}
+
+	var release versions.GitHubRelease
+	if g.plan {
+		release = plannedRelease(ownerRepo, tag)
+	} else {
+		release, err = g.versionResolver.ResolveGitHubRelease(ownerRepo, tag, assetPattern, checksumPattern)
+		if err != nil {
+			return nil, fmt.Errorf("resolving GitHub release: %w", err)
+		}
+	}
+
+	if tag == "" {
+		g.mu.Lock()
+		g.resolvedVersions["https://github.com/"+ownerRepo] = versions.VersionMetadata{Version: release.Tag, URL: release.Asset.URL}
+		g.mu.Unlock()
+	}
+
+	result := make(map[string]any, len(with)+3)
+	for key, value := range with {
+		result[key] = value
+	}
+	result["tag"] = release.Tag
+	result["asset-url"] = release.Asset.URL
+	result["checksum-url"] = release.ChecksumURL
+	return result, nil
+}
+
+func (g *Generator) bomJSON() []byte {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
 	bom := g.collectBOMEntries()
 	if len(bom) == 0 {
-		return ""
+		return nil
 	}
 
-	sortedBOM := g.sortBOMKeys(bom)
-	return g.formatBOMAsComment(sortedBOM)
+	jsonBytes, err := json.Marshal(g.sortBOMKeys(bom))
+	if err != nil {
+		slog.Warn("failed to generate BOM", "error", err)
+		return nil
+	}
+	return jsonBytes
 }
 
 func (g *Generator) collectBOMEntries() map[string]string {
@@ -846,13 +1855,3 @@ func (g *Generator) sortBOMKeys(bom map[string]string) map[string]string {
 
 	return sortedBOM
 }
-
-func (g *Generator) formatBOMAsComment(bom map[string]string) string {
-	jsonBytes, err := json.Marshal(bom)
-	if err != nil {
-		slog.Warn("failed to generate BOM", "error", err)
-		return ""
-	}
-
-	return fmt.Sprintf("# BOM: %s\n", string(jsonBytes))
-}
@@ -1,9 +1,12 @@
 package generator
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/greboid/dfo/pkg/config"
+	"github.com/greboid/dfo/pkg/util"
 )
 
 func TestGenerateArgsSection(t *testing.T) {
@@ -32,6 +35,21 @@ func TestGenerateArgsSection(t *testing.T) {
 			}},
 			expected: "ARG PORT=\"8080\"\nARG VERSION=\"1.0.0\"\n\n",
 		},
+		{
+			name: "quotes and dollar signs are escaped",
+			env: config.Environment{Args: map[string]string{
+				"GREETING": `say "hi" to $USER`,
+			}},
+			expected: "ARG GREETING=\"say \\\"hi\\\" to \\$USER\"\n\n",
+		},
+		{
+			name: "expanded arg keeps variables",
+			env: config.Environment{
+				Args:   map[string]string{"GREETING": `say "hi" to $USER`},
+				Expand: []string{"GREETING"},
+			},
+			expected: "ARG GREETING=\"say \\\"hi\\\" to $USER\"\n\n",
+		},
 	}
 
 	g := &Generator{config: &config.BuildConfig{}}
@@ -77,6 +95,18 @@ func TestGenerateLabelsSection(t *testing.T) {
 			isFinalStage: true,
 			expected:     "LABEL maintainer=\"test@example.com\"\nLABEL version=\"1.0.0\"\n\n",
 		},
+		{
+			name: "label referencing variable",
+			env:  config.Environment{},
+			config: &config.BuildConfig{
+				Vars: map[string]string{"vendor": "example"},
+				Package: config.Package{Labels: map[string]string{
+					"org.opencontainers.image.vendor": "%{vendor}",
+				}},
+			},
+			isFinalStage: true,
+			expected:     "LABEL org.opencontainers.image.vendor=\"example\"\n\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -116,6 +146,17 @@ func TestGenerateEnvSection(t *testing.T) {
 			}},
 			expected: "ENV APP_HOME=\"/app\"\nENV PATH=\"/usr/local/bin\"\n\n",
 		},
+		{
+			name: "expanded env var",
+			env: config.Environment{
+				Environment: map[string]string{
+					"PATH":  "/app/bin:$PATH",
+					"PRICE": "$5",
+				},
+				Expand: []string{"PATH"},
+			},
+			expected: "ENV PATH=\"/app/bin:$PATH\"\nENV PRICE=\"\\$5\"\n\n",
+		},
 	}
 
 	g := &Generator{config: &config.BuildConfig{}}
@@ -158,6 +199,180 @@ func TestGenerateWorkDirSection(t *testing.T) {
 	}
 }
 
+func TestGenerateMetadataSectionsDefaultUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultUser string
+		env         config.Environment
+		expected    string
+	}{
+		{
+			name:        "default applies without explicit user",
+			defaultUser: "nobody",
+			env:         config.Environment{},
+			expected:    "USER nobody\n\n",
+		},
+		{
+			name:        "explicit user wins",
+			defaultUser: "nobody",
+			env:         config.Environment{User: "app"},
+			expected:    "USER app\n\n",
+		},
+		{
+			name:     "explicit user without default",
+			env:      config.Environment{User: "app"},
+			expected: "USER app\n\n",
+		},
+		{
+			name:     "no user",
+			env:      config.Environment{},
+			expected: "",
+		},
+		{
+			name:        "default skipped for drop-privileges",
+			defaultUser: "nobody",
+			env: config.Environment{
+				DropPrivileges: &config.DropPrivileges{User: "app"},
+				Entrypoint:     []string{dropPrivilegesEntrypoint, "/app"},
+			},
+			expected: "ENTRYPOINT [\"" + dropPrivilegesEntrypoint + "\", \"/app\"]\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{config: &config.BuildConfig{DefaultUser: tt.defaultUser}}
+			result := g.generateMetadataSections(tt.env)
+			if result != tt.expected {
+				t.Errorf("generateMetadataSections() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateHealthCheckSection(t *testing.T) {
+	tests := []struct {
+		name     string
+		hc       *config.HealthCheck
+		expected string
+	}{
+		{
+			name:     "no healthcheck",
+			expected: "",
+		},
+		{
+			name: "full healthcheck",
+			hc: &config.HealthCheck{
+				Test:        []string{"/app", "--health"},
+				Interval:    "30s",
+				Timeout:     "5s",
+				StartPeriod: "10s",
+				Retries:     3,
+			},
+			expected: "HEALTHCHECK --interval=30s --timeout=5s --start-period=10s --retries=3 CMD [\"/app\", \"--health\"]\n\n",
+		},
+		{
+			name:     "test only",
+			hc:       &config.HealthCheck{Test: []string{"/app", "--health"}},
+			expected: "HEALTHCHECK CMD [\"/app\", \"--health\"]\n\n",
+		},
+		{
+			name:     "disabled",
+			hc:       &config.HealthCheck{Disable: true},
+			expected: "HEALTHCHECK NONE\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{config: &config.BuildConfig{}}
+			result := g.generateMetadataSections(config.Environment{HealthCheck: tt.hc})
+			if result != tt.expected {
+				t.Errorf("generateMetadataSections() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateStageContentShell(t *testing.T) {
+	g := &Generator{config: &config.BuildConfig{}}
+	env := config.Environment{
+		WorkDir:    "/src",
+		Shell:      []string{"/bin/bash", "-c"},
+		Entrypoint: []string{"/app"},
+	}
+	pipeline := []config.PipelineStep{{Run: "echo ${PWD}"}}
+
+	content, err := g.generateStageContent(env, pipeline, true)
+	if err != nil {
+		t.Fatalf("generateStageContent() error = %v", err)
+	}
+
+	shell := "SHELL [\"/bin/bash\", \"-c\"]\n\n"
+	shellIndex := strings.Index(content, shell)
+	if shellIndex == -1 {
+		t.Fatalf("content missing %q:\n%s", shell, content)
+	}
+	if workdirIndex := strings.Index(content, "WORKDIR /src"); workdirIndex > shellIndex {
+		t.Errorf("SHELL emitted before WORKDIR:\n%s", content)
+	}
+	if runIndex := strings.Index(content, "RUN "); runIndex < shellIndex {
+		t.Errorf("SHELL emitted after pipeline steps:\n%s", content)
+	}
+}
+
+func TestGenerateCopyStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		copy     *config.CopyStep
+		expected string
+	}{
+		{
+			name:     "plain copy",
+			copy:     &config.CopyStep{From: "app.conf", To: "/etc/app.conf"},
+			expected: "COPY app.conf /etc/app.conf\n",
+		},
+		{
+			name:     "copy with chmod",
+			copy:     &config.CopyStep{From: "entrypoint.sh", To: "/entrypoint.sh", Chmod: "0755"},
+			expected: "COPY --chmod=0755 entrypoint.sh /entrypoint.sh\n",
+		},
+		{
+			name:     "copy from stage with chown and chmod",
+			copy:     &config.CopyStep{FromStage: "build", From: "/main", To: "/rootfs/app", Chown: "65532:65532", Chmod: "0755"},
+			expected: "COPY --from=build --chown=65532:65532 --chmod=0755 /main /rootfs/app\n",
+		},
+		{
+			name:     "copy with link",
+			copy:     &config.CopyStep{From: "app.conf", To: "/etc/app.conf", Link: true},
+			expected: "COPY --link app.conf /etc/app.conf\n",
+		},
+		{
+			name:     "copy from stage with link",
+			copy:     &config.CopyStep{FromStage: "build", From: "/main", To: "/rootfs/app", Link: true},
+			expected: "COPY --from=build --link /main /rootfs/app\n",
+		},
+		{
+			name:     "copy from stage with chown, chmod and link",
+			copy:     &config.CopyStep{FromStage: "build", From: "/main", To: "/rootfs/app", Chown: "65532:65532", Chmod: "0755", Link: true},
+			expected: "COPY --from=build --chown=65532:65532 --chmod=0755 --link /main /rootfs/app\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{config: &config.BuildConfig{}}
+			result, err := g.generatePipelineStep(config.PipelineStep{Copy: tt.copy})
+			if err != nil {
+				t.Fatalf("generatePipelineStep() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("generatePipelineStep() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestExtractShortDigest(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -310,3 +525,809 @@ func TestMergeDeps(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatRunCommandSeparator(t *testing.T) {
+	run := "apk update\nmake\nmake install"
+
+	tests := []struct {
+		name      string
+		separator string
+		expected  string
+	}{
+		{
+			name:      "semicolon joined",
+			separator: util.ShellSeparator,
+			expected:  "RUN apk update; \\\n    make; \\\n    make install\n",
+		},
+		{
+			name:      "and joined",
+			separator: util.ShellSeparatorFailFast,
+			expected:  "RUN apk update && \\\n    make && \\\n    make install\n",
+		},
+	}
+
+	g := &Generator{config: &config.BuildConfig{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := g.formatRunCommand(run, tt.separator)
+			if result != tt.expected {
+				t.Errorf("formatRunCommand() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunSeparator(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *config.BuildConfig
+		step     config.PipelineStep
+		expected string
+	}{
+		{
+			name:     "default",
+			config:   &config.BuildConfig{},
+			step:     config.PipelineStep{Run: "make"},
+			expected: util.ShellSeparator,
+		},
+		{
+			name:     "per-step fail-fast",
+			config:   &config.BuildConfig{},
+			step:     config.PipelineStep{Run: "make", FailFast: true},
+			expected: util.ShellSeparatorFailFast,
+		},
+		{
+			name:     "global fail-fast",
+			config:   &config.BuildConfig{FailFast: true},
+			step:     config.PipelineStep{Run: "make"},
+			expected: util.ShellSeparatorFailFast,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{config: tt.config}
+			if result := g.runSeparator(tt.step); result != tt.expected {
+				t.Errorf("runSeparator() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatRunCommandShellOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		run          string
+		shellOptions bool
+		expected     string
+	}{
+		{
+			name:         "disabled",
+			run:          "make\nmake install",
+			shellOptions: false,
+			expected:     "RUN make; \\\n    make install\n",
+		},
+		{
+			name:         "enabled",
+			run:          "make\nmake install",
+			shellOptions: true,
+			expected:     "RUN set -eux; \\\n    make; \\\n    make install\n",
+		},
+		{
+			name:         "enabled with existing options",
+			run:          "set -ex\nmake\nmake install",
+			shellOptions: true,
+			expected:     "RUN set -ex; \\\n    make; \\\n    make install\n",
+		},
+		{
+			name:         "enabled single line",
+			run:          "make",
+			shellOptions: true,
+			expected:     "RUN make\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{config: &config.BuildConfig{}, shellOptions: tt.shellOptions}
+			result := g.formatRunCommand(tt.run, util.ShellSeparator)
+			if result != tt.expected {
+				t.Errorf("formatRunCommand() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunWithBuildDepsShellOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		shellOptions bool
+		expected     bool
+	}{
+		{
+			name:         "disabled",
+			shellOptions: false,
+			expected:     false,
+		},
+		{
+			name:         "enabled",
+			shellOptions: true,
+			expected:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{config: &config.BuildConfig{}, shellOptions: tt.shellOptions}
+			result := g.generateRunWithBuildDeps("make\nmake install", nil, util.ShellSeparator)
+			if strings.Contains(result, util.RunIndent+"set -eux; \\\n"+util.RunIndent+"make; \\\n") != tt.expected {
+				t.Errorf("generateRunWithBuildDeps() = %q, expected set -eux prefix: %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateStageRaw(t *testing.T) {
+	stage := config.Stage{
+		Name: "build",
+		Environment: config.Environment{
+			ExternalImage: "alpine:3.20",
+			Cmd:           []string{"/app"},
+		},
+		Raw: []string{
+			"HEALTHCHECK CMD /app --health",
+			"ONBUILD RUN echo hello",
+		},
+	}
+
+	g := &Generator{config: &config.BuildConfig{}}
+	result, err := g.generateStage(stage, true)
+	if err != nil {
+		t.Fatalf("generateStage() error = %v", err)
+	}
+
+	expectedSuffix := "CMD [\"/app\"]\n\nHEALTHCHECK CMD /app --health\nONBUILD RUN echo hello\n\n"
+	if !strings.HasSuffix(result, expectedSuffix) {
+		t.Errorf("generateStage() = %q, want suffix %q", result, expectedSuffix)
+	}
+}
+
+func TestValidateWorkDirs(t *testing.T) {
+	tests := []struct {
+		name    string
+		stage   config.Stage
+		vars    map[string]string
+		strict  bool
+		wantErr bool
+	}{
+		{
+			name:  "absolute environment workdir",
+			stage: config.Stage{Name: "build", Environment: config.Environment{WorkDir: "/app"}},
+		},
+		{
+			name:    "relative environment workdir",
+			stage:   config.Stage{Name: "build", Environment: config.Environment{WorkDir: "app"}},
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:  "relative environment workdir without strict",
+			stage: config.Stage{Name: "build", Environment: config.Environment{WorkDir: "app"}},
+		},
+		{
+			name:   "absolute workdir from variable",
+			stage:  config.Stage{Name: "build", Environment: config.Environment{WorkDir: "%{dir}/app"}},
+			vars:   map[string]string{"dir": "/srv"},
+			strict: true,
+		},
+		{
+			name: "absolute step workdir",
+			stage: config.Stage{Name: "build", Pipeline: []config.PipelineStep{
+				{Uses: "clone", With: map[string]any{"workdir": "/src"}},
+			}},
+			strict: true,
+		},
+		{
+			name: "relative step workdir",
+			stage: config.Stage{Name: "build", Pipeline: []config.PipelineStep{
+				{Name: "clone", Uses: "clone", With: map[string]any{"workdir": "src"}},
+			}},
+			strict:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{
+				config: &config.BuildConfig{Vars: tt.vars, Stages: []config.Stage{tt.stage}},
+				strict: tt.strict,
+			}
+			err := g.validateWorkDirs()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateWorkDirs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatArchPackageInstall(t *testing.T) {
+	g := &Generator{config: &config.BuildConfig{}}
+	result := g.formatArchPackageInstall("arm64", "        qemu-aarch64=8.2.0-r0 \\\n        libfoo=1.0-r1 \\")
+	expected := "# Install arm64 packages\n" +
+		"RUN if [ \"$TARGETARCH\" = \"arm64\" ]; then \\\n" +
+		"    apk add --no-cache \\\n" +
+		"        qemu-aarch64=8.2.0-r0 \\\n" +
+		"        libfoo=1.0-r1 \\\n" +
+		"    ; fi\n"
+	if result != expected {
+		t.Errorf("formatArchPackageInstall() = %q, want %q", result, expected)
+	}
+}
+
+func TestHadolintIgnore(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		name := "disabled"
+		if enabled {
+			name = "enabled"
+		}
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.BuildConfig{
+				Package:  config.Package{Name: "app"},
+				Hadolint: enabled,
+				Stages: []config.Stage{{
+					Name: "final",
+					Environment: config.Environment{
+						BaseImage:      "base",
+						Packages:       []string{"ca-certificates", "qemu-aarch64[arm64]"},
+						RootfsPackages: []string{"musl"},
+					},
+				}},
+			}
+
+			g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+			g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+			content, err := g.Plan()
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+
+			lines := strings.Split(content, "\n")
+			installs := 0
+			for i, line := range lines {
+				if !strings.HasPrefix(line, "RUN ") || !strings.Contains(line+lines[i+1], "apk add") {
+					continue
+				}
+				installs++
+				ignored := lines[i-1] == "# hadolint ignore=DL3018"
+				if ignored != enabled {
+					t.Errorf("line %q preceded by %q, want ignore directive %v", line, lines[i-1], enabled)
+				}
+			}
+			if installs != 4 {
+				t.Errorf("found %d package install RUNs, want 4:\n%s", installs, content)
+			}
+		})
+	}
+}
+
+func TestGenerateRootfsPackageInstallForEnvArch(t *testing.T) {
+	g := &Generator{
+		config:           &config.BuildConfig{},
+		plan:             true,
+		resolvedPackages: make(map[string]string),
+		packageStages:    make(map[string][]string),
+	}
+
+	content := g.generateRootfsPackageInstallForEnv(config.Environment{
+		RootfsPackages: []string{"musl", "qemu-aarch64[arm64]"},
+	})
+
+	expected := "# Install packages into rootfs\n" +
+		"RUN \\\n" +
+		"    apk add --no-cache musl=PENDING; \\\n" +
+		"    apk info -qL musl | rsync -aq --files-from=- / /rootfs/\n" +
+		"\n" +
+		"ARG TARGETARCH\n" +
+		"\n" +
+		"# Install arm64 packages into rootfs\n" +
+		"RUN if [ \"$TARGETARCH\" = \"arm64\" ]; then \\\n" +
+		"    apk add --no-cache qemu-aarch64=PENDING; \\\n" +
+		"    apk info -qL qemu-aarch64 | rsync -aq --files-from=- / /rootfs/; \\\n" +
+		"    fi\n"
+	if content != expected {
+		t.Errorf("generateRootfsPackageInstallForEnv() =\n%s\nwant:\n%s", content, expected)
+	}
+}
+
+func TestGeneratePackageInstallForEnvInvalidArch(t *testing.T) {
+	g := &Generator{config: &config.BuildConfig{}}
+	if _, err := g.generatePackageInstallForEnv(config.Environment{Packages: []string{"libfoo[arm64"}}); err == nil {
+		t.Error("expected error for unterminated arch constraint")
+	}
+}
+
+func TestValidateFetchDestinations(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline []config.PipelineStep
+		wantErr  bool
+	}{
+		{
+			name: "distinct destinations",
+			pipeline: []config.PipelineStep{
+				{Fetch: &config.FetchStep{URL: "https://example.com/a", Destination: "/tmp/a"}},
+				{Fetch: &config.FetchStep{URL: "https://example.com/b", Destination: "/tmp/b"}},
+			},
+		},
+		{
+			name: "colliding destinations",
+			pipeline: []config.PipelineStep{
+				{Fetch: &config.FetchStep{URL: "https://example.com/a", Destination: "/tmp/a"}},
+				{Fetch: &config.FetchStep{URL: "https://example.com/b", Destination: "/tmp/a"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "colliding default destination",
+			pipeline: []config.PipelineStep{
+				{Fetch: &config.FetchStep{URL: "https://example.com/a"}},
+				{Fetch: &config.FetchStep{URL: "https://example.com/b"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fetch colliding with download-verify-extract",
+			pipeline: []config.PipelineStep{
+				{Fetch: &config.FetchStep{URL: "https://example.com/a", Destination: "/tmp/tool.tar.gz"}},
+				{Name: "tool", Uses: "download-verify-extract", With: map[string]any{"destination": "/tmp/./tool.tar.gz"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{
+				config: &config.BuildConfig{Stages: []config.Stage{{Name: "build", Pipeline: tt.pipeline}}},
+				strict: true,
+			}
+			err := g.validateFetchDestinations()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateFetchDestinations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateFetchDestinationsPerStage(t *testing.T) {
+	g := &Generator{
+		config: &config.BuildConfig{Stages: []config.Stage{
+			{Name: "one", Pipeline: []config.PipelineStep{{Fetch: &config.FetchStep{URL: "https://example.com/a"}}}},
+			{Name: "two", Pipeline: []config.PipelineStep{{Fetch: &config.FetchStep{URL: "https://example.com/b"}}}},
+		}},
+		strict: true,
+	}
+	if err := g.validateFetchDestinations(); err != nil {
+		t.Errorf("validateFetchDestinations() error = %v, want nil", err)
+	}
+}
+
+func TestFinalOnlySteps(t *testing.T) {
+	pipeline := []config.PipelineStep{
+		{Run: "make"},
+		{Name: "cleanup", Run: "rm -rf /tmp/cache", FinalOnly: true},
+	}
+
+	tests := []struct {
+		name         string
+		isFinalStage bool
+		wantCleanup  bool
+	}{
+		{name: "skipped in intermediate stage", isFinalStage: false, wantCleanup: false},
+		{name: "included in final stage", isFinalStage: true, wantCleanup: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{config: &config.BuildConfig{}}
+			result, err := g.generateStageContent(config.Environment{}, pipeline, tt.isFinalStage)
+			if err != nil {
+				t.Fatalf("generateStageContent() error = %v", err)
+			}
+			if !strings.Contains(result, "RUN make") {
+				t.Errorf("expected regular step in output, got:\n%s", result)
+			}
+			if got := strings.Contains(result, "rm -rf /tmp/cache"); got != tt.wantCleanup {
+				t.Errorf("final-only step present = %v, want %v; output:\n%s", got, tt.wantCleanup, result)
+			}
+		})
+	}
+}
+
+func TestValidateEmptyStages(t *testing.T) {
+	tests := []struct {
+		name    string
+		stage   config.Stage
+		labels  map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "bare stage",
+			stage:   config.Stage{Name: "base", Environment: config.Environment{BaseImage: "alpine"}},
+			wantErr: true,
+		},
+		{
+			name:  "base passthrough",
+			stage: config.Stage{Name: "base", Environment: config.Environment{BaseImage: "alpine"}, BasePassthrough: true},
+		},
+		{
+			name:  "stage with packages",
+			stage: config.Stage{Name: "base", Environment: config.Environment{BaseImage: "alpine", Packages: []string{"git"}}},
+		},
+		{
+			name:  "stage with pipeline",
+			stage: config.Stage{Name: "base", Environment: config.Environment{BaseImage: "alpine"}, Pipeline: []config.PipelineStep{{Run: "make"}}},
+		},
+		{
+			name:  "stage with metadata",
+			stage: config.Stage{Name: "base", Environment: config.Environment{ExternalImage: "scratch", Cmd: []string{"/app"}}},
+		},
+		{
+			name:  "stage with raw lines",
+			stage: config.Stage{Name: "base", Environment: config.Environment{BaseImage: "alpine"}, Raw: []string{"HEALTHCHECK NONE"}},
+		},
+		{
+			name:   "final stage with labels",
+			stage:  config.Stage{Name: "base", Environment: config.Environment{BaseImage: "alpine"}},
+			labels: map[string]string{"org.opencontainers.image.title": "app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{
+				config: &config.BuildConfig{Package: config.Package{Labels: tt.labels}, Stages: []config.Stage{tt.stage}},
+				strict: true,
+			}
+			err := g.validateEmptyStages()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateEmptyStages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateScratchFinalStage(t *testing.T) {
+	scratch := config.Environment{ExternalImage: "scratch", Entrypoint: []string{"/usr/bin/app"}}
+	copyTo := func(to string) []config.PipelineStep {
+		return []config.PipelineStep{{Copy: &config.CopyStep{FromStage: "build", From: "/out", To: to}}}
+	}
+
+	tests := []struct {
+		name    string
+		stage   config.Stage
+		strict  bool
+		wantErr bool
+	}{
+		{
+			name:    "entrypoint without copy",
+			stage:   config.Stage{Name: "final", Environment: scratch},
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:  "warning only without strict",
+			stage: config.Stage{Name: "final", Environment: scratch},
+		},
+		{
+			name:    "copy to another path",
+			stage:   config.Stage{Name: "final", Environment: scratch, Pipeline: copyTo("/etc/app")},
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name:   "copy of binary",
+			stage:  config.Stage{Name: "final", Environment: scratch, Pipeline: copyTo("/usr/bin/app")},
+			strict: true,
+		},
+		{
+			name:   "copy of parent directory",
+			stage:  config.Stage{Name: "final", Environment: scratch, Pipeline: copyTo("/usr/bin/")},
+			strict: true,
+		},
+		{
+			name:   "copy of rootfs",
+			stage:  config.Stage{Name: "final", Environment: scratch, Pipeline: copyTo("/")},
+			strict: true,
+		},
+		{
+			name:   "raw copy",
+			stage:  config.Stage{Name: "final", Environment: scratch, Raw: []string{"COPY --from=build /rootfs/ /"}},
+			strict: true,
+		},
+		{
+			name:   "non-scratch stage",
+			stage:  config.Stage{Name: "final", Environment: config.Environment{BaseImage: "alpine", Entrypoint: []string{"/usr/bin/app"}}},
+			strict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{
+				config: &config.BuildConfig{Stages: []config.Stage{tt.stage}},
+				strict: tt.strict,
+			}
+			err := g.validateScratchFinalStage()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateScratchFinalStage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCopyTrailingSlashes(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr bool
+	}{
+		{name: "directory to directory", from: "/rootfs/", to: "/"},
+		{name: "file to file", from: "/main", to: "/rootfs/app"},
+		{name: "directory to file path", from: "/src/static/", to: "/rootfs/static", wantErr: true},
+		{name: "file into directory", from: "/main", to: "/rootfs/usr/bin/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{
+				config: &config.BuildConfig{Stages: []config.Stage{{
+					Name:     "rootfs",
+					Pipeline: []config.PipelineStep{{Copy: &config.CopyStep{FromStage: "build", From: tt.from, To: tt.to}}},
+				}}},
+				strict: true,
+			}
+			err := g.validateCopyTrailingSlashes()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCopyTrailingSlashes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunIndentConsistency(t *testing.T) {
+	const indent = "  "
+	g := &Generator{config: &config.BuildConfig{}}
+	g.SetRunIndent(indent)
+	generated := g.formatRunCommand("make\nmake install", util.ShellSeparator)
+
+	result, err := g.runPipeline(config.PipelineStep{
+		Uses: "download-verify-extract",
+		With: map[string]any{
+			"url":         "https://example.com/app.tar.gz",
+			"destination": "/app",
+			"checksum":    "abc123",
+		},
+	})
+	if err != nil {
+		t.Fatalf("runPipeline() error = %v", err)
+	}
+
+	outputs := map[string]string{"generator": generated}
+	for _, step := range result.Steps {
+		outputs["pipeline "+step.Name] = step.Content
+	}
+
+	for name, output := range outputs {
+		lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		for _, line := range lines[1:] {
+			if !strings.HasPrefix(line, indent) || strings.HasPrefix(line, indent+" ") {
+				t.Errorf("%s continuation line %q does not use indent %q", name, line, indent)
+			}
+		}
+	}
+}
+
+func TestRunWithBuildDepsIndent(t *testing.T) {
+	const indent = "  "
+	g := &Generator{
+		config:           &config.BuildConfig{},
+		plan:             true,
+		resolvedPackages: make(map[string]string),
+		packageStages:    make(map[string][]string),
+	}
+	g.SetRunIndent(indent)
+
+	result := g.generateRunWithBuildDeps("make\nmake install", []string{"gcc", "make"}, util.ShellSeparator)
+	expected := "RUN apk add --no-cache --virtual .build-deps \\\n" +
+		"  gcc=PENDING \\\n" +
+		"  make=PENDING \\\n" +
+		"  ; \\\n" +
+		"  make; \\\n" +
+		"  make install; \\\n" +
+		"  apk del --no-network .build-deps\n"
+	if result != expected {
+		t.Errorf("generateRunWithBuildDeps() =\n%s\nwant\n%s", result, expected)
+	}
+}
+
+func TestWithInit(t *testing.T) {
+	tests := []struct {
+		name             string
+		env              config.Environment
+		expectedPackages []string
+		expectedMetadata string
+	}{
+		{
+			name:             "tini wraps entrypoint",
+			env:              config.Environment{Init: "tini", Packages: []string{"ca-certificates"}, Entrypoint: []string{"/app"}},
+			expectedPackages: []string{"ca-certificates", "tini"},
+			expectedMetadata: "ENTRYPOINT [\"/sbin/tini\", \"--\", \"/app\"]\n\n",
+		},
+		{
+			name:             "dumb-init without entrypoint",
+			env:              config.Environment{Init: "dumb-init", Cmd: []string{"/app"}},
+			expectedPackages: []string{"dumb-init"},
+			expectedMetadata: "ENTRYPOINT [\"/usr/bin/dumb-init\", \"--\"]\n\nCMD [\"/app\"]\n\n",
+		},
+		{
+			name:             "package not duplicated",
+			env:              config.Environment{Init: "tini", Packages: []string{"tini"}, Entrypoint: []string{"/app"}},
+			expectedPackages: []string{"tini"},
+			expectedMetadata: "ENTRYPOINT [\"/sbin/tini\", \"--\", \"/app\"]\n\n",
+		},
+		{
+			name:             "no init",
+			env:              config.Environment{Entrypoint: []string{"/app"}},
+			expectedMetadata: "ENTRYPOINT [\"/app\"]\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := withInit(tt.env)
+			if !slices.Equal(env.Packages, tt.expectedPackages) {
+				t.Errorf("Packages = %v, want %v", env.Packages, tt.expectedPackages)
+			}
+
+			g := &Generator{config: &config.BuildConfig{}}
+			if result := g.generateMetadataSections(env); result != tt.expectedMetadata {
+				t.Errorf("generateMetadataSections() = %q, want %q", result, tt.expectedMetadata)
+			}
+		})
+	}
+}
+
+func TestDropPrivileges(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{{
+			Name: "final",
+			Environment: config.Environment{
+				BaseImage:  "base",
+				Init:       "tini",
+				Entrypoint: []string{"/app"},
+				DropPrivileges: &config.DropPrivileges{
+					User:  "65532:65532",
+					Setup: []string{"chown -R 65532:65532 /data"},
+				},
+			},
+		}},
+	}
+
+	g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+	content, err := g.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	expected := []string{
+		"su-exec=PENDING",
+		"RUN cat > /usr/local/bin/dfo-entrypoint <<'EOF'\n" +
+			"#!/bin/sh\n" +
+			"set -e\n" +
+			"chown -R 65532:65532 /data\n" +
+			"exec su-exec 65532:65532 \"$@\"\n" +
+			"EOF\n" +
+			"RUN chmod 0755 /usr/local/bin/dfo-entrypoint\n",
+		`ENTRYPOINT ["/sbin/tini", "--", "/usr/local/bin/dfo-entrypoint", "/app"]`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(content, want) {
+			t.Errorf("output does not contain %q:\n%s", want, content)
+		}
+	}
+
+	if _, ok := g.BOM()["apk:su-exec"]; !ok {
+		t.Errorf("BOM() = %v, want apk:su-exec", g.BOM())
+	}
+}
+
+func TestSharedBuildDeps(t *testing.T) {
+	clone := func(repo string, buildDeps ...string) config.PipelineStep {
+		return config.PipelineStep{
+			Uses:      "clone",
+			BuildDeps: buildDeps,
+			With:      map[string]any{"repo": repo, "tag": "v1.0.0"},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		pipeline      []config.PipelineStep
+		gitInstalls   int
+		sharedInstall string
+		contains      []string
+	}{
+		{
+			name: "adjacent steps share a group",
+			pipeline: []config.PipelineStep{
+				clone("https://github.com/owner/one"),
+				clone("https://github.com/owner/two", "patch"),
+			},
+			gitInstalls: 1,
+			sharedInstall: "# Install shared build dependencies\n" +
+				"RUN apk add --no-cache --virtual .shared-build-deps \\\n" +
+				"    git=PENDING \\\n" +
+				"    ;\n\n" +
+				"# Clone repository\n",
+			contains: []string{
+				"RUN apk add --no-cache --virtual .clone-deps \\\n    patch=PENDING \\\n",
+				"RUN apk del --no-network .clone-deps\n\nRUN apk del --no-network .shared-build-deps\n",
+			},
+		},
+		{
+			name: "separated steps install per step",
+			pipeline: []config.PipelineStep{
+				clone("https://github.com/owner/one"),
+				{Run: "true"},
+				clone("https://github.com/owner/two", "patch"),
+			},
+			gitInstalls: 2,
+			contains: []string{
+				"RUN apk add --no-cache --virtual .clone-deps \\\n    git=PENDING \\\n    patch=PENDING \\\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.BuildConfig{
+				Package: config.Package{Name: "app"},
+				Stages: []config.Stage{{
+					Name:        "final",
+					Environment: config.Environment{BaseImage: "base"},
+					Pipeline:    tt.pipeline,
+				}},
+			}
+
+			g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+			g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+			content, err := g.Plan()
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+
+			if count := strings.Count(content, "git=PENDING"); count != tt.gitInstalls {
+				t.Errorf("git installed %d times, want %d:\n%s", count, tt.gitInstalls, content)
+			}
+			if tt.sharedInstall != "" && !strings.Contains(content, tt.sharedInstall) {
+				t.Errorf("content missing shared install %q:\n%s", tt.sharedInstall, content)
+			}
+			if tt.sharedInstall == "" && strings.Contains(content, ".shared-build-deps") {
+				t.Errorf("unexpected shared group:\n%s", content)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(content, want) {
+					t.Errorf("content missing %q:\n%s", want, content)
+				}
+			}
+		})
+	}
+}
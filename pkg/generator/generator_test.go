@@ -1,7 +1,21 @@
 package generator
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/greboid/dfo/pkg/config"
+	"github.com/greboid/dfo/pkg/packages"
+	"github.com/greboid/dfo/pkg/util"
+	"github.com/greboid/dfo/pkg/versions"
 )
 
 func TestBuildFetchCommand(t *testing.T) {
@@ -10,6 +24,7 @@ func TestBuildFetchCommand(t *testing.T) {
 		url      string
 		dest     string
 		extract  bool
+		retries  int
 		expected string
 	}{
 		{
@@ -26,14 +41,649 @@ func TestBuildFetchCommand(t *testing.T) {
 			extract:  true,
 			expected: "RUN curl -fsSL \"https://example.com/archive.tar.gz\" | tar -xz -C \"/app\"\n",
 		},
+		{
+			name:     "download with retries",
+			url:      "https://example.com/file.tar.gz",
+			dest:     "/tmp/file.tar.gz",
+			retries:  3,
+			expected: "RUN curl -fsSL --retry 3 --retry-delay 5 --retry-connrefused -o /tmp/file.tar.gz \"https://example.com/file.tar.gz\"\n",
+		},
+		{
+			name:     "extraction with retries",
+			url:      "https://example.com/archive.tar.gz",
+			dest:     "/app",
+			extract:  true,
+			retries:  2,
+			expected: "RUN curl -fsSL --retry 2 --retry-delay 5 --retry-connrefused \"https://example.com/archive.tar.gz\" | tar -xz -C \"/app\"\n",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := buildFetchCommand(tt.url, tt.dest, tt.extract)
+			result := buildFetchCommand(tt.url, tt.dest, tt.extract, tt.retries)
 			if result != tt.expected {
 				t.Errorf("buildFetchCommand() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
 }
+
+func TestGenerateStats(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{
+			{
+				Name:        "build",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Run: "make"}},
+			},
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+			},
+		},
+	}
+
+	g := New(cfg, "out", util.DryRunFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef0123456789abcdef"})
+
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	stats := g.Stats()
+	if stats.Images != 1 {
+		t.Errorf("Stats().Images = %d, want 1 (base is used twice)", stats.Images)
+	}
+	if stats.Versions != 0 || stats.Packages != 0 {
+		t.Errorf("Stats() = %+v, want no versions or packages", stats)
+	}
+	if stats.TotalDuration <= 0 {
+		t.Errorf("Stats().TotalDuration = %v, want > 0", stats.TotalDuration)
+	}
+}
+
+func TestNewRegistryOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *config.BuildConfig
+		expected string
+	}{
+		{
+			name:     "constructor default",
+			config:   &config.BuildConfig{},
+			expected: "docker.io",
+		},
+		{
+			name:     "config registry wins",
+			config:   &config.BuildConfig{Registry: "mirror.example.com"},
+			expected: "mirror.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(tt.config, "out", util.DryRunFS{}, nil, "3.20", "", "", "docker.io", nil)
+			if registry := g.imageResolver.GetRegistry(); registry != tt.expected {
+				t.Errorf("registry = %q, want %q", registry, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateStageDigestOnly(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      string
+		digestOnly bool
+		expected   string
+	}{
+		{
+			name:     "default keeps tag",
+			image:    "golang:1.22",
+			expected: "FROM golang:1.22@sha256:abcdef AS build\n",
+		},
+		{
+			name:       "digest only drops tag",
+			image:      "golang:1.22",
+			digestOnly: true,
+			expected:   "FROM golang@sha256:abcdef AS build\n",
+		},
+		{
+			name:       "digest only keeps registry port",
+			image:      "localhost:5000/golang:1.22",
+			digestOnly: true,
+			expected:   "FROM localhost:5000/golang@sha256:abcdef AS build\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(&config.BuildConfig{}, "out", util.DryRunFS{}, nil, "3.20", "", "", "", nil)
+			g.SetBuiltImages(map[string]string{tt.image: "sha256:abcdef"})
+			g.SetDigestOnly(tt.digestOnly)
+
+			stage := config.Stage{Name: "build", Environment: config.Environment{BaseImage: tt.image}}
+			result, err := g.generateStage(stage, false)
+			if err != nil {
+				t.Fatalf("generateStage() error = %v", err)
+			}
+			if !strings.HasPrefix(result, tt.expected) {
+				t.Errorf("generateStage() = %q, want prefix %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateBOMHashLabel(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{
+			{
+				Name:        "build",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Run: "make"}},
+			},
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	g := New(cfg, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef0123456789abcdef"})
+
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, g.outputFilename))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	output := string(data)
+
+	firstLine, _, _ := strings.Cut(output, "\n")
+	bom, ok := strings.CutPrefix(firstLine, "# BOM: ")
+	if !ok {
+		t.Fatalf("output does not start with a BOM comment: %q", firstLine)
+	}
+
+	hash := sha256.Sum256([]byte(bom))
+	expected := fmt.Sprintf("LABEL dfo.bom-hash=\"sha256:%s\"\n", hex.EncodeToString(hash[:]))
+	if !strings.Contains(output, expected) {
+		t.Errorf("output missing %q:\n%s", expected, output)
+	}
+	if strings.Index(output, expected) < strings.LastIndex(output, "FROM ") {
+		t.Errorf("BOM hash label should be in the final stage:\n%s", output)
+	}
+}
+
+func TestBOMMatchesComment(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Run: "make"}},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	g := New(cfg, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef0123456789abcdef"})
+	g.resolvedVersions["go"] = versions.VersionMetadata{Version: "1.23.1"}
+	g.resolvedPackages["musl"] = "1.2.5-r0"
+
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, g.outputFilename))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+
+	firstLine, _, _ := strings.Cut(string(data), "\n")
+	comment, ok := strings.CutPrefix(firstLine, "# BOM: ")
+	if !ok {
+		t.Fatalf("output does not start with a BOM comment: %q", firstLine)
+	}
+
+	var expected map[string]string
+	if err := json.Unmarshal([]byte(comment), &expected); err != nil {
+		t.Fatalf("parsing BOM comment: %v", err)
+	}
+
+	bom := g.BOM()
+	if !maps.Equal(bom, expected) {
+		t.Errorf("BOM() = %v, want %v", bom, expected)
+	}
+	if bom["go"] != "1.23.1" || bom["apk:musl"] != "1.2.5-r0" {
+		t.Errorf("BOM() missing resolved entries: %v", bom)
+	}
+}
+
+func TestStageBOM(t *testing.T) {
+	tests := []struct {
+		name     string
+		stageBOM bool
+		expected []string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:     "enabled",
+			stageBOM: true,
+			expected: []string{
+				`# BOM stage build: {"apk:gcc":"13.2.1-r0","apk:musl":"1.2.5-r0"}`,
+				`# BOM stage final: {"apk:musl":"1.2.5-r0"}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.BuildConfig{
+				Package:  config.Package{Name: "app"},
+				StageBOM: tt.stageBOM,
+				Stages: []config.Stage{
+					{Name: "build", Environment: config.Environment{BaseImage: "base"}, Pipeline: []config.PipelineStep{{Run: "make"}}},
+					{Name: "empty", Environment: config.Environment{BaseImage: "base"}, Pipeline: []config.PipelineStep{{Run: "true"}}},
+					{Name: "final", Environment: config.Environment{BaseImage: "base"}, Pipeline: []config.PipelineStep{{Run: "true"}}},
+				},
+			}
+
+			dir := t.TempDir()
+			g := New(cfg, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+			g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+			g.resolvedPackages["gcc"] = "13.2.1-r0"
+			g.resolvedPackages["musl"] = "1.2.5-r0"
+			g.packageStages["gcc"] = []string{"build"}
+			g.packageStages["musl"] = []string{"final", "build"}
+
+			if err := g.Generate(); err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, g.outputFilename))
+			if err != nil {
+				t.Fatalf("reading output: %v", err)
+			}
+
+			lines := strings.Split(string(data), "\n")
+			if !strings.HasPrefix(lines[0], "# BOM: ") {
+				t.Fatalf("output does not start with a BOM comment: %q", lines[0])
+			}
+
+			var stageLines []string
+			for _, line := range lines[1:] {
+				if !strings.HasPrefix(line, "# BOM stage ") {
+					break
+				}
+				stageLines = append(stageLines, line)
+			}
+			if !slices.Equal(stageLines, tt.expected) {
+				t.Errorf("stage BOM lines = %q, want %q", stageLines, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMatchRequestedPackages(t *testing.T) {
+	resolved := []packages.ResolvedPackage{
+		{Name: "git", Version: "2.43.0-r0", Repo: "main"},
+		{Name: "pcre2", Version: "10.43-r0", Repo: "main"},
+		{Name: "yq", Version: "4.44.1-r2", Repo: "community"},
+	}
+
+	result := matchRequestedPackages("build", []string{"git", "yq"}, resolved)
+
+	expected := []PackageResolution{
+		{Stage: "build", Spec: "git", Version: "2.43.0-r0", Repo: "main"},
+		{Stage: "build", Spec: "yq", Version: "4.44.1-r2", Repo: "community"},
+	}
+	if !slices.Equal(result, expected) {
+		t.Errorf("matchRequestedPackages() = %v, want %v", result, expected)
+	}
+}
+
+func TestWithBuildInfo(t *testing.T) {
+	g := &Generator{
+		config: &config.BuildConfig{},
+		resolvedVersions: map[string]versions.VersionMetadata{
+			"go": {Version: "1.23.1"},
+		},
+	}
+
+	with := map[string]any{"path": "/build.json"}
+	result := g.withBuildInfo("build-info", with)
+
+	if result["path"] != "/build.json" {
+		t.Errorf("path = %v, want /build.json", result["path"])
+	}
+	if result["bom-hash"] != bomHashPlaceholder {
+		t.Errorf("bom-hash = %v, want %q", result["bom-hash"], bomHashPlaceholder)
+	}
+	if v, _ := result["versions"].(map[string]string); v["go"] != "1.23.1" {
+		t.Errorf("versions = %v, want go=1.23.1", result["versions"])
+	}
+	if _, ok := with["versions"]; ok {
+		t.Error("withBuildInfo() should not modify the original params")
+	}
+
+	if other := g.withBuildInfo("make-executable", with); len(other) != 1 {
+		t.Errorf("withBuildInfo() injected params into another pipeline: %v", other)
+	}
+}
+
+func TestGenerateReplacesBOMHashPlaceholder(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Run: "echo " + bomHashPlaceholder}},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	g := New(cfg, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef0123456789abcdef"})
+
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, g.outputFilename))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	output := string(data)
+
+	if strings.Contains(output, bomHashPlaceholder) {
+		t.Errorf("output still contains placeholder:\n%s", output)
+	}
+	if !strings.Contains(output, "RUN echo "+g.bomHash()+"\n") {
+		t.Errorf("output missing BOM hash matching the label:\n%s", output)
+	}
+}
+
+func TestOnlyStage(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{
+			{
+				Name:        "deps",
+				Environment: config.Environment{BaseImage: "golang"},
+				Pipeline:    []config.PipelineStep{{Run: "go mod download"}},
+			},
+			{
+				Name:        "build",
+				Environment: config.Environment{BaseImage: "golang"},
+				Pipeline:    []config.PipelineStep{{Run: "go build -o /main"}},
+			},
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Copy: &config.CopyStep{FromStage: "build", From: "/main", To: "/app"}}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		onlyStage   string
+		contains    []string
+		notContains []string
+		wantErr     bool
+	}{
+		{
+			name:        "first stage",
+			onlyStage:   "deps",
+			contains:    []string{"AS deps", "go mod download"},
+			notContains: []string{"AS build", "go build", "FROM base"},
+		},
+		{
+			name:        "middle stage keeps its name for --target",
+			onlyStage:   "build",
+			contains:    []string{"AS deps", "AS build", "go build -o /main"},
+			notContains: []string{"FROM base", "COPY --from=build"},
+		},
+		{
+			name:      "final stage",
+			onlyStage: "final",
+			contains:  []string{"AS build", "FROM base@sha256:0123456789abcdef", "COPY --from=build /main /app"},
+		},
+		{
+			name:     "all stages by default",
+			contains: []string{"AS deps", "AS build", "COPY --from=build /main /app"},
+		},
+		{
+			name:      "unknown stage",
+			onlyStage: "missing",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+			g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+			g.SetOnlyStage(tt.onlyStage)
+
+			content, err := g.Plan()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Plan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for _, want := range tt.contains {
+				if !strings.Contains(content, want) {
+					t.Errorf("content missing %q:\n%s", want, content)
+				}
+			}
+			for _, unwanted := range tt.notContains {
+				if strings.Contains(content, unwanted) {
+					t.Errorf("content contains %q:\n%s", unwanted, content)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildkitFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     config.PipelineStep
+		expected string
+	}{
+		{
+			name: "single line run",
+			step: config.PipelineStep{
+				Run: "go build -o /main",
+				CacheMounts: []config.CacheMount{
+					{Target: "/root/.cache/go-build", ID: "go-build"},
+					{Target: "/go/pkg/mod"},
+				},
+			},
+			expected: "RUN --mount=type=cache,target=/root/.cache/go-build,id=go-build --mount=type=cache,target=/go/pkg/mod go build -o /main\n",
+		},
+		{
+			name: "run with build deps",
+			step: config.PipelineStep{
+				Run:         "cargo build --release",
+				BuildDeps:   []string{"cargo"},
+				CacheMounts: []config.CacheMount{{Target: "/root/.cargo/registry"}},
+			},
+			expected: "RUN --mount=type=cache,target=/root/.cargo/registry apk add --no-cache --virtual .build-deps \\\n",
+		},
+		{
+			name: "secret mount",
+			step: config.PipelineStep{
+				Run:     "git clone https://github.com/owner/private /src",
+				Secrets: []string{"git-credentials"},
+			},
+			expected: "RUN --mount=type=secret,id=git-credentials git clone https://github.com/owner/private /src\n",
+		},
+		{
+			name: "secret mount with build deps and cache",
+			step: config.PipelineStep{
+				Run:         "go mod download",
+				BuildDeps:   []string{"git"},
+				CacheMounts: []config.CacheMount{{Target: "/go/pkg/mod"}},
+				Secrets:     []string{"netrc"},
+			},
+			expected: "RUN --mount=type=cache,target=/go/pkg/mod --mount=type=secret,id=netrc apk add --no-cache --virtual .build-deps \\\n",
+		},
+		{
+			name:     "copy with link",
+			step:     config.PipelineStep{Copy: &config.CopyStep{From: "app.conf", To: "/etc/app.conf", Link: true}},
+			expected: "COPY --link app.conf /etc/app.conf\n",
+		},
+		{
+			name: "copy-files pipeline with link",
+			step: config.PipelineStep{Uses: "copy-files", With: map[string]any{
+				"files": []any{map[string]any{"from": "app.conf", "to": "/etc/app.conf", "link": true}},
+			}},
+			expected: "COPY --link app.conf /etc/app.conf\n",
+		},
+		{
+			name: "write-file heredoc",
+			step: config.PipelineStep{Uses: "write-file", With: map[string]any{
+				"path":    "/etc/app.conf",
+				"content": "key = value",
+			}},
+			expected: "<<'",
+		},
+		{
+			name: "pipeline build cache mount",
+			step: config.PipelineStep{Uses: "build-go-static", With: map[string]any{
+				"repo":        "https://github.com/owner/app",
+				"tag":         "v1.0.0",
+				"build-cache": true,
+			}},
+			expected: "--mount=type=cache,id=dfo-go-build",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.BuildConfig{
+				Package: config.Package{Name: "app"},
+				Stages: []config.Stage{{
+					Name:        "final",
+					Environment: config.Environment{BaseImage: "base"},
+					Pipeline:    []config.PipelineStep{tt.step},
+				}},
+			}
+
+			g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+			g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+			content, err := g.Plan()
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+
+			if !strings.HasPrefix(content, "# syntax=docker/dockerfile:1\n") {
+				t.Errorf("content does not start with syntax directive:\n%s", content)
+			}
+			if !strings.Contains(content, tt.expected) {
+				t.Errorf("content missing %q:\n%s", tt.expected, content)
+			}
+		})
+	}
+}
+
+func TestSyntaxDirectiveForDropPrivileges(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{{
+			Name: "final",
+			Environment: config.Environment{
+				BaseImage:      "base",
+				DropPrivileges: &config.DropPrivileges{User: "app"},
+				Entrypoint:     []string{"/app"},
+			},
+		}},
+	}
+
+	g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+	content, err := g.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if !strings.HasPrefix(content, "# syntax=docker/dockerfile:1\n") {
+		t.Errorf("drop-privileges entrypoint heredoc without syntax directive:\n%s", content)
+	}
+}
+
+func TestNoSyntaxDirectiveWithoutBuildkitFeatures(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{{
+			Name:        "final",
+			Environment: config.Environment{BaseImage: "base"},
+			Pipeline:    []config.PipelineStep{{Run: "make"}},
+		}},
+	}
+
+	g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+	content, err := g.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if strings.Contains(content, "# syntax=") || strings.Contains(content, "--mount=") {
+		t.Errorf("unexpected cache mount output:\n%s", content)
+	}
+}
+
+func TestSyntaxDirective(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package: config.Package{Name: "app"},
+		Stages: []config.Stage{{
+			Name:        "final",
+			Environment: config.Environment{BaseImage: "base"},
+			Pipeline:    []config.PipelineStep{{Run: "make"}},
+		}},
+	}
+
+	dir := t.TempDir()
+	g := New(cfg, dir, util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+	g.SetSyntaxDirective("docker/dockerfile:1.7")
+
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, g.outputFilename))
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 4 {
+		t.Fatalf("output too short:\n%s", data)
+	}
+	if lines[0] != "# syntax=docker/dockerfile:1.7" {
+		t.Errorf("line 1 = %q, want syntax directive", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "# BOM: ") {
+		t.Errorf("line 2 = %q, want BOM comment", lines[1])
+	}
+	if lines[2] != "" || !strings.HasPrefix(lines[3], "FROM ") {
+		t.Errorf("lines 3-4 = %q, %q, want blank line then FROM", lines[2], lines[3])
+	}
+}
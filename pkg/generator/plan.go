@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/greboid/dfo/pkg/images"
+	"github.com/greboid/dfo/pkg/packages"
+	"github.com/greboid/dfo/pkg/util"
+	"github.com/greboid/dfo/pkg/versions"
+)
+
+const (
+	planPlaceholder = "PENDING"
+	planDigest      = "sha256:" + planPlaceholder
+)
+
+func (g *Generator) Plan() (string, error) {
+	g.plan = true
+
+	if err := g.resolveVersions(); err != nil {
+		return "", fmt.Errorf("resolving versions: %w", err)
+	}
+
+	if err := g.validate(); err != nil {
+		return "", err
+	}
+
+	content, err := g.renderDockerfile()
+	if err != nil {
+		return "", fmt.Errorf("generating Dockerfile: %w", err)
+	}
+	return content, nil
+}
+
+func plannedVersion() versions.VersionMetadata {
+	return versions.VersionMetadata{
+		Version:  planPlaceholder,
+		URL:      planPlaceholder,
+		Checksum: planPlaceholder,
+	}
+}
+
+func plannedImage(imageName string) *images.ResolvedImage {
+	return &images.ResolvedImage{
+		Name:    imageName,
+		Digest:  planDigest,
+		FullRef: util.FormatFullRef(imageName, planDigest),
+	}
+}
+
+func plannedRelease(ownerRepo, tag string) versions.GitHubRelease {
+	if tag == "" {
+		tag = planPlaceholder
+	}
+	downloadURL := fmt.Sprintf("https://github.com/%s/releases/download/%s/%s", ownerRepo, tag, planPlaceholder)
+	return versions.GitHubRelease{
+		Tag:         tag,
+		Asset:       versions.ReleaseAsset{Name: planPlaceholder, URL: downloadURL},
+		ChecksumURL: downloadURL,
+	}
+}
+
+func plannedPackages(specs []packages.PackageSpec) []packages.ResolvedPackage {
+	resolved := make([]packages.ResolvedPackage, 0, len(specs))
+	for _, spec := range specs {
+		version := spec.Version
+		if version == "" {
+			version = planPlaceholder
+		}
+		resolved = append(resolved, packages.ResolvedPackage{Name: spec.Name, Version: version})
+	}
+	return resolved
+}
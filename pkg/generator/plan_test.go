@@ -0,0 +1,146 @@
+package generator
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/greboid/dfo/pkg/config"
+	"github.com/greboid/dfo/pkg/util"
+)
+
+func TestPlan(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package:  config.Package{Name: "app"},
+		Versions: map[string]string{"app": "github.com/owner/app"},
+		Stages: []config.Stage{
+			{
+				Name: "build",
+				Environment: config.Environment{
+					BaseImage: "golang",
+					Packages:  []string{"git"},
+				},
+				Pipeline: []config.PipelineStep{{Run: "echo %{versions.app}"}},
+			},
+			{
+				Name:        "final",
+				Environment: config.Environment{BaseImage: "base"},
+				Pipeline:    []config.PipelineStep{{Copy: &config.CopyStep{FromStage: "build", From: "/main", To: "/app"}}},
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	g := New(cfg, dir, util.OSFS{}, nil, "", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+	content, err := g.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	expected := []string{
+		"FROM golang@sha256:PENDING AS build",
+		"git=PENDING",
+		"echo PENDING",
+		"FROM base@sha256:0123456789abcdef",
+		"COPY --from=build /main /app",
+		`LABEL dfo.bom-hash="sha256:PENDING"`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(content, want) {
+			t.Errorf("plan does not contain %q:\n%s", want, content)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading output dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("plan wrote %d files to the output directory", len(entries))
+	}
+}
+
+func TestPlanApkSnapshotRepositories(t *testing.T) {
+	cfg := &config.BuildConfig{
+		Package:     config.Package{Name: "app"},
+		ApkSnapshot: "https://snapshot.example.com/alpine/2024-06-01",
+		Stages: []config.Stage{{
+			Name: "final",
+			Environment: config.Environment{
+				BaseImage: "base",
+				Packages:  []string{"ca-certificates"},
+			},
+		}},
+	}
+
+	g := New(cfg, t.TempDir(), util.OSFS{}, nil, "3.20", "", "", "", nil)
+	g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+	content, err := g.Plan()
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	want := "apk add --no-cache --repositories-file=/dev/null" +
+		" --repository=https://snapshot.example.com/alpine/2024-06-01/v3.20/main" +
+		" --repository=https://snapshot.example.com/alpine/2024-06-01/v3.20/community \\\n"
+	if !strings.Contains(content, want) {
+		t.Errorf("plan does not install from the snapshot repositories:\n%s", content)
+	}
+}
+
+func TestPlanGitHubRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      string
+		expected string
+	}{
+		{
+			name:     "latest tag",
+			expected: `"https://github.com/owner/tool/releases/download/PENDING/PENDING"`,
+		},
+		{
+			name:     "pinned tag",
+			tag:      "v1.2.3",
+			expected: `"https://github.com/owner/tool/releases/download/v1.2.3/PENDING"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			with := map[string]any{
+				"repo":          "owner/tool",
+				"asset-pattern": "*_linux_amd64.tar.gz",
+				"binary":        "tool",
+				"destination":   "/usr/local/bin/tool",
+			}
+			if tt.tag != "" {
+				with["tag"] = tt.tag
+			}
+			cfg := &config.BuildConfig{
+				Package: config.Package{Name: "app"},
+				Stages: []config.Stage{{
+					Name:        "final",
+					Environment: config.Environment{BaseImage: "base"},
+					Pipeline:    []config.PipelineStep{{Uses: "install-github-release", With: with}},
+				}},
+			}
+
+			g := New(cfg, t.TempDir(), util.OSFS{}, nil, "", "", "", "", nil)
+			g.SetBuiltImages(map[string]string{"base": "sha256:0123456789abcdef"})
+
+			content, err := g.Plan()
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if !strings.Contains(content, tt.expected) {
+				t.Errorf("plan does not download %s:\n%s", tt.expected, content)
+			}
+			if strings.Contains(content, "api.github.com") {
+				t.Errorf("plan queries the GitHub API at build time:\n%s", content)
+			}
+		})
+	}
+}
@@ -57,3 +57,101 @@ func TestBuildVarsMap(t *testing.T) {
 		})
 	}
 }
+
+func TestLabelVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "version variable",
+			labels: map[string]string{
+				"org.opencontainers.image.version": "%{versions.app}",
+			},
+			expected: "LABEL org.opencontainers.image.version=\"v1.2.3\"\n\n",
+		},
+		{
+			name: "unknown variable",
+			labels: map[string]string{
+				"org.opencontainers.image.version": "%{versions.missing}",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Generator{
+				config: &config.BuildConfig{Package: config.Package{Labels: tt.labels}},
+				resolvedVersions: map[string]versions.VersionMetadata{
+					"app": {Version: "v1.2.3"},
+				},
+			}
+
+			err := g.validateVariableReferences()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateVariableReferences() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			result := g.generateLabelsSection(config.Environment{}, true)
+			if result != tt.expected {
+				t.Errorf("generateLabelsSection() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestArgVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     map[string]string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "version-derived default",
+			args:     map[string]string{"VERSION": "%{versions.app}"},
+			expected: "ARG VERSION=\"v1.2.3\"\n\n",
+		},
+		{
+			name:     "literal default",
+			args:     map[string]string{"MODE": "release"},
+			expected: "ARG MODE=\"release\"\n\n",
+		},
+		{
+			name:    "unknown variable",
+			args:    map[string]string{"VERSION": "%{versions.missing}"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := config.Environment{BaseImage: "alpine", Args: tt.args}
+			g := &Generator{
+				config: &config.BuildConfig{Stages: []config.Stage{{Name: "build", Environment: env}}},
+				resolvedVersions: map[string]versions.VersionMetadata{
+					"app": {Version: "v1.2.3"},
+				},
+			}
+
+			err := g.validateVariableReferences()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateVariableReferences() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			result := g.generateArgsSection(env)
+			if result != tt.expected {
+				t.Errorf("generateArgsSection() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
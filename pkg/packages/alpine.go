@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 
@@ -14,10 +17,48 @@ import (
 )
 
 const (
-	apkIndexURLTemplate = "https://dl-cdn.alpinelinux.org/alpine/v%s/%s/x86_64/APKINDEX.tar.gz"
+	defaultMirror       = "https://dl-cdn.alpinelinux.org/alpine"
+	apkIndexURLTemplate = "%s/v%s/%s/%s/APKINDEX.tar.gz"
 	latestReleaseURL    = "https://dl-cdn.alpinelinux.org/alpine/latest-stable/releases/x86_64/latest-releases.yaml"
 )
 
+var archKeys = map[string]apkutils.KeyProvider{
+	"x86_64":      keys.X86_64,
+	"x86":         keys.X86,
+	"aarch64":     keys.Aarch64,
+	"armhf":       keys.ARMhf,
+	"armv7":       keys.ARMV7,
+	"ppc64le":     keys.PPC64le,
+	"s390x":       keys.S390X,
+	"riscv64":     keys.RISCV64,
+	"loongarch64": keys.LooongArch64,
+}
+
+var goArchToApkArch = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "x86",
+	"arm64":   "aarch64",
+	"arm":     "armv7",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+	"loong64": "loongarch64",
+}
+
+func HostArch() string {
+	if arch, ok := goArchToApkArch[runtime.GOARCH]; ok {
+		return arch
+	}
+	return "x86_64"
+}
+
+func ValidateArch(arch string) error {
+	if _, ok := archKeys[arch]; !ok {
+		return fmt.Errorf("unsupported apk architecture %q (must be one of: %s)", arch, strings.Join(slices.Sorted(maps.Keys(archKeys)), ", "))
+	}
+	return nil
+}
+
 type AlpineClient struct {
 	httpClient    *http.Client
 	indexCache    map[string]map[string]*apkutils.PackageInfo
@@ -32,23 +73,41 @@ func NewAlpineClient() *AlpineClient {
 	}
 }
 
-func (c *AlpineClient) FetchIndex(version, repo string) (map[string]*apkutils.PackageInfo, error) {
-	cacheKey := fmt.Sprintf("%s:%s", version, repo)
+func (c *AlpineClient) FetchIndex(version, arch, repo string) (map[string]*apkutils.PackageInfo, error) {
+	return c.fetchIndexFrom("", version, arch, repo)
+}
+
+// fetchIndexFrom fetches an APKINDEX from mirror, or the default CDN when mirror
+// is empty. Indexes are cached per mirror.
+func (c *AlpineClient) fetchIndexFrom(mirror, version, arch, repo string) (map[string]*apkutils.PackageInfo, error) {
+	keyProvider, ok := archKeys[arch]
+	if !ok {
+		return nil, ValidateArch(arch)
+	}
+
+	cacheKey := fmt.Sprintf("%s:%s:%s", version, arch, repo)
+	if mirror != "" {
+		cacheKey = mirror + ":" + cacheKey
+	} else {
+		mirror = defaultMirror
+	}
 
 	c.mu.RLock()
 	if cached, ok := c.indexCache[cacheKey]; ok {
 		c.mu.RUnlock()
 		slog.Debug("using cached APKINDEX",
 			"version", version,
+			"arch", arch,
 			"repo", repo,
 			"packages", len(cached))
 		return cached, nil
 	}
 	c.mu.RUnlock()
 
-	url := fmt.Sprintf(apkIndexURLTemplate, version, repo)
+	url := fmt.Sprintf(apkIndexURLTemplate, mirror, version, repo, arch)
 	slog.Debug("fetching APKINDEX from network",
 		"version", version,
+		"arch", arch,
 		"repo", repo,
 		"url", url)
 
@@ -68,7 +127,7 @@ func (c *AlpineClient) FetchIndex(version, repo string) (map[string]*apkutils.Pa
 	}
 
 	slog.Debug("parsing APKINDEX", "version", version, "repo", repo)
-	packages, err := apkutils.ReadApkIndex(resp.Body, keys.X86_64)
+	packages, err := apkutils.ReadApkIndex(resp.Body, keyProvider)
 	if err != nil {
 		return nil, fmt.Errorf("parsing APKINDEX: %w", err)
 	}
@@ -85,15 +144,21 @@ func (c *AlpineClient) FetchIndex(version, repo string) (map[string]*apkutils.Pa
 	return packages, nil
 }
 
-func (c *AlpineClient) GetCombinedPackages(version string, repos []string) (map[string]*apkutils.PackageInfo, error) {
+func (c *AlpineClient) GetCombinedPackages(version, arch string, repos []string) (map[string]*apkutils.PackageInfo, error) {
+	return c.combinedPackagesFrom("", version, arch, repos)
+}
+
+func (c *AlpineClient) combinedPackagesFrom(mirror, version, arch string, repos []string) (map[string]*apkutils.PackageInfo, error) {
 	slog.Debug("building combined package map",
+		"mirror", mirror,
 		"version", version,
+		"arch", arch,
 		"repos", repos)
 
 	combined := make(map[string]*apkutils.PackageInfo)
 
 	for _, repo := range repos {
-		packages, err := c.FetchIndex(version, repo)
+		packages, err := c.fetchIndexFrom(mirror, version, arch, repo)
 		if err != nil {
 			return nil, fmt.Errorf("fetching %s repository: %w", repo, err)
 		}
@@ -12,22 +12,53 @@ import (
 type ResolvedPackage struct {
 	Name    string
 	Version string
+	Repo    string
 }
 
 type Resolver struct {
 	client        *AlpineClient
 	alpineVersion string
+	arch          string
 	repos         []string
+	mirror        string
 }
 
 func NewResolver(client *AlpineClient, alpineVersion string) *Resolver {
 	return &Resolver{
 		client:        client,
 		alpineVersion: alpineVersion,
+		arch:          HostArch(),
 		repos:         []string{"main", "community"},
 	}
 }
 
+func (r *Resolver) SetArch(arch string) {
+	r.arch = arch
+}
+
+func (r *Resolver) Arch() string {
+	return r.arch
+}
+
+func (r *Resolver) SetSnapshot(mirror string) {
+	r.mirror = strings.TrimSuffix(mirror, "/")
+}
+
+// RepositoryFlags returns the apk add flags that make installs use the same
+// snapshot repositories that versions were resolved against. It is empty when
+// no snapshot is set, leaving the image's own repositories in place.
+func (r *Resolver) RepositoryFlags() string {
+	if r.mirror == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("--repositories-file=/dev/null")
+	for _, repo := range r.repos {
+		b.WriteString(fmt.Sprintf(" --repository=%s/v%s/%s", r.mirror, r.alpineVersion, repo))
+	}
+	return b.String()
+}
+
 func (r *Resolver) Resolve(specs []PackageSpec) ([]ResolvedPackage, error) {
 	if len(specs) == 0 {
 		return nil, nil
@@ -40,10 +71,11 @@ func (r *Resolver) Resolve(specs []PackageSpec) ([]ResolvedPackage, error) {
 
 	slog.Debug("resolving packages",
 		"alpine_version", r.alpineVersion,
+		"arch", r.arch,
 		"requested_packages", names,
 		"count", len(names))
 
-	allPackages, err := r.client.GetCombinedPackages(r.alpineVersion, r.repos)
+	allPackages, err := r.client.combinedPackagesFrom(r.mirror, r.alpineVersion, r.arch, r.repos)
 	if err != nil {
 		return nil, err
 	}
@@ -61,11 +93,22 @@ func (r *Resolver) Resolve(specs []PackageSpec) ([]ResolvedPackage, error) {
 		"requested_packages", len(names),
 		"total_with_deps", len(flattened))
 
+	for _, spec := range specs {
+		if pkg, ok := flattened[spec.Name]; ok && spec.Version != "" && pkg.Version != spec.Version {
+			return nil, fmt.Errorf("package %s is pinned to %s but the index has %s", spec.Name, spec.Version, pkg.Version)
+		}
+	}
+
 	resolved := make([]ResolvedPackage, 0, len(flattened))
 	for name, pkg := range flattened {
+		repo, err := r.repoOf(name)
+		if err != nil {
+			return nil, err
+		}
 		resolved = append(resolved, ResolvedPackage{
 			Name:    name,
 			Version: pkg.Version,
+			Repo:    repo,
 		})
 	}
 
@@ -79,3 +122,16 @@ func (r *Resolver) Resolve(specs []PackageSpec) ([]ResolvedPackage, error) {
 
 	return resolved, nil
 }
+
+func (r *Resolver) repoOf(name string) (string, error) {
+	for _, repo := range slices.Backward(r.repos) {
+		index, err := r.client.fetchIndexFrom(r.mirror, r.alpineVersion, r.arch, repo)
+		if err != nil {
+			return "", fmt.Errorf("fetching %s repository: %w", repo, err)
+		}
+		if _, ok := index[name]; ok {
+			return repo, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,173 @@
+package packages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/csmith/apkutils/v2"
+)
+
+func stubIndex(client *AlpineClient, version, arch string, pkgs ...*apkutils.PackageInfo) {
+	index := make(map[string]*apkutils.PackageInfo, len(pkgs))
+	for _, pkg := range pkgs {
+		index[pkg.Name] = pkg
+	}
+	client.indexCache[version+":"+arch+":main"] = index
+	client.indexCache[version+":"+arch+":community"] = map[string]*apkutils.PackageInfo{}
+}
+
+func TestResolverArch(t *testing.T) {
+	client := NewAlpineClient()
+	stubIndex(client, "3.20", "x86_64", &apkutils.PackageInfo{Name: "qemu", Version: "1.0-r0"})
+	stubIndex(client, "3.20", "aarch64",
+		&apkutils.PackageInfo{Name: "qemu", Version: "2.0-r0", Dependencies: []string{"libfoo"}},
+		&apkutils.PackageInfo{Name: "libfoo", Version: "3.0-r0"},
+	)
+
+	resolver := NewResolver(client, "3.20")
+	resolver.SetArch("aarch64")
+
+	resolved, err := resolver.Resolve([]PackageSpec{{Name: "qemu"}})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	expected := []ResolvedPackage{{Name: "libfoo", Version: "3.0-r0", Repo: "main"}, {Name: "qemu", Version: "2.0-r0", Repo: "main"}}
+	if len(resolved) != len(expected) {
+		t.Fatalf("Resolve() = %v, want %v", resolved, expected)
+	}
+	for i := range expected {
+		if resolved[i] != expected[i] {
+			t.Errorf("Resolve()[%d] = %v, want %v", i, resolved[i], expected[i])
+		}
+	}
+}
+
+func TestResolverPinnedVersion(t *testing.T) {
+	client := NewAlpineClient()
+	stubIndex(client, "3.20", "x86_64", &apkutils.PackageInfo{Name: "git", Version: "2.45.2-r0"})
+
+	resolver := NewResolver(client, "3.20")
+	resolver.SetArch("x86_64")
+
+	resolved, err := resolver.Resolve([]PackageSpec{{Name: "git", Version: "2.45.2-r0"}})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(resolved) != 1 || resolved[0].Version != "2.45.2-r0" {
+		t.Errorf("Resolve() = %v, want git 2.45.2-r0", resolved)
+	}
+
+	_, err = resolver.Resolve([]PackageSpec{{Name: "git", Version: "2.44.0-r0"}})
+	if err == nil || !strings.Contains(err.Error(), "package git is pinned to 2.44.0-r0 but the index has 2.45.2-r0") {
+		t.Errorf("Resolve() error = %v, want pin mismatch", err)
+	}
+}
+
+func TestResolverRepo(t *testing.T) {
+	client := NewAlpineClient()
+	stubIndex(client, "3.20", "x86_64",
+		&apkutils.PackageInfo{Name: "git", Version: "2.45.2-r0", Dependencies: []string{"zlib"}},
+		&apkutils.PackageInfo{Name: "zlib", Version: "1.3.1-r1"},
+	)
+	client.indexCache["3.20:x86_64:community"] = map[string]*apkutils.PackageInfo{
+		"yq": {Name: "yq", Version: "4.44.1-r2"},
+	}
+
+	resolver := NewResolver(client, "3.20")
+	resolver.SetArch("x86_64")
+
+	resolved, err := resolver.Resolve([]PackageSpec{{Name: "git"}, {Name: "yq"}})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	expected := []ResolvedPackage{
+		{Name: "git", Version: "2.45.2-r0", Repo: "main"},
+		{Name: "yq", Version: "4.44.1-r2", Repo: "community"},
+		{Name: "zlib", Version: "1.3.1-r1", Repo: "main"},
+	}
+	if !slices.Equal(resolved, expected) {
+		t.Errorf("Resolve() = %v, want %v", resolved, expected)
+	}
+}
+
+func TestResolverSnapshot(t *testing.T) {
+	client := NewAlpineClient()
+	stubIndex(client, "3.20", "x86_64", &apkutils.PackageInfo{Name: "qemu", Version: "2.0-r0"})
+	mirror := "https://snapshot.example.com/alpine"
+	client.indexCache[mirror+":3.20:x86_64:main"] = map[string]*apkutils.PackageInfo{
+		"qemu": {Name: "qemu", Version: "1.0-r0"},
+	}
+	client.indexCache[mirror+":3.20:x86_64:community"] = map[string]*apkutils.PackageInfo{}
+
+	resolver := NewResolver(client, "3.20")
+	resolver.SetArch("x86_64")
+	resolver.SetSnapshot(mirror + "/")
+
+	resolved, err := resolver.Resolve([]PackageSpec{{Name: "qemu"}})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if len(resolved) != 1 || resolved[0].Version != "1.0-r0" {
+		t.Errorf("Resolve() = %v, want qemu 1.0-r0 from the snapshot", resolved)
+	}
+}
+
+func TestResolverSnapshotFetchesFromMirror(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	client := NewAlpineClient()
+	client.httpClient = server.Client()
+
+	resolver := NewResolver(client, "3.20")
+	resolver.SetArch("x86_64")
+	resolver.SetSnapshot(server.URL + "/snapshots/2024-06-01/")
+
+	if _, err := resolver.Resolve([]PackageSpec{{Name: "qemu"}}); err == nil {
+		t.Fatal("Resolve() expected error from stub mirror")
+	}
+
+	expected := []string{"/snapshots/2024-06-01/v3.20/main/x86_64/APKINDEX.tar.gz"}
+	if !slices.Equal(requested, expected) {
+		t.Errorf("requested = %v, want %v", requested, expected)
+	}
+}
+
+func TestResolverRepositoryFlags(t *testing.T) {
+	resolver := NewResolver(NewAlpineClient(), "3.20")
+	if flags := resolver.RepositoryFlags(); flags != "" {
+		t.Errorf("RepositoryFlags() without snapshot = %q, want empty", flags)
+	}
+
+	resolver.SetSnapshot("https://snapshot.example.com/alpine/")
+	expected := "--repositories-file=/dev/null" +
+		" --repository=https://snapshot.example.com/alpine/v3.20/main" +
+		" --repository=https://snapshot.example.com/alpine/v3.20/community"
+	if flags := resolver.RepositoryFlags(); flags != expected {
+		t.Errorf("RepositoryFlags() = %q, want %q", flags, expected)
+	}
+}
+
+func TestResolverUnsupportedArch(t *testing.T) {
+	resolver := NewResolver(NewAlpineClient(), "3.20")
+	resolver.SetArch("arm64")
+
+	if _, err := resolver.Resolve([]PackageSpec{{Name: "qemu"}}); err == nil {
+		t.Error("Resolve() expected error for unsupported arch")
+	}
+}
+
+func TestHostArch(t *testing.T) {
+	if err := ValidateArch(HostArch()); err != nil {
+		t.Errorf("HostArch() returned unsupported arch: %v", err)
+	}
+}
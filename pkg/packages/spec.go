@@ -2,12 +2,14 @@ package packages
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
 type PackageSpec struct {
 	Name    string
 	Version string
+	Arch    []string
 }
 
 func ParsePackageSpec(spec string) (PackageSpec, error) {
@@ -16,27 +18,126 @@ func ParsePackageSpec(spec string) (PackageSpec, error) {
 		return PackageSpec{}, fmt.Errorf("empty package specification")
 	}
 
-	if idx := strings.Index(spec, "="); idx > 0 {
-		return PackageSpec{}, fmt.Errorf("package versions cannot be provided")
+	name, arch, err := parseArchConstraint(spec)
+	if err != nil {
+		return PackageSpec{}, err
+	}
+
+	name, version, pinned := strings.Cut(name, "=")
+	name = strings.TrimSpace(name)
+	version = strings.TrimSpace(version)
+	if name == "" {
+		return PackageSpec{}, fmt.Errorf("missing package name in %q", spec)
+	}
+	if pinned && version == "" {
+		return PackageSpec{}, fmt.Errorf("empty version pin in %q", spec)
 	}
 
 	return PackageSpec{
-		Name: spec,
+		Name:    name,
+		Version: version,
+		Arch:    arch,
 	}, nil
 }
 
+// String formats the spec as name or name=version, without arch constraints.
+func (s PackageSpec) String() string {
+	if s.Version == "" {
+		return s.Name
+	}
+	return s.Name + "=" + s.Version
+}
+
+func parseArchConstraint(spec string) (string, []string, error) {
+	start := strings.Index(spec, "[")
+	if start == -1 {
+		return spec, nil, nil
+	}
+	if !strings.HasSuffix(spec, "]") {
+		return "", nil, fmt.Errorf("unterminated arch constraint in %q", spec)
+	}
+
+	name := strings.TrimSpace(spec[:start])
+	if name == "" {
+		return "", nil, fmt.Errorf("missing package name in %q", spec)
+	}
+
+	var arch []string
+	for _, a := range strings.Split(spec[start+1:len(spec)-1], ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			return "", nil, fmt.Errorf("empty arch constraint in %q", spec)
+		}
+		arch = append(arch, a)
+	}
+	return name, arch, nil
+}
+
+// ParsePackageSpecs parses specs and merges those naming the same package in
+// the order they first appear. A version pin takes precedence over an unpinned
+// spec, and different pins for the same package are an error.
 func ParsePackageSpecs(specs []string) ([]PackageSpec, error) {
 	if len(specs) == 0 {
 		return nil, nil
 	}
 
 	result := make([]PackageSpec, 0, len(specs))
+	seen := make(map[string]int)
 	for i, spec := range specs {
 		parsed, err := ParsePackageSpec(spec)
 		if err != nil {
 			return nil, fmt.Errorf("parsing package spec at index %d: %w", i, err)
 		}
+		if idx, ok := seen[parsed.Name]; ok {
+			merged, err := mergePackageSpecs(result[idx], parsed)
+			if err != nil {
+				return nil, fmt.Errorf("parsing package spec at index %d: %w", i, err)
+			}
+			result[idx] = merged
+			continue
+		}
+		seen[parsed.Name] = len(result)
 		result = append(result, parsed)
 	}
 	return result, nil
 }
+
+func mergePackageSpecs(existing, duplicate PackageSpec) (PackageSpec, error) {
+	switch {
+	case existing.Version == "":
+		existing.Version = duplicate.Version
+	case duplicate.Version != "" && duplicate.Version != existing.Version:
+		return PackageSpec{}, fmt.Errorf("conflicting pins for %s: %s and %s", existing.Name, existing.Version, duplicate.Version)
+	}
+
+	if len(existing.Arch) == 0 || len(duplicate.Arch) == 0 {
+		existing.Arch = nil
+		return existing, nil
+	}
+	for _, arch := range duplicate.Arch {
+		if !slices.Contains(existing.Arch, arch) {
+			existing.Arch = append(existing.Arch, arch)
+		}
+	}
+	return existing, nil
+}
+
+func GroupByArch(specs []string) ([]string, map[string][]string, error) {
+	parsed, err := ParsePackageSpecs(specs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var common []string
+	byArch := make(map[string][]string)
+	for _, spec := range parsed {
+		if len(spec.Arch) == 0 {
+			common = append(common, spec.String())
+			continue
+		}
+		for _, arch := range spec.Arch {
+			byArch[arch] = append(byArch[arch], spec.String())
+		}
+	}
+	return common, byArch, nil
+}
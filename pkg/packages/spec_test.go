@@ -11,6 +11,7 @@ func TestParsePackageSpec(t *testing.T) {
 		spec        string
 		wantName    string
 		wantVersion string
+		wantArch    []string
 		wantErr     bool
 		errMsg      string
 	}{
@@ -27,10 +28,53 @@ func TestParsePackageSpec(t *testing.T) {
 			wantErr:  false,
 		},
 		{
-			name:    "package with version equals sign",
-			spec:    "package=1.0",
+			name:        "package with version pin",
+			spec:        "package=1.0-r0",
+			wantName:    "package",
+			wantVersion: "1.0-r0",
+		},
+		{
+			name:        "version pin with arch constraint",
+			spec:        "qemu-aarch64=8.2.0-r0[arm64]",
+			wantName:    "qemu-aarch64",
+			wantVersion: "8.2.0-r0",
+			wantArch:    []string{"arm64"},
+		},
+		{
+			name:    "empty version pin",
+			spec:    "package=",
+			wantErr: true,
+			errMsg:  "empty version pin in \"package=\"",
+		},
+		{
+			name:    "missing package name",
+			spec:    "=1.0",
+			wantErr: true,
+			errMsg:  "missing package name in \"=1.0\"",
+		},
+		{
+			name:     "package with arch constraint",
+			spec:     "qemu-aarch64[arm64]",
+			wantName: "qemu-aarch64",
+			wantArch: []string{"arm64"},
+		},
+		{
+			name:     "package with multiple arch constraints",
+			spec:     "libfoo[amd64, arm64]",
+			wantName: "libfoo",
+			wantArch: []string{"amd64", "arm64"},
+		},
+		{
+			name:    "unterminated arch constraint",
+			spec:    "libfoo[arm64",
+			wantErr: true,
+			errMsg:  "unterminated arch constraint in \"libfoo[arm64\"",
+		},
+		{
+			name:    "empty arch constraint",
+			spec:    "libfoo[]",
 			wantErr: true,
-			errMsg:  "package versions cannot be provided",
+			errMsg:  "empty arch constraint in \"libfoo[]\"",
 		},
 		{
 			name:    "empty spec",
@@ -71,6 +115,9 @@ func TestParsePackageSpec(t *testing.T) {
 			if got.Version != tt.wantVersion {
 				t.Errorf("Version = %q, want %q", got.Version, tt.wantVersion)
 			}
+			if strings.Join(got.Arch, ",") != strings.Join(tt.wantArch, ",") {
+				t.Errorf("Arch = %v, want %v", got.Arch, tt.wantArch)
+			}
 		})
 	}
 }
@@ -109,13 +156,13 @@ func TestParsePackageSpecs(t *testing.T) {
 		},
 		{
 			name:        "one invalid spec",
-			specs:       []string{"git", "bad=1.0"},
+			specs:       []string{"git", "bad="},
 			wantErr:     true,
 			errContains: "parsing package spec at index 1",
 		},
 		{
 			name:        "first spec invalid",
-			specs:       []string{"bad=1.0", "git"},
+			specs:       []string{"bad=", "git"},
 			wantErr:     true,
 			errContains: "parsing package spec at index 0",
 		},
@@ -146,3 +193,104 @@ func TestParsePackageSpecs(t *testing.T) {
 		})
 	}
 }
+
+func TestGroupByArch(t *testing.T) {
+	common, byArch, err := GroupByArch([]string{"git", "qemu-aarch64[arm64]", "libfoo[amd64,arm64]", "curl"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Join(common, ",") != "git,curl" {
+		t.Errorf("common = %v, want [git curl]", common)
+	}
+	if strings.Join(byArch["arm64"], ",") != "qemu-aarch64,libfoo" {
+		t.Errorf("byArch[arm64] = %v, want [qemu-aarch64 libfoo]", byArch["arm64"])
+	}
+	if strings.Join(byArch["amd64"], ",") != "libfoo" {
+		t.Errorf("byArch[amd64] = %v, want [libfoo]", byArch["amd64"])
+	}
+}
+
+func TestParsePackageSpecsDedup(t *testing.T) {
+	tests := []struct {
+		name     string
+		specs    []string
+		expected []string
+	}{
+		{
+			name:     "exact duplicates",
+			specs:    []string{"git", "curl", "git"},
+			expected: []string{"git", "curl"},
+		},
+		{
+			name:     "unconstrained wins over arch constraint",
+			specs:    []string{"qemu[arm64]", "git", "qemu"},
+			expected: []string{"qemu", "git"},
+		},
+		{
+			name:     "arch constraints are merged",
+			specs:    []string{"libfoo[arm64]", "libfoo[amd64,arm64]"},
+			expected: []string{"libfoo[arm64,amd64]"},
+		},
+		{
+			name:     "pin wins over unpinned spec",
+			specs:    []string{"git", "curl", "git=2.45.2-r0"},
+			expected: []string{"git=2.45.2-r0", "curl"},
+		},
+		{
+			name:     "unpinned spec keeps earlier pin",
+			specs:    []string{"git=2.45.2-r0", "git"},
+			expected: []string{"git=2.45.2-r0"},
+		},
+		{
+			name:     "identical pins are merged",
+			specs:    []string{"git=2.45.2-r0[arm64]", "git=2.45.2-r0"},
+			expected: []string{"git=2.45.2-r0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePackageSpecs(tt.specs)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var formatted []string
+			for _, spec := range got {
+				if len(spec.Arch) == 0 {
+					formatted = append(formatted, spec.String())
+					continue
+				}
+				formatted = append(formatted, spec.String()+"["+strings.Join(spec.Arch, ",")+"]")
+			}
+			if strings.Join(formatted, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("ParsePackageSpecs() = %v, want %v", formatted, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParsePackageSpecsConflictingPins(t *testing.T) {
+	_, err := ParsePackageSpecs([]string{"git=2.45.2-r0", "curl", "git=2.44.0-r0"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "conflicting pins for git: 2.45.2-r0 and 2.44.0-r0") {
+		t.Errorf("error = %q, want conflicting pins error", err.Error())
+	}
+}
+
+func TestGroupByArchKeepsPins(t *testing.T) {
+	common, byArch, err := GroupByArch([]string{"git=2.45.2-r0", "qemu-aarch64=8.2.0-r0[arm64]"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Join(common, ",") != "git=2.45.2-r0" {
+		t.Errorf("common = %v, want [git=2.45.2-r0]", common)
+	}
+	if strings.Join(byArch["arm64"], ",") != "qemu-aarch64=8.2.0-r0" {
+		t.Errorf("byArch[arm64] = %v, want [qemu-aarch64=8.2.0-r0]", byArch["arm64"])
+	}
+}
@@ -1,7 +1,12 @@
 package pipelines
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/greboid/dfo/pkg/util"
@@ -23,8 +28,15 @@ type Pipeline func(params map[string]any) (PipelineResult, error)
 var Registry = map[string]Pipeline{
 	"create-user":              CreateUser,
 	"set-ownership":            SetOwnership,
+	"set-permissions":          SetPermissions,
+	"remove-paths":             RemovePaths,
+	"trim-rootfs":              TrimRootfs,
+	"write-file":               WriteFile,
+	"build-info":               BuildInfo,
 	"download-verify-extract":  DownloadVerifyExtract,
 	"make-executable":          MakeExecutable,
+	"verify-static":            VerifyStatic,
+	"validate-config-file":     ValidateConfigFile,
 	"clone":                    Clone,
 	"clone-and-build-go":       CloneAndBuildGo,
 	"build-go-static":          BuildGo,
@@ -32,12 +44,27 @@ var Registry = map[string]Pipeline{
 	"clone-and-build-rust":     CloneAndBuildRust,
 	"clone-and-build-make":     CloneAndBuildMake,
 	"clone-and-build-autoconf": CloneAndBuildAutoconf,
+	"clone-and-build-node":     CloneAndBuildNode,
+	"clone-and-build-python":   CloneAndBuildPython,
+	"set-file-attributes":      SetFileAttributes,
+	"harden-writable-dirs":     HardenWritableDirs,
 	"setup-users-groups":       SetupUsersGroups,
 	"create-directories":       CreateDirectories,
 	"copy-files":               CopyFiles,
+	"install-github-release":   InstallGitHubRelease,
+	"run-script":               RunScript,
+	"apk-world":                ApkWorld,
+	"install-toolchain":        InstallToolchain,
+	"run-migrations":           RunMigrations,
+	"write-sysctls":            WriteSysctls,
+	"setup-nsswitch":           SetupNsswitch,
 }
 
 func CreateUser(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("create-user", params); err != nil {
+		return PipelineResult{}, err
+	}
+
 	username, err := util.ValidateStringParam(params, "username")
 	if err != nil {
 		return PipelineResult{}, err
@@ -53,11 +80,44 @@ func CreateUser(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
+	home, err := util.ValidateOptionalStringParamStrict(params, "home", "")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	shell, err := util.ValidateOptionalStringParamStrict(params, "shell", "")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	noCreateHome, err := util.ValidateOptionalBoolParam(params, "no-create-home", false)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	adduserFlags := "-D"
+	if home != "" {
+		adduserFlags += " -h " + home
+	}
+	if shell != "" {
+		adduserFlags += " -s " + shell
+	}
+	if noCreateHome {
+		adduserFlags += " -H"
+	}
+
+	commands := []string{
+		fmt.Sprintf("addgroup -g %d %s", gidInt, username),
+		fmt.Sprintf("adduser %s -u %d -G %s %s", adduserFlags, uidInt, username, username),
+	}
+	for _, group := range util.ExtractStringSlice(params, "groups") {
+		commands = append(commands, fmt.Sprintf("addgroup %s %s", username, group))
+	}
+
 	return PipelineResult{
 		Steps: []Step{{
-			Name: "Create application user",
-			Content: fmt.Sprintf("RUN addgroup -g %d %s && \\\n    adduser -D -u %d -G %s %s\n",
-				gidInt, username, uidInt, username, username),
+			Name:    "Create application user",
+			Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(commands, util.ShellSeparatorFailFast)),
 		}},
 		BuildDeps: []string{"busybox"},
 	}, nil
@@ -89,7 +149,7 @@ func SetOwnership(params map[string]any) (PipelineResult, error) {
 }
 
 func DownloadVerifyExtract(params map[string]any) (PipelineResult, error) {
-	if err := ValidateParams("download-verify-extract", params); err != nil {
+	if err := validateDownloadVerifyExtract(params); err != nil {
 		return PipelineResult{}, err
 	}
 
@@ -116,13 +176,42 @@ func DownloadVerifyExtract(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
-	hasChecksum := checksum != ""
+	algorithmName, err := util.ValidateOptionalStringParamStrict(params, "checksum-algorithm", "sha256")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	algorithm, err := parseChecksumAlgorithm(algorithmName)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	checksums := util.ExtractStringSlice(params, "checksums")
+	hasChecksum := checksum != "" || len(checksums) > 0
 	hasChecksumURL := checksumURL != ""
 
 	if err := util.ValidateMutuallyExclusiveRequired(hasChecksum, hasChecksumURL, "checksum", "checksum-url"); err != nil {
 		return PipelineResult{}, err
 	}
 
+	retries, err := util.ValidateOptionalIntParam(params, "retries", 0)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	curl := "curl " + util.CurlFlags(retries)
+
+	signatureURL, err := util.ValidateOptionalStringParamStrict(params, "signature-url", "")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	gpgKey, err := util.ValidateOptionalStringParamStrict(params, "gpg-key", "")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	keyserver, err := util.ValidateOptionalStringParamStrict(params, "keyserver", defaultKeyserver)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	extractDir, err := util.ValidateOptionalStringParamStrict(params, "extract-dir", "")
 	if err != nil {
 		return PipelineResult{}, err
@@ -136,43 +225,67 @@ func DownloadVerifyExtract(params map[string]any) (PipelineResult, error) {
 		if err := validateArchiveFormat(destination); err != nil {
 			return PipelineResult{}, err
 		}
+		if pathWithin(destination, extractDir) {
+			return PipelineResult{}, fmt.Errorf("destination %q must not be inside extract-dir %q", destination, extractDir)
+		}
 	}
 
 	var cmdParts []string
 
 	if hasChecksumURL {
 		checksumDest := destination + ".checksum"
-		cmdParts = append(cmdParts, fmt.Sprintf("curl -fsSL -o %s %q", checksumDest, checksumURL))
+		cmdParts = append(cmdParts, fmt.Sprintf("%s -o %s %q", curl, checksumDest, checksumURL))
 	}
 
-	cmdParts = append(cmdParts, fmt.Sprintf("curl -fsSL -o %s %q", destination, url))
+	downloads := []string{fmt.Sprintf("%s -o %s %q", curl, destination, url)}
+	for _, mirror := range util.ExtractStringSlice(params, "mirrors") {
+		downloads = append(downloads, fmt.Sprintf("%s -o %s %q", curl, destination, mirror))
+	}
+	cmdParts = append(cmdParts, strings.Join(downloads, " || "))
+
+	if guard := algorithm.requireCommand(); guard != "" {
+		cmdParts = append(cmdParts, guard)
+	}
 
 	var verifyCmd string
 	if hasChecksumURL {
 		checksumDest := destination + ".checksum"
 		if checksumPattern != "" {
-			verifyCmd = fmt.Sprintf("echo \"$(grep %q %s | awk '{print $1}') *%s\" | sha256sum -wc -",
-				checksumPattern, checksumDest, destination)
+			verifyCmd = algorithm.verifyExpression(fmt.Sprintf("$(grep %q %s | awk '{print $1}')", checksumPattern, checksumDest), destination)
 		} else {
-			verifyCmd = fmt.Sprintf("echo \"$(cat %s | awk '{print $1}') *%s\" | sha256sum -wc -",
-				checksumDest, destination)
+			verifyCmd = algorithm.verifyExpression(fmt.Sprintf("$(%s)", basenameChecksumCommand(checksumDest, path.Base(destination))), destination)
 		}
+	} else if len(checksums) > 0 {
+		verifyCmd = verifyAnyChecksum(algorithm, checksums, destination)
 	} else {
-		verifyCmd = fmt.Sprintf("echo %q | sha256sum -c", checksum+"  "+destination)
+		verifyCmd = algorithm.verifyLiteral(checksum, destination)
 	}
 	cmdParts = append(cmdParts, verifyCmd)
 
+	if signatureURL != "" {
+		cmdParts = append(cmdParts, buildSignatureVerifyCommands(curl, destination, signatureURL, gpgKey, keyserver)...)
+	}
+
 	if extractDir != "" {
 		extractCmd := buildExtractCommand(destination, extractDir, stripComponents)
 		cmdParts = append(cmdParts, extractCmd)
 	}
 
-	combinedCmd := strings.Join(cmdParts, " && \\\n    ")
+	combinedCmd := util.JoinShellCommands(cmdParts, util.ShellSeparatorFailFast)
 
 	buildDeps := []string{"busybox", "curl"}
+	if algorithm.buildDep != "" {
+		buildDeps = append(buildDeps, algorithm.buildDep)
+	}
+	if signatureURL != "" {
+		buildDeps = append(buildDeps, "gnupg")
+	}
 	if extractDir != "" && strings.HasSuffix(destination, ".zip") {
 		buildDeps = append(buildDeps, "unzip")
 	}
+	if extractDir != "" && strings.HasSuffix(destination, ".7z") {
+		buildDeps = append(buildDeps, "7zip")
+	}
 
 	return PipelineResult{
 		Steps: []Step{
@@ -185,6 +298,154 @@ func DownloadVerifyExtract(params map[string]any) (PipelineResult, error) {
 	}, nil
 }
 
+func validateDownloadVerifyExtract(params map[string]any) error {
+	var problems []string
+
+	if err := ValidateSignature(Signatures["download-verify-extract"], params); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	destination, _ := params["destination"].(string)
+	if extractDir, _ := params["extract-dir"].(string); extractDir != "" && destination != "" {
+		if err := validateArchiveFormat(destination); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if retries, err := util.ValidateOptionalIntParam(params, "retries", 0); err == nil && retries < 0 {
+		problems = append(problems, fmt.Sprintf("retries must be non-negative, got %d", retries))
+	}
+
+	signatureURL, _ := params["signature-url"].(string)
+	gpgKey, _ := params["gpg-key"].(string)
+	if signatureURL != "" && gpgKey == "" {
+		problems = append(problems, "signature-url requires gpg-key (a key fingerprint or a URL to the public key)")
+	}
+	if gpgKey != "" && !isGPGKeyURL(gpgKey) && !gpgFingerprintPattern.MatchString(gpgKey) {
+		problems = append(problems, fmt.Sprintf("gpg-key %q must be a hex key fingerprint or an http(s) URL", gpgKey))
+	}
+	if keyserver, _ := params["keyserver"].(string); keyserver != "" {
+		if !keyserverPattern.MatchString(keyserver) {
+			problems = append(problems, fmt.Sprintf("keyserver %q must be an hkp:// or hkps:// host", keyserver))
+		}
+		if gpgKey == "" || isGPGKeyURL(gpgKey) {
+			problems = append(problems, "keyserver requires gpg-key to be a key fingerprint")
+		}
+	}
+
+	if checksums, ok := params["checksums"].([]any); ok && len(checksums) == 0 {
+		problems = append(problems, "checksums must contain at least one checksum")
+	}
+
+	if algorithmName, ok := params["checksum-algorithm"].(string); ok {
+		if _, err := parseChecksumAlgorithm(algorithmName); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("pipeline %q: %s", "download-verify-extract", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+const defaultKeyserver = "hkps://keys.openpgp.org"
+
+var (
+	gpgFingerprintPattern = regexp.MustCompile(`^(0x)?[0-9A-Fa-f]{16,40}$`)
+	keyserverPattern      = regexp.MustCompile(`^hkps?://[A-Za-z0-9.-]+(:[0-9]+)?$`)
+)
+
+func isGPGKeyURL(key string) bool {
+	return strings.HasPrefix(key, "https://") || strings.HasPrefix(key, "http://")
+}
+
+// buildSignatureVerifyCommands imports gpgKey and checks the detached signature.
+// A URL key is fetched with curl; a fingerprint is received from keyserver.
+func buildSignatureVerifyCommands(curl, destination, signatureURL, gpgKey, keyserver string) []string {
+	signatureDest := destination + ".sig"
+
+	importCmd := fmt.Sprintf("gpg --batch --keyserver %s --recv-keys %s", keyserver, gpgKey)
+	if isGPGKeyURL(gpgKey) {
+		importCmd = fmt.Sprintf("%s %q | gpg --batch --import", curl, gpgKey)
+	}
+
+	return []string{
+		fmt.Sprintf("%s -o %s %q", curl, signatureDest, signatureURL),
+		"export GNUPGHOME=\"$(mktemp -d)\"",
+		importCmd,
+		fmt.Sprintf("gpg --batch --verify %s %s", signatureDest, destination),
+		"rm -rf \"$GNUPGHOME\" " + signatureDest,
+	}
+}
+
+type checksumAlgorithm struct {
+	name     string
+	binary   string
+	digest   string
+	buildDep string
+}
+
+var checksumAlgorithms = map[string]checksumAlgorithm{
+	"sha256":   {name: "sha256", binary: "sha256sum"},
+	"sha512":   {name: "sha512", binary: "sha512sum"},
+	"sha1":     {name: "sha1", binary: "sha1sum"},
+	"blake2":   {name: "blake2", binary: "b2sum", buildDep: "coreutils"},
+	"b2sum":    {name: "blake2", binary: "b2sum", buildDep: "coreutils"},
+	"sha3-256": {name: "sha3-256", binary: "openssl", digest: "sha3-256", buildDep: "openssl"},
+}
+
+func parseChecksumAlgorithm(name string) (checksumAlgorithm, error) {
+	algorithm, ok := checksumAlgorithms[name]
+	if !ok {
+		supported := make([]string, 0, len(checksumAlgorithms))
+		for key := range checksumAlgorithms {
+			supported = append(supported, key)
+		}
+		sort.Strings(supported)
+		return checksumAlgorithm{}, fmt.Errorf("unsupported checksum-algorithm %q (supported: %s)", name, strings.Join(supported, ", "))
+	}
+	return algorithm, nil
+}
+
+func (a checksumAlgorithm) requireCommand() string {
+	if a.buildDep == "" {
+		return ""
+	}
+	return fmt.Sprintf("{ command -v %s >/dev/null 2>&1 || { echo \"checksum algorithm %s requires %s, which is not available on the base image\" >&2; exit 1; }; }",
+		a.binary, a.name, a.binary)
+}
+
+func (a checksumAlgorithm) verifyLiteral(checksum, file string) string {
+	if a.digest != "" {
+		return a.verifyExpression(checksum, file)
+	}
+	return fmt.Sprintf("echo %q | %s -c", checksum+"  "+file, a.binary)
+}
+
+func (a checksumAlgorithm) verifyExpression(expected, file string) string {
+	if a.digest != "" {
+		return fmt.Sprintf("test \"%s\" = \"$(openssl dgst -%s -r %s | awk '{print $1}')\"", expected, a.digest, file)
+	}
+	return fmt.Sprintf("echo \"%s *%s\" | %s -wc -", expected, file, a.binary)
+}
+
+func verifyAnyChecksum(algorithm checksumAlgorithm, checksums []string, file string) string {
+	if len(checksums) == 1 {
+		return algorithm.verifyLiteral(checksums[0], file)
+	}
+	verifications := make([]string, 0, len(checksums))
+	for _, checksum := range checksums {
+		verifications = append(verifications, algorithm.verifyLiteral(checksum, file))
+	}
+	return fmt.Sprintf("{ %s; }", strings.Join(verifications, " || "))
+}
+
+func basenameChecksumCommand(checksumFile, name string) string {
+	return fmt.Sprintf("awk -v f=%q 'NR == 1 { first = $1 } $2 == f || $2 == \"*\" f { print $1; found = 1; exit } END { if (!found) print first }' %s",
+		name, checksumFile)
+}
+
 func MakeExecutable(params map[string]any) (PipelineResult, error) {
 	path, err := util.ValidateStringParam(params, "path")
 	if err != nil {
@@ -200,6 +461,58 @@ func MakeExecutable(params map[string]any) (PipelineResult, error) {
 	}, nil
 }
 
+func VerifyStatic(params map[string]any) (PipelineResult, error) {
+	path, err := util.ValidateStringParam(params, "path")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Verify static binary",
+			Content: fmt.Sprintf("RUN ldd %s 2>&1 | grep -q \"not a dynamic executable\" || (ldd %s; exit 1)\n", path, path),
+		}},
+		BuildDeps: []string{"busybox", "musl-utils"},
+	}, nil
+}
+
+var configFileValidators = map[string]struct {
+	command   string
+	buildDeps []string
+}{
+	"json": {command: "jq empty %s", buildDeps: []string{"jq"}},
+	"yaml": {command: "python3 -c 'import sys, yaml; yaml.safe_load(open(sys.argv[1]))' %s", buildDeps: []string{"python3", "py3-yaml"}},
+}
+
+func ValidateConfigFile(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("validate-config-file", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	path, err := util.ValidateStringParam(params, "path")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	format, err := util.ValidateStringParam(params, "format")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	validator, ok := configFileValidators[format]
+	if !ok {
+		return PipelineResult{}, fmt.Errorf("unsupported format %q (must be one of: %s)", format, strings.Join(util.SortedKeys(configFileValidators), ", "))
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Validate config file",
+			Content: fmt.Sprintf("RUN %s\n", fmt.Sprintf(validator.command, path)),
+		}},
+		BuildDeps: append([]string{"busybox"}, validator.buildDeps...),
+	}, nil
+}
+
 func buildExtractCommand(destination, extractDir string, stripComponents int) string {
 	mkdirCmd := fmt.Sprintf("mkdir -p %q", extractDir)
 
@@ -207,6 +520,10 @@ func buildExtractCommand(destination, extractDir string, stripComponents int) st
 		return fmt.Sprintf("%s && unzip -q %q -d %q", mkdirCmd, destination, extractDir)
 	}
 
+	if strings.HasSuffix(destination, ".7z") {
+		return fmt.Sprintf("%s && 7z x -y -o%q %q", mkdirCmd, extractDir, destination)
+	}
+
 	if isTarArchive(destination) {
 		return fmt.Sprintf("%s && tar -xf %q -C %q --strip-components=%d",
 			mkdirCmd, destination, extractDir, stripComponents)
@@ -231,13 +548,24 @@ func isTarArchive(filename string) bool {
 }
 
 func validateArchiveFormat(filename string) error {
-	if strings.HasSuffix(filename, ".zip") {
+	if strings.HasSuffix(filename, ".zip") || strings.HasSuffix(filename, ".7z") {
 		return nil
 	}
 	if isTarArchive(filename) {
 		return nil
 	}
-	return fmt.Errorf("unsupported archive format: %s (supported: .zip, .tar, .tar.gz, .tgz, .tar.bz2, .tbz2, .tar.xz, .txz)", filename)
+	return fmt.Errorf("unsupported archive format: %s (supported: .zip, .7z, .tar, .tar.gz, .tgz, .tar.bz2, .tbz2, .tar.xz, .txz)", filename)
+}
+
+func pathWithin(p, dir string) bool {
+	p, dir = path.Clean(p), path.Clean(dir)
+	if path.IsAbs(p) != path.IsAbs(dir) {
+		return false
+	}
+	if dir == "/" || dir == "." {
+		return p != ".." && !strings.HasPrefix(p, "../")
+	}
+	return p == dir || strings.HasPrefix(p, dir+"/")
 }
 
 func ExtractGitHubOwnerRepo(repoURL string) string {
@@ -272,27 +600,103 @@ func extractRepoWorkdir(repo string, params map[string]any) (string, error) {
 	return util.ValidateOptionalStringParamStrict(params, "workdir", defaultWorkdir)
 }
 
+func extractMakeSteps(params map[string]any) ([]string, error) {
+	jobs, err := util.ValidateOptionalIntParam(params, "jobs", 0)
+	if err != nil {
+		return nil, err
+	}
+	if jobs < 0 {
+		return nil, fmt.Errorf("jobs must be non-negative (0 for $(nproc)), got %d", jobs)
+	}
+
+	if makeSteps := util.ExtractStringSlice(params, "make-steps"); len(makeSteps) > 0 {
+		return makeSteps, nil
+	}
+
+	jobsFlag := "-j$(nproc)"
+	if jobs > 0 {
+		jobsFlag = fmt.Sprintf("-j%d", jobs)
+	}
+	return []string{"make " + jobsFlag, "make install"}, nil
+}
+
 func generateMakeStep(workdir string, makeSteps []string) Step {
-	makeCmd := strings.Join(makeSteps, "; \\\n    ")
+	makeCmd := util.JoinShellCommands(makeSteps, util.ShellSeparator)
 	return Step{
 		Name:    "Build with make",
 		Content: fmt.Sprintf("WORKDIR %s\nRUN %s\n", workdir, makeCmd),
 	}
 }
 
-func generateStripStep(workdir string) Step {
+var stripModes = map[string]string{
+	"all":      "strip",
+	"debug":    "strip --strip-debug",
+	"unneeded": "strip --strip-unneeded",
+}
+
+func parseStripMode(params map[string]any) (string, error) {
+	mode, err := util.ValidateOptionalStringParamStrict(params, "strip-mode", "all")
+	if err != nil {
+		return "", err
+	}
+	command, ok := stripModes[mode]
+	if !ok {
+		return "", fmt.Errorf("unsupported strip-mode %q (must be one of: %s)", mode, strings.Join(util.SortedKeys(stripModes), ", "))
+	}
+	return command, nil
+}
+
+func generateStripStep(workdir, stripCommand string) Step {
 	return Step{
 		Name:    "Strip binaries",
-		Content: fmt.Sprintf("RUN find %s -type f -executable -exec strip {} + 2>/dev/null || true\n", workdir),
+		Content: fmt.Sprintf("RUN find %s -type f -executable -exec %s {} + 2>/dev/null || true\n", workdir, stripCommand),
+	}
+}
+
+var patchModes = map[string]string{
+	"patch":     "patch",
+	"git-apply": "git",
+}
+
+type patchOptions struct {
+	strip int
+	mode  string
+}
+
+func extractPatchOptions(params map[string]any) (patchOptions, error) {
+	strip, err := util.ValidateOptionalIntParam(params, "patch-strip", 1)
+	if err != nil {
+		return patchOptions{}, err
 	}
+	if strip < 0 {
+		return patchOptions{}, fmt.Errorf("patch-strip must be non-negative, got %d", strip)
+	}
+
+	mode, err := util.ValidateOptionalStringParamStrict(params, "patch-mode", "patch")
+	if err != nil {
+		return patchOptions{}, err
+	}
+	if _, ok := patchModes[mode]; !ok {
+		return patchOptions{}, fmt.Errorf("unsupported patch-mode %q (must be one of: %s)", mode, strings.Join(util.SortedKeys(patchModes), ", "))
+	}
+
+	return patchOptions{strip: strip, mode: mode}, nil
+}
+
+func (o patchOptions) buildDep() string {
+	return patchModes[o.mode]
 }
 
-func generatePatchSteps(patches []string, workdir string) []Step {
+func generatePatchSteps(patches []string, workdir string, opts patchOptions) []Step {
 	var steps []Step
 	for _, patch := range patches {
+		applyCmd := fmt.Sprintf("patch -p%d < %s", opts.strip, patch)
+		if opts.mode == "git-apply" {
+			applyCmd = fmt.Sprintf("git apply -p%d %s", opts.strip, patch)
+		}
 		steps = append(steps, Step{
 			Name:    fmt.Sprintf("Apply patch %s", patch),
-			Content: fmt.Sprintf("COPY %s %s/\nRUN cd %s && patch -p1 < %s\n", patch, workdir, workdir, patch),
+			Content: fmt.Sprintf("COPY %s %s/\nRUN cd %s && %s\n", patch, workdir, workdir, applyCmd),
 		})
 	}
 	return steps
@@ -327,9 +731,12 @@ func generateGoInstallSteps(tools []string) []Step {
 	return steps
 }
 
-func generateGoInstallLicenseSteps(tools []string, output string) []Step {
+func extractNoticesPath(params map[string]any, output string) (string, error) {
+	return util.ValidateOptionalStringParamStrict(params, "notices", "/notices"+output)
+}
+
+func generateGoInstallLicenseSteps(tools []string, noticesPath string) []Step {
 	var steps []Step
-	noticesPath := "/notices" + output
 
 	for _, tool := range tools {
 		pkg := tool
@@ -345,17 +752,47 @@ func generateGoInstallLicenseSteps(tools []string, output string) []Step {
 	return steps
 }
 
-func generateCloneStep(repo, tag, commit, workdir string) Step {
-	var cloneCmd string
+func extractCloneDepth(params map[string]any) (int, error) {
+	depth, err := util.ValidateOptionalIntParam(params, "depth", 1)
+	if err != nil {
+		return 0, err
+	}
+	if depth < 0 {
+		return 0, fmt.Errorf("depth must be non-negative (0 for a full clone), got %d", depth)
+	}
+	return depth, nil
+}
+
+func generateCloneStep(repo, tag, commit, workdir string, depth int, sparsePaths []string) Step {
+	sparseFlags := ""
+	if len(sparsePaths) > 0 {
+		sparseFlags = "--filter=blob:none --sparse "
+	}
+
+	var commands []string
 	if commit != "" {
-		cloneCmd = fmt.Sprintf("RUN git clone %q %s && \\\n    cd %s && \\\n    git checkout %s\n", repo, workdir, workdir, commit)
+		commands = append(commands, fmt.Sprintf("git clone %s%q %s", sparseFlags, repo, workdir))
 	} else {
-		cloneCmd = fmt.Sprintf("RUN git clone --depth=1 --branch %s %q %s\n", tag, repo, workdir)
+		depthFlag := ""
+		if depth > 0 {
+			depthFlag = fmt.Sprintf("--depth=%d ", depth)
+		}
+		commands = append(commands, fmt.Sprintf("git clone %s%s--branch %s %q %s", depthFlag, sparseFlags, tag, repo, workdir))
+	}
+
+	if len(sparsePaths) > 0 || commit != "" {
+		commands = append(commands, fmt.Sprintf("cd %s", workdir))
+	}
+	if len(sparsePaths) > 0 {
+		commands = append(commands, fmt.Sprintf("git sparse-checkout set %s", strings.Join(sparsePaths, " ")))
+	}
+	if commit != "" {
+		commands = append(commands, fmt.Sprintf("git checkout %s", commit))
 	}
 
 	return Step{
 		Name:    "Clone repository",
-		Content: cloneCmd,
+		Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(commands, util.ShellSeparatorFailFast)),
 	}
 }
 
@@ -391,13 +828,50 @@ func Clone(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, fmt.Errorf("must specify either tag or commit parameter (use tag: %%{versions.REPO_URL} to resolve version)")
 	}
 
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	return PipelineResult{
-		Steps:     []Step{generateCloneStep(repo, tag, commit, workdir)},
+		Steps:     []Step{generateCloneStep(repo, tag, commit, workdir, depth, util.ExtractStringSlice(params, "sparse-paths"))},
 		BuildDeps: []string{"git"},
 	}, nil
 }
 
-func generateGoBuildStep(pkg, output, extraLdflags, extraTags, goExperiment string, cgo bool) Step {
+// Every Go build step shares one cache id so separate stages reuse compiled
+// packages; Go keys cache entries by content, GOOS and GOARCH.
+const (
+	goBuildCacheID     = "dfo-go-build"
+	goBuildCacheTarget = "/root/.cache/go-build"
+)
+
+func goBuildCacheMount() string {
+	return fmt.Sprintf("--mount=type=cache,id=%s,target=%s", goBuildCacheID, goBuildCacheTarget)
+}
+
+func extractVersionLdflags(params map[string]any) (string, error) {
+	versionVar, err := util.ValidateOptionalStringParamStrict(params, "version-var", "")
+	if err != nil {
+		return "", err
+	}
+	versionValue, err := util.ValidateOptionalStringParamStrict(params, "version-value", "")
+	if err != nil {
+		return "", err
+	}
+	if versionVar == "" && versionValue == "" {
+		return "", nil
+	}
+	if versionVar == "" || versionValue == "" {
+		return "", fmt.Errorf("version-var and version-value must be specified together")
+	}
+	if strings.ContainsAny(versionVar+versionValue, `"'`) {
+		return "", fmt.Errorf("version-var and version-value must not contain quotes")
+	}
+	return fmt.Sprintf(`-X "%s=%s"`, versionVar, versionValue), nil
+}
+
+func generateGoBuildStep(pkg, output, extraLdflags, extraTags, goExperiment string, cgo, buildCache bool) Step {
 	ldflags := `-s -w -extldflags "-static"`
 	if extraLdflags != "" {
 		ldflags += " " + extraLdflags
@@ -416,14 +890,19 @@ func generateGoBuildStep(pkg, output, extraLdflags, extraTags, goExperiment stri
 		envVars += fmt.Sprintf(" GOEXPERIMENT=%s", goExperiment)
 	}
 
+	run := "RUN"
+	if buildCache {
+		run = fmt.Sprintf("RUN %s", goBuildCacheMount())
+		envVars += fmt.Sprintf(" GOCACHE=%s", goBuildCacheTarget)
+	}
+
 	return Step{
 		Name:    "Build binary",
-		Content: fmt.Sprintf("RUN %s go build -trimpath -tags '%s' -ldflags='%s' -o %s %s\n", envVars, tags, ldflags, output, pkg),
+		Content: fmt.Sprintf("%s %s go build -trimpath -tags '%s' -ldflags='%s' -o %s %s\n", run, envVars, tags, ldflags, output, pkg),
 	}
 }
 
-func generateLicenseStep(pkg, output string, ignore []string) Step {
-	noticesPath := "/notices" + output
+func generateLicenseStep(pkg, noticesPath string, ignore []string) Step {
 	var licenseCmd string
 	if len(ignore) > 0 {
 		ignores := strings.Builder{}
@@ -465,6 +944,11 @@ func CloneAndBuildGo(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
+	noticesPath, err := extractNoticesPath(params, output)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	tag, err := util.ValidateStringParam(params, "tag")
 	if err != nil {
 		return PipelineResult{}, fmt.Errorf("tag parameter is required (use tag: %%{versions.REPO_URL} to resolve version): %w", err)
@@ -485,6 +969,16 @@ func CloneAndBuildGo(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
+	buildCache, err := util.ValidateOptionalBoolParam(params, "build-cache", false)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	versionLdflags, err := extractVersionLdflags(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	ignore := util.ExtractStringSlice(params, "ignore")
 
 	workdir, err := extractRepoWorkdir(repo, params)
@@ -494,20 +988,32 @@ func CloneAndBuildGo(params map[string]any) (PipelineResult, error) {
 
 	patches := util.ExtractStringSlice(params, "patches")
 
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	steps := []Step{
-		generateCloneStep(repo, tag, "", workdir),
+		generateCloneStep(repo, tag, "", workdir, depth, util.ExtractStringSlice(params, "sparse-paths")),
+	}
+
+	patchOpts, err := extractPatchOptions(params)
+	if err != nil {
+		return PipelineResult{}, err
 	}
 
 	buildDeps := []string{"git", "go"}
 	if len(patches) > 0 {
-		buildDeps = append(buildDeps, "patch")
-		steps = append(steps, generatePatchSteps(patches, workdir)...)
+		if dep := patchOpts.buildDep(); !slices.Contains(buildDeps, dep) {
+			buildDeps = append(buildDeps, dep)
+		}
+		steps = append(steps, generatePatchSteps(patches, workdir, patchOpts)...)
 	}
 
 	steps = append(steps,
 		generateGoModDownloadStep(workdir),
-		generateGoBuildStep(pkg, output, "", goTags, goExperiment, cgo),
-		generateLicenseStep(pkg, output, ignore),
+		generateGoBuildStep(pkg, output, versionLdflags, goTags, goExperiment, cgo, buildCache),
+		generateLicenseStep(pkg, noticesPath, ignore),
 	)
 
 	return PipelineResult{
@@ -541,6 +1047,11 @@ func BuildGo(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
+	noticesPath, err := extractNoticesPath(params, output)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	ignore := util.ExtractStringSlice(params, "ignore")
 
 	tag, err := util.ValidateStringParam(params, "tag")
@@ -563,19 +1074,41 @@ func BuildGo(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
+	buildCache, err := util.ValidateOptionalBoolParam(params, "build-cache", false)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	versionLdflags, err := extractVersionLdflags(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	patches := util.ExtractStringSlice(params, "patches")
 	packages := util.ExtractStringSlice(params, "packages")
 	goGenerate := util.ExtractStringSlice(params, "go-generate")
 	goInstall := util.ExtractStringSlice(params, "go-install")
 
-	steps := []Step{
-		generateCloneStep(repo, tag, "", workdir),
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	steps := []Step{
+		generateCloneStep(repo, tag, "", workdir, depth, util.ExtractStringSlice(params, "sparse-paths")),
+	}
+
+	patchOpts, err := extractPatchOptions(params)
+	if err != nil {
+		return PipelineResult{}, err
 	}
 
 	buildDeps := []string{"git", "go"}
 	if len(patches) > 0 {
-		buildDeps = append(buildDeps, "patch")
-		steps = append(steps, generatePatchSteps(patches, workdir)...)
+		if dep := patchOpts.buildDep(); !slices.Contains(buildDeps, dep) {
+			buildDeps = append(buildDeps, dep)
+		}
+		steps = append(steps, generatePatchSteps(patches, workdir, patchOpts)...)
 	}
 	if len(packages) > 0 {
 		buildDeps = append(buildDeps, packages...)
@@ -592,12 +1125,12 @@ func BuildGo(params map[string]any) (PipelineResult, error) {
 	}
 
 	steps = append(steps,
-		generateGoBuildStep(pkg, output, "", goTags, goExperiment, cgo),
-		generateLicenseStep(pkg, output, ignore),
+		generateGoBuildStep(pkg, output, versionLdflags, goTags, goExperiment, cgo, buildCache),
+		generateLicenseStep(pkg, noticesPath, ignore),
 	)
 
 	if len(goInstall) > 0 {
-		steps = append(steps, generateGoInstallLicenseSteps(goInstall, output)...)
+		steps = append(steps, generateGoInstallLicenseSteps(goInstall, noticesPath)...)
 	}
 
 	return PipelineResult{
@@ -626,6 +1159,11 @@ func BuildGoOnly(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
+	noticesPath, err := extractNoticesPath(params, output)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	ignore := util.ExtractStringSlice(params, "ignore")
 
 	goTags, err := util.ValidateOptionalStringParamStrict(params, "go-tags", "")
@@ -643,10 +1181,20 @@ func BuildGoOnly(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, err
 	}
 
+	buildCache, err := util.ValidateOptionalBoolParam(params, "build-cache", false)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	versionLdflags, err := extractVersionLdflags(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	steps := []Step{
 		generateGoModDownloadStep(workdir),
-		generateGoBuildStep(pkg, output, "", goTags, goExperiment, cgo),
-		generateLicenseStep(pkg, output, ignore),
+		generateGoBuildStep(pkg, output, versionLdflags, goTags, goExperiment, cgo, buildCache),
+		generateLicenseStep(pkg, noticesPath, ignore),
 	}
 
 	return PipelineResult{
@@ -655,6 +1203,8 @@ func BuildGoOnly(params map[string]any) (PipelineResult, error) {
 	}, nil
 }
 
+const defaultRustTarget = "x86_64-unknown-linux-musl"
+
 func CloneAndBuildRust(params map[string]any) (PipelineResult, error) {
 	if err := ValidateParams("clone-and-build-rust", params); err != nil {
 		return PipelineResult{}, err
@@ -674,6 +1224,10 @@ func CloneAndBuildRust(params map[string]any) (PipelineResult, error) {
 	if err != nil {
 		return PipelineResult{}, err
 	}
+	target, err := util.ValidateOptionalStringParamStrict(params, "target", defaultRustTarget)
+	if err != nil {
+		return PipelineResult{}, err
+	}
 	output, err := util.ValidateOptionalStringParamStrict(params, "output", "/main")
 	if err != nil {
 		return PipelineResult{}, err
@@ -686,21 +1240,33 @@ func CloneAndBuildRust(params map[string]any) (PipelineResult, error) {
 
 	patches := util.ExtractStringSlice(params, "patches")
 
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	steps := []Step{
-		generateCloneStep(repo, tag, "", workdir),
+		generateCloneStep(repo, tag, "", workdir, depth, util.ExtractStringSlice(params, "sparse-paths")),
+	}
+
+	patchOpts, err := extractPatchOptions(params)
+	if err != nil {
+		return PipelineResult{}, err
 	}
 
 	buildDeps := []string{"busybox", "git", "cargo", "rust", "make"}
 	if len(patches) > 0 {
-		buildDeps = append(buildDeps, "patch")
-		steps = append(steps, generatePatchSteps(patches, workdir)...)
+		if dep := patchOpts.buildDep(); !slices.Contains(buildDeps, dep) {
+			buildDeps = append(buildDeps, dep)
+		}
+		steps = append(steps, generatePatchSteps(patches, workdir, patchOpts)...)
 	}
 
 	var buildCmd string
 	if features != "" {
-		buildCmd = fmt.Sprintf("RUN cd %s && cargo build --release --target x86_64-unknown-linux-musl --features %s\n", workdir, features)
+		buildCmd = fmt.Sprintf("RUN cd %s && cargo build --release --target %s --features %s\n", workdir, target, features)
 	} else {
-		buildCmd = fmt.Sprintf("RUN cd %s && cargo build --release --target x86_64-unknown-linux-musl\n", workdir)
+		buildCmd = fmt.Sprintf("RUN cd %s && cargo build --release --target %s\n", workdir, target)
 	}
 
 	steps = append(steps, Step{
@@ -710,7 +1276,7 @@ func CloneAndBuildRust(params map[string]any) (PipelineResult, error) {
 
 	steps = append(steps, Step{
 		Name:    "Copy binary to final location",
-		Content: fmt.Sprintf("RUN find %s/target/x86_64-unknown-linux-musl/release -maxdepth 1 -type f -executable -exec cp {} %s \\;\n", workdir, output),
+		Content: fmt.Sprintf("RUN find %s/target/%s/release -maxdepth 1 -type f -executable -exec cp {} %s \\;\n", workdir, target, output),
 	})
 
 	return PipelineResult{
@@ -739,30 +1305,41 @@ func CloneAndBuildMake(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, fmt.Errorf("tag parameter is required (use tag: %%{versions.REPO_URL} to resolve version): %w", err)
 	}
 
-	makeSteps := util.ExtractStringSlice(params, "make-steps")
+	makeSteps, err := extractMakeSteps(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
 
 	strip, err := util.ValidateOptionalBoolParam(params, "strip", true)
 	if err != nil {
 		return PipelineResult{}, err
 	}
+	stripCommand, err := parseStripMode(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
 
-	steps := []Step{
-		generateCloneStep(repo, tag, "", workdir),
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
 	}
 
-	if len(makeSteps) > 0 {
-		steps = append(steps, generateMakeStep(workdir, makeSteps))
+	steps := []Step{
+		generateCloneStep(repo, tag, "", workdir, depth, util.ExtractStringSlice(params, "sparse-paths")),
 	}
 
+	steps = append(steps, generateMakeStep(workdir, makeSteps))
+
 	buildDeps := []string{"busybox", "git", "make"}
 	if strip {
-		steps = append(steps, generateStripStep(workdir))
+		steps = append(steps, generateStripStep(workdir, stripCommand))
 		buildDeps = append(buildDeps, "binutils")
 	}
 
 	return PipelineResult{
 		Steps:     steps,
 		BuildDeps: buildDeps,
+		Packages:  util.ExtractStringSlice(params, "runtime-packages"),
 	}, nil
 }
 
@@ -786,16 +1363,49 @@ func CloneAndBuildAutoconf(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, fmt.Errorf("tag parameter is required (use tag: %%{versions.REPO_URL} to resolve version): %w", err)
 	}
 
+	// A custom autogen-command implies autogen unless it is explicitly disabled.
+	_, hasAutogenCommand := params["autogen-command"]
+	autogen, err := util.ValidateOptionalBoolParam(params, "autogen", hasAutogenCommand)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	if hasAutogenCommand && !autogen {
+		return PipelineResult{}, fmt.Errorf("autogen-command cannot be used with autogen: false")
+	}
+	autogenCommand, err := util.ValidateOptionalStringParamStrict(params, "autogen-command", "./autogen.sh")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	configureOptions := util.ExtractStringSlice(params, "configure-options")
-	makeSteps := util.ExtractStringSlice(params, "make-steps")
+	makeSteps, err := extractMakeSteps(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
 
 	strip, err := util.ValidateOptionalBoolParam(params, "strip", true)
 	if err != nil {
 		return PipelineResult{}, err
 	}
+	stripCommand, err := parseStripMode(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
 
 	steps := []Step{
-		generateCloneStep(repo, tag, "", workdir),
+		generateCloneStep(repo, tag, "", workdir, depth, util.ExtractStringSlice(params, "sparse-paths")),
+	}
+
+	if autogen {
+		steps = append(steps, Step{
+			Name:    "Generate configure script",
+			Content: fmt.Sprintf("RUN cd %s && %s\n", workdir, autogenCommand),
+		})
 	}
 
 	configureCmd := "./configure"
@@ -807,22 +1417,180 @@ func CloneAndBuildAutoconf(params map[string]any) (PipelineResult, error) {
 		Content: fmt.Sprintf("WORKDIR %s\nRUN %s\n", workdir, configureCmd),
 	})
 
-	if len(makeSteps) > 0 {
-		steps = append(steps, Step{
-			Name:    "Build with make",
-			Content: fmt.Sprintf("RUN %s\n", strings.Join(makeSteps, "; \\\n    ")),
-		})
-	}
+	steps = append(steps, Step{
+		Name:    "Build with make",
+		Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(makeSteps, util.ShellSeparator)),
+	})
 
 	buildDeps := []string{"busybox", "git", "autoconf", "automake", "make"}
+	if autogen {
+		buildDeps = append(buildDeps, "libtool")
+	}
 	if strip {
-		steps = append(steps, generateStripStep(workdir))
+		steps = append(steps, generateStripStep(workdir, stripCommand))
 		buildDeps = append(buildDeps, "binutils")
 	}
 
 	return PipelineResult{
 		Steps:     steps,
 		BuildDeps: buildDeps,
+		Packages:  util.ExtractStringSlice(params, "runtime-packages"),
+	}, nil
+}
+
+var nodePackageManagers = map[string]struct {
+	install string
+	build   string
+}{
+	"npm":  {install: "npm ci", build: "npm run build"},
+	"yarn": {install: "yarn install --frozen-lockfile", build: "yarn build"},
+	"pnpm": {install: "pnpm install --frozen-lockfile", build: "pnpm run build"},
+}
+
+func CloneAndBuildNode(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("clone-and-build-node", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	repo, err := util.ValidateStringParam(params, "repo")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	workdir, err := extractRepoWorkdir(repo, params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	tag, err := util.ValidateStringParam(params, "tag")
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("tag parameter is required (use tag: %%{versions.REPO_URL} to resolve version): %w", err)
+	}
+
+	packageManager, err := util.ValidateOptionalStringParamStrict(params, "package-manager", "npm")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	defaults, ok := nodePackageManagers[packageManager]
+	if !ok {
+		return PipelineResult{}, fmt.Errorf("unsupported package-manager %q (must be one of: %s)", packageManager, strings.Join(util.SortedKeys(nodePackageManagers), ", "))
+	}
+
+	installCmd, err := util.ValidateOptionalStringParamStrict(params, "install-command", defaults.install)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	buildCmd, err := util.ValidateOptionalStringParamStrict(params, "build-command", defaults.build)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	dist, err := util.ValidateOptionalStringParamStrict(params, "dist", "dist")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	output, err := util.ValidateStringParam(params, "output")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	steps := []Step{
+		generateCloneStep(repo, tag, "", workdir, depth, util.ExtractStringSlice(params, "sparse-paths")),
+		{
+			Name:    "Install dependencies",
+			Content: fmt.Sprintf("RUN cd %s && %s\n", workdir, installCmd),
+		},
+		{
+			Name:    "Build project",
+			Content: fmt.Sprintf("RUN cd %s && %s\n", workdir, buildCmd),
+		},
+		{
+			Name:    "Copy build output",
+			Content: fmt.Sprintf("RUN mkdir -p %s && cp -R %s/. %s/\n", output, path.Join(workdir, dist), output),
+		},
+	}
+
+	return PipelineResult{
+		Steps:     steps,
+		BuildDeps: []string{"busybox", "git", "nodejs", packageManager},
+	}, nil
+}
+
+func CloneAndBuildPython(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("clone-and-build-python", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	repo, err := util.ValidateStringParam(params, "repo")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	workdir, err := extractRepoWorkdir(repo, params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	tag, err := util.ValidateStringParam(params, "tag")
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("tag parameter is required (use tag: %%{versions.REPO_URL} to resolve version): %w", err)
+	}
+
+	venv, err := util.ValidateOptionalStringParamStrict(params, "venv", "/opt/venv")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	requirements, err := util.ValidateOptionalStringParamStrict(params, "requirements", "requirements.txt")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	pythonVersion, err := util.ValidateOptionalStringParamStrict(params, "python-version", "3")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	extras := util.ExtractStringSlice(params, "extras")
+
+	target := "."
+	if len(extras) > 0 {
+		target = fmt.Sprintf("\".[%s]\"", strings.Join(extras, ","))
+	}
+
+	pip := path.Join(venv, "bin", "pip")
+
+	depth, err := extractCloneDepth(params)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	steps := []Step{
+		generateCloneStep(repo, tag, "", workdir, depth, util.ExtractStringSlice(params, "sparse-paths")),
+		{
+			Name:    "Create virtualenv",
+			Content: fmt.Sprintf("RUN python%s -m venv %s\n", pythonVersion, venv),
+		},
+		{
+			Name:    "Install requirements",
+			Content: fmt.Sprintf("RUN cd %s && %s install --no-cache-dir -r %s\n", workdir, pip, requirements),
+		},
+		{
+			Name:    "Install project",
+			Content: fmt.Sprintf("RUN cd %s && %s install --no-cache-dir %s\n", workdir, pip, target),
+		},
+	}
+
+	return PipelineResult{
+		Steps:     steps,
+		BuildDeps: []string{"busybox", "git", "python3", "py3-pip"},
 	}, nil
 }
 
@@ -858,6 +1626,11 @@ func SetupUsersGroups(params map[string]any) (PipelineResult, error) {
 		return PipelineResult{}, fmt.Errorf("no users or groups specified")
 	}
 
+	skipExisting, err := util.ValidateOptionalBoolParam(params, "skip-existing", false)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
 	var commands []string
 
 	if rootfs != "" {
@@ -865,9 +1638,9 @@ func SetupUsersGroups(params map[string]any) (PipelineResult, error) {
 	}
 
 	for _, group := range groups {
-		commands = append(commands,
-			fmt.Sprintf("echo \"%s:x:%d:\" >> %s/etc/group",
-				group.Name, group.GID, rootfs))
+		commands = append(commands, appendAccountLine(
+			fmt.Sprintf("%s:x:%d:%s", group.Name, group.GID, strings.Join(group.Members, ",")),
+			group.Name, rootfs+"/etc/group", skipExisting))
 	}
 
 	for _, user := range users {
@@ -880,9 +1653,9 @@ func SetupUsersGroups(params map[string]any) (PipelineResult, error) {
 			home = "/nonexistent"
 		}
 
-		commands = append(commands,
-			fmt.Sprintf("echo \"%s:x:%d:%d:%s:%s:%s\" >> %s/etc/passwd",
-				user.Username, user.UID, user.GID, user.Username, home, shell, rootfs))
+		commands = append(commands, appendAccountLine(
+			fmt.Sprintf("%s:x:%d:%d:%s:%s:%s", user.Username, user.UID, user.GID, user.Username, home, shell),
+			user.Username, rootfs+"/etc/passwd", skipExisting))
 
 		if home != "/nonexistent" {
 			commands = append(commands,
@@ -892,7 +1665,7 @@ func SetupUsersGroups(params map[string]any) (PipelineResult, error) {
 		}
 	}
 
-	cmdStr := strings.Join(commands, "; \\\n    ")
+	cmdStr := util.JoinShellCommands(commands, util.ShellSeparator)
 
 	return PipelineResult{
 		Steps: []Step{{
@@ -903,9 +1676,18 @@ func SetupUsersGroups(params map[string]any) (PipelineResult, error) {
 	}, nil
 }
 
+func appendAccountLine(line, name, file string, skipExisting bool) string {
+	echo := fmt.Sprintf("echo \"%s\" >> %s", line, file)
+	if !skipExisting {
+		return echo
+	}
+	return fmt.Sprintf("grep -q \"^%s:\" %s || %s", name, file, echo)
+}
+
 type groupDef struct {
-	Name string
-	GID  int
+	Name    string
+	GID     int
+	Members []string
 }
 
 type userDef struct {
@@ -928,13 +1710,39 @@ func parseGroups(data any) ([]groupDef, error) {
 			return groupDef{}, err
 		}
 
+		members, err := parseGroupMembers(m["members"], fmt.Sprintf("group at index %d", i))
+		if err != nil {
+			return groupDef{}, err
+		}
+
 		return groupDef{
-			Name: name,
-			GID:  gid,
+			Name:    name,
+			GID:     gid,
+			Members: members,
 		}, nil
 	})
 }
 
+func parseGroupMembers(data any, context string) ([]string, error) {
+	if data == nil {
+		return nil, nil
+	}
+	items, ok := data.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: members must be an array of usernames", context)
+	}
+
+	members := make([]string, 0, len(items))
+	for j, item := range items {
+		member, ok := item.(string)
+		if !ok || member == "" || strings.ContainsAny(member, ":, ") {
+			return nil, fmt.Errorf("%s: member at index %d must be a non-empty username", context, j)
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
 func parseUsers(data any) ([]userDef, error) {
 	return util.ParseArrayParam(data, "users", func(m map[string]any, i int) (userDef, error) {
 		username, err := util.ExtractRequiredString(m, "username", fmt.Sprintf("user at index %d", i))
@@ -995,7 +1803,7 @@ func CreateDirectories(params map[string]any) (PipelineResult, error) {
 		}
 	}
 
-	cmdStr := strings.Join(commands, "; \\\n    ")
+	cmdStr := util.JoinShellCommands(commands, util.ShellSeparator)
 
 	return PipelineResult{
 		Steps: []Step{{
@@ -1027,63 +1835,530 @@ func parseDirectories(data any) ([]directoryDef, error) {
 	})
 }
 
-func CopyFiles(params map[string]any) (PipelineResult, error) {
-	filesParam, ok := params["files"]
-	if !ok {
-		return PipelineResult{}, fmt.Errorf("files parameter is required")
+var defaultWritableDirs = []any{
+	map[string]any{"path": "/tmp"},
+	map[string]any{"path": "/var/tmp"},
+	map[string]any{"path": "/run"},
+}
+
+var directoryModePattern = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+func HardenWritableDirs(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("harden-writable-dirs", params); err != nil {
+		return PipelineResult{}, err
 	}
 
-	files, err := parseFiles(filesParam)
+	rootfs, err := util.ValidateOptionalStringParamStrict(params, "rootfs", "")
 	if err != nil {
-		return PipelineResult{}, fmt.Errorf("parsing files: %w", err)
+		return PipelineResult{}, err
 	}
 
-	if len(files) == 0 {
-		return PipelineResult{}, fmt.Errorf("at least one file must be specified")
+	dirsParam, ok := params["directories"]
+	if !ok {
+		dirsParam = defaultWritableDirs
 	}
 
-	var steps []Step
-	for _, file := range files {
-		var copyCmd strings.Builder
-		copyCmd.WriteString("COPY")
+	dirs, err := parseWritableDirs(dirsParam)
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("parsing directories: %w", err)
+	}
 
-		if file.Chown != "" {
-			copyCmd.WriteString(fmt.Sprintf(" --chown=%s", file.Chown))
-		}
-		if file.Chmod != "" {
-			copyCmd.WriteString(fmt.Sprintf(" --chmod=%s", file.Chmod))
-		}
+	if len(dirs) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one directory must be specified")
+	}
 
-		copyCmd.WriteString(fmt.Sprintf(" %s %s\n", file.From, file.To))
+	var paths []string
+	for _, dir := range dirs {
+		paths = append(paths, rootfs+dir.Path)
+	}
 
-		steps = append(steps, Step{
-			Name:    fmt.Sprintf("Copy %s to %s", file.From, file.To),
-			Content: copyCmd.String(),
-		})
+	commands := []string{fmt.Sprintf("mkdir -p %s", strings.Join(paths, " "))}
+	for i, dir := range dirs {
+		commands = append(commands, fmt.Sprintf("chmod %s %s", dir.Mode, paths[i]))
 	}
 
 	return PipelineResult{
-		Steps: steps,
+		Steps: []Step{{
+			Name:    "Harden writable directories",
+			Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(commands, util.ShellSeparator)),
+		}},
+		BuildDeps: []string{"busybox"},
 	}, nil
 }
 
-type fileDef struct {
-	From  string
-	To    string
-	Chown string
-	Chmod string
+type writableDirDef struct {
+	Path string
+	Mode string
 }
 
-func parseFiles(data any) ([]fileDef, error) {
-	return util.ParseArrayParam(data, "files", func(m map[string]any, i int) (fileDef, error) {
-		from, err := util.ExtractRequiredString(m, "from", fmt.Sprintf("file at index %d", i))
+func parseWritableDirs(data any) ([]writableDirDef, error) {
+	return util.ParseArrayParam(data, "directories", func(m map[string]any, i int) (writableDirDef, error) {
+		context := fmt.Sprintf("directory at index %d", i)
+		path, err := util.ExtractRequiredString(m, "path", context)
 		if err != nil {
-			return fileDef{}, err
+			return writableDirDef{}, err
 		}
 
-		to, err := util.ExtractRequiredString(m, "to", fmt.Sprintf("file at index %d", i))
-		if err != nil {
-			return fileDef{}, err
+		mode := util.ExtractOptionalString(m, "mode")
+		if mode == "" {
+			mode = "1777"
+		}
+		if !directoryModePattern.MatchString(mode) {
+			return writableDirDef{}, fmt.Errorf("%s: invalid mode %q (expected octal, e.g. 1777)", context, mode)
+		}
+
+		return writableDirDef{
+			Path: path,
+			Mode: mode,
+		}, nil
+	})
+}
+
+var fileAttributesPattern = regexp.MustCompile(`^[-+=][aAcCdDeFijmPsStTux]+$`)
+
+// SetFileAttributes runs chattr on each file. chattr only works on ext2/3/4
+// filesystems, so builds using overlay or tmpfs storage will fail at this step.
+func SetFileAttributes(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("set-file-attributes", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	filesParam, ok := params["files"]
+	if !ok {
+		return PipelineResult{}, fmt.Errorf("files parameter is required")
+	}
+
+	files, err := parseFileAttributes(filesParam)
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("parsing files: %w", err)
+	}
+
+	if len(files) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one file must be specified")
+	}
+
+	var commands []string
+	for _, file := range files {
+		commands = append(commands, fmt.Sprintf("chattr %s %s", file.Attributes, file.Path))
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Set file attributes",
+			Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(commands, util.ShellSeparator)),
+		}},
+		BuildDeps: []string{"busybox", "e2fsprogs"},
+	}, nil
+}
+
+type fileAttributesDef struct {
+	Path       string
+	Attributes string
+}
+
+func parseFileAttributes(data any) ([]fileAttributesDef, error) {
+	return util.ParseArrayParam(data, "files", func(m map[string]any, i int) (fileAttributesDef, error) {
+		context := fmt.Sprintf("file at index %d", i)
+		path, err := util.ExtractRequiredString(m, "path", context)
+		if err != nil {
+			return fileAttributesDef{}, err
+		}
+
+		attributes, err := util.ExtractRequiredString(m, "attributes", context)
+		if err != nil {
+			return fileAttributesDef{}, err
+		}
+		if !fileAttributesPattern.MatchString(attributes) {
+			return fileAttributesDef{}, fmt.Errorf("%s: invalid attributes %q (expected e.g. +i or -a)", context, attributes)
+		}
+
+		return fileAttributesDef{
+			Path:       path,
+			Attributes: attributes,
+		}, nil
+	})
+}
+
+func SetPermissions(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("set-permissions", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	pathsParam, ok := params["paths"]
+	if !ok {
+		return PipelineResult{}, fmt.Errorf("paths parameter is required")
+	}
+
+	paths, err := parsePermissions(pathsParam)
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("parsing paths: %w", err)
+	}
+
+	if len(paths) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one path must be specified")
+	}
+
+	var commands []string
+	for _, p := range paths {
+		if p.Recursive {
+			commands = append(commands, fmt.Sprintf("chmod -R %s %s", p.Mode, p.Path))
+		} else {
+			commands = append(commands, fmt.Sprintf("chmod %s %s", p.Mode, p.Path))
+		}
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Set permissions",
+			Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(commands, util.ShellSeparator)),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+type permissionsDef struct {
+	Path      string
+	Mode      string
+	Recursive bool
+}
+
+func parsePermissions(data any) ([]permissionsDef, error) {
+	return util.ParseArrayParam(data, "paths", func(m map[string]any, i int) (permissionsDef, error) {
+		context := fmt.Sprintf("path at index %d", i)
+		path, err := util.ExtractRequiredString(m, "path", context)
+		if err != nil {
+			return permissionsDef{}, err
+		}
+
+		mode, err := util.ExtractRequiredString(m, "mode", context)
+		if err != nil {
+			return permissionsDef{}, err
+		}
+
+		recursive, err := util.ValidateOptionalBoolParam(m, "recursive", false)
+		if err != nil {
+			return permissionsDef{}, fmt.Errorf("%s: %w", context, err)
+		}
+
+		return permissionsDef{
+			Path:      path,
+			Mode:      mode,
+			Recursive: recursive,
+		}, nil
+	})
+}
+
+func RemovePaths(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("remove-paths", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	paths := util.ExtractStringSlice(params, "paths")
+	if len(paths) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one path must be specified")
+	}
+
+	for i, p := range paths {
+		if strings.TrimSpace(p) == "" {
+			return PipelineResult{}, fmt.Errorf("path at index %d must not be empty", i)
+		}
+		if path.Clean(p) == "/" {
+			return PipelineResult{}, fmt.Errorf("path at index %d: refusing to remove the root directory (%q)", i, p)
+		}
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Remove paths",
+			Content: fmt.Sprintf("RUN rm -rf %s\n", strings.Join(paths, " ")),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+var trimRootfsTargets = map[string]string{
+	"man":       "/usr/share/man",
+	"doc":       "/usr/share/doc",
+	"info":      "/usr/share/info",
+	"apk-cache": "/var/cache/apk/*",
+}
+
+var defaultTrimRootfs = []string{"man", "doc", "apk-cache"}
+
+func TrimRootfs(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("trim-rootfs", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	rootfs, err := util.ValidateOptionalStringParamStrict(params, "rootfs", "/rootfs")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	rootfs = strings.TrimSuffix(rootfs, "/")
+
+	remove := defaultTrimRootfs
+	if _, ok := params["remove"]; ok {
+		remove = util.ExtractStringSlice(params, "remove")
+	}
+	if len(remove) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one item to remove must be specified")
+	}
+
+	var paths []string
+	for _, item := range remove {
+		target, ok := trimRootfsTargets[item]
+		if !ok {
+			return PipelineResult{}, fmt.Errorf("unsupported remove item %q (must be one of: %s)", item, strings.Join(util.SortedKeys(trimRootfsTargets), ", "))
+		}
+		paths = append(paths, rootfs+target)
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Trim rootfs",
+			Content: fmt.Sprintf("RUN rm -rf %s\n", strings.Join(paths, " ")),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+func WriteFile(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("write-file", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	path, err := util.ValidateStringParam(params, "path")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	content, err := util.ValidateStringParam(params, "content")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	mode, err := util.ValidateOptionalStringParamStrict(params, "mode", "")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	appendContent, err := util.ValidateOptionalBoolParam(params, "append", false)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	redirect := ">"
+	if appendContent {
+		redirect = ">>"
+	}
+
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	delimiter := heredocDelimiter(content)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("RUN cat %s %s <<'%s'\n", redirect, path, delimiter))
+	b.WriteString(content)
+	b.WriteString(delimiter + "\n")
+	if mode != "" {
+		b.WriteString(fmt.Sprintf("RUN chmod %s %s\n", mode, path))
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    fmt.Sprintf("Write %s", path),
+			Content: b.String(),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+const buildTimePlaceholder = "@BUILD_TIME@"
+
+var buildInfoFields = []string{"versions", "bom-hash", "build-time"}
+
+func BuildInfo(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("build-info", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	path, err := util.ValidateOptionalStringParamStrict(params, "path", "/etc/build-info.json")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	fields := buildInfoFields
+	if _, ok := params["fields"]; ok {
+		fields = util.ExtractStringSlice(params, "fields")
+	}
+	if len(fields) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one field must be specified")
+	}
+
+	info := make(map[string]any)
+	for _, field := range fields {
+		switch field {
+		case "versions":
+			versions, _ := params["versions"].(map[string]string)
+			if versions == nil {
+				versions = map[string]string{}
+			}
+			info[field] = versions
+		case "bom-hash":
+			bomHash, _ := params["bom-hash"].(string)
+			info[field] = bomHash
+		case "build-time":
+			info[field] = buildTimePlaceholder
+		default:
+			return PipelineResult{}, fmt.Errorf("unsupported field %q (must be one of: %s)", field, strings.Join(buildInfoFields, ", "))
+		}
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("marshaling build info: %w", err)
+	}
+	content := string(data) + "\n"
+	delimiter := heredocDelimiter(content)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("RUN cat > %s <<'%s'\n", path, delimiter))
+	b.WriteString(content)
+	b.WriteString(delimiter + "\n")
+	if slices.Contains(fields, "build-time") {
+		b.WriteString(fmt.Sprintf("RUN sed -i \"s/%s/$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)/\" %s\n", buildTimePlaceholder, path))
+	}
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Write build info",
+			Content: b.String(),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+func heredocDelimiter(content string) string {
+	lines := strings.Split(content, "\n")
+	delimiter := "EOF"
+	for i := 1; slices.Contains(lines, delimiter); i++ {
+		delimiter = fmt.Sprintf("EOF_%d", i)
+	}
+	return delimiter
+}
+
+func CopyFiles(params map[string]any) (PipelineResult, error) {
+	filesParam, ok := params["files"]
+	if !ok {
+		return PipelineResult{}, fmt.Errorf("files parameter is required")
+	}
+
+	files, err := parseFiles(filesParam)
+	if err != nil {
+		return PipelineResult{}, fmt.Errorf("parsing files: %w", err)
+	}
+
+	if len(files) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one file must be specified")
+	}
+
+	preserveParents, err := util.ValidateOptionalBoolParam(params, "preserve-parents", false)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	if preserveParents {
+		return copyFilesWithParents(files)
+	}
+
+	var steps []Step
+	for _, file := range files {
+		var copyCmd strings.Builder
+		copyCmd.WriteString("COPY")
+
+		if file.Chown != "" {
+			copyCmd.WriteString(fmt.Sprintf(" --chown=%s", file.Chown))
+		}
+		if file.Chmod != "" {
+			copyCmd.WriteString(fmt.Sprintf(" --chmod=%s", file.Chmod))
+		}
+		if file.Link {
+			copyCmd.WriteString(" --link")
+		}
+
+		copyCmd.WriteString(fmt.Sprintf(" %s %s\n", file.From, file.To))
+
+		steps = append(steps, Step{
+			Name:    fmt.Sprintf("Copy %s to %s", file.From, file.To),
+			Content: copyCmd.String(),
+		})
+	}
+
+	return PipelineResult{
+		Steps: steps,
+	}, nil
+}
+
+// copyFilesWithParents copies from a bind mount of the build context, as COPY
+// has no --parents. RUN --mount needs BuildKit (or buildah), and the result is
+// an ordinary RUN layer, so COPY options such as --link do not apply.
+func copyFilesWithParents(files []fileDef) (PipelineResult, error) {
+	const contextDir = "/tmp/dfo-context"
+
+	var steps []Step
+	for i, file := range files {
+		if file.Link {
+			return PipelineResult{}, fmt.Errorf("file at index %d: link cannot be used with preserve-parents", i)
+		}
+
+		commands := []string{
+			fmt.Sprintf("mkdir -p %s", file.To),
+			fmt.Sprintf("cd %s", contextDir),
+			fmt.Sprintf("cp -R --parents %s %s", file.From, file.To),
+		}
+		if file.Chown != "" {
+			commands = append(commands, fmt.Sprintf("chown -R %s %s", file.Chown, path.Join(file.To, file.From)))
+		}
+		if file.Chmod != "" {
+			commands = append(commands, fmt.Sprintf("chmod -R %s %s", file.Chmod, path.Join(file.To, file.From)))
+		}
+
+		steps = append(steps, Step{
+			Name: fmt.Sprintf("Copy %s to %s preserving parents", file.From, file.To),
+			Content: fmt.Sprintf("RUN --mount=type=bind,target=%s \\\n%s%s\n",
+				contextDir, util.RunIndent, util.JoinShellCommands(commands, util.ShellSeparatorFailFast)),
+		})
+	}
+
+	return PipelineResult{
+		Steps:     steps,
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+type fileDef struct {
+	From  string
+	To    string
+	Chown string
+	Chmod string
+	Link  bool
+}
+
+func parseFiles(data any) ([]fileDef, error) {
+	return util.ParseArrayParam(data, "files", func(m map[string]any, i int) (fileDef, error) {
+		from, err := util.ExtractRequiredString(m, "from", fmt.Sprintf("file at index %d", i))
+		if err != nil {
+			return fileDef{}, err
+		}
+
+		to, err := util.ExtractRequiredString(m, "to", fmt.Sprintf("file at index %d", i))
+		if err != nil {
+			return fileDef{}, err
+		}
+
+		link, err := util.ValidateOptionalBoolParam(m, "link", false)
+		if err != nil {
+			return fileDef{}, fmt.Errorf("file at index %d: %w", i, err)
 		}
 
 		return fileDef{
@@ -1091,6 +2366,422 @@ func parseFiles(data any) ([]fileDef, error) {
 			To:    to,
 			Chown: util.ExtractOptionalString(m, "chown"),
 			Chmod: util.ExtractOptionalString(m, "chmod"),
+			Link:  link,
 		}, nil
 	})
 }
+
+// DefaultChecksumPattern matches the checksums asset of most GitHub releases.
+const DefaultChecksumPattern = "*checksums.txt"
+
+// GitHubRepo returns the owner/repo of a GitHub repository given as owner/repo
+// or as a URL.
+func GitHubRepo(repo string) (string, error) {
+	ownerRepo := ExtractGitHubOwnerRepo(repo)
+	if ownerRepo == "" && strings.Count(repo, "/") == 1 && !strings.Contains(repo, ":") {
+		ownerRepo = repo
+	}
+	if ownerRepo == "" {
+		return "", fmt.Errorf("repo must be a GitHub repository (owner/repo or URL), got %q", repo)
+	}
+	return ownerRepo, nil
+}
+
+// InstallGitHubRelease expects the generator to have resolved the release and
+// passed the matching asset-url and checksum-url; the build never queries the
+// GitHub API.
+func InstallGitHubRelease(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("install-github-release", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	repo, err := util.ValidateStringParam(params, "repo")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	ownerRepo, err := GitHubRepo(repo)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	assetPattern, err := util.ValidateStringParam(params, "asset-pattern")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	if err := validateAssetPattern(assetPattern); err != nil {
+		return PipelineResult{}, err
+	}
+	if err := validateArchiveFormat(assetPattern); err != nil {
+		return PipelineResult{}, err
+	}
+
+	checksumPattern, err := util.ValidateOptionalStringParamStrict(params, "checksum-pattern", DefaultChecksumPattern)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	if err := validateAssetPattern(checksumPattern); err != nil {
+		return PipelineResult{}, err
+	}
+
+	binary, err := util.ValidateStringParam(params, "binary")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	destination, err := util.ValidateStringParam(params, "destination")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	assetURL, _ := params["asset-url"].(string)
+	checksumURL, _ := params["checksum-url"].(string)
+	if assetURL == "" || checksumURL == "" {
+		return PipelineResult{}, fmt.Errorf("release assets for %s have not been resolved", ownerRepo)
+	}
+	assetName := path.Base(assetURL)
+
+	const workDir = "/tmp/github-release"
+	cmdParts := []string{
+		fmt.Sprintf("mkdir -p %s/extract", workDir),
+		fmt.Sprintf("curl -fsSL -o %q %q", workDir+"/"+assetName, assetURL),
+		fmt.Sprintf("curl -fsSL -o %s/checksums %q", workDir, checksumURL),
+		fmt.Sprintf("cd %s", workDir),
+		fmt.Sprintf("awk -v name=%q '$2 == name || $2 == \"*\" name' checksums > asset.sha256", assetName),
+		"test -s asset.sha256",
+		"sha256sum -c asset.sha256",
+	}
+
+	buildDeps := []string{"busybox", "curl"}
+	if strings.HasSuffix(assetPattern, ".zip") {
+		cmdParts = append(cmdParts, fmt.Sprintf("unzip -q %q %q -d %s/extract", workDir+"/"+assetName, binary, workDir))
+		buildDeps = append(buildDeps, "unzip")
+	} else {
+		cmdParts = append(cmdParts, fmt.Sprintf("tar -xf %q -C %s/extract %q", workDir+"/"+assetName, workDir, binary))
+	}
+
+	cmdParts = append(cmdParts,
+		fmt.Sprintf("install -D -m 0755 %q %q", workDir+"/extract/"+binary, destination),
+		fmt.Sprintf("rm -rf %s", workDir),
+	)
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    fmt.Sprintf("Install %s from %s release", path.Base(binary), ownerRepo),
+			Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(cmdParts, util.ShellSeparatorFailFast)),
+		}},
+		BuildDeps: buildDeps,
+	}, nil
+}
+
+func validateAssetPattern(pattern string) error {
+	if strings.Contains(pattern, "/") {
+		return fmt.Errorf("asset pattern %q must match a file name, not a path", pattern)
+	}
+	if strings.ContainsAny(pattern, "\"'$`;|&() ") {
+		return fmt.Errorf("asset pattern %q contains unsupported characters", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid asset pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+func RunScript(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("run-script", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	script, err := util.ValidateStringParam(params, "script")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	args := util.ExtractStringSlice(params, "args")
+	buildDeps := util.ExtractStringSlice(params, "build-deps")
+
+	target := "/tmp/dfo-scripts/" + path.Base(script)
+
+	runCmd := target
+	for _, arg := range args {
+		runCmd += fmt.Sprintf(" %q", arg)
+	}
+
+	return PipelineResult{
+		Steps: []Step{
+			{
+				Name:    fmt.Sprintf("Copy script %s", script),
+				Content: fmt.Sprintf("COPY %s %s\n", script, target),
+			},
+			{
+				Name: fmt.Sprintf("Run script %s", script),
+				Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands([]string{
+					fmt.Sprintf("chmod +x %s", target),
+					runCmd,
+					fmt.Sprintf("rm -f %s", target),
+				}, util.ShellSeparatorFailFast)),
+			},
+		},
+		BuildDeps: append([]string{"busybox"}, buildDeps...),
+	}, nil
+}
+
+func ApkWorld(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("apk-world", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	packages := util.ExtractStringSlice(params, "packages")
+	if len(packages) == 0 {
+		return PipelineResult{}, fmt.Errorf("at least one package must be specified")
+	}
+
+	root, err := util.ValidateOptionalStringParamStrict(params, "root", "/")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	seen := make(map[string]bool)
+	var world []string
+	for _, pkg := range packages {
+		pkg = strings.TrimSpace(pkg)
+		if pkg == "" || seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		world = append(world, pkg)
+	}
+	sort.Strings(world)
+
+	apkDir := path.Join(root, "etc/apk")
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name: "Write apk world file",
+			Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands([]string{
+				fmt.Sprintf("mkdir -p %s", apkDir),
+				fmt.Sprintf("printf '%%s\\n' %s > %s", strings.Join(world, " "), path.Join(apkDir, "world")),
+			}, util.ShellSeparatorFailFast)),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+func InstallToolchain(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("install-toolchain", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	url, err := util.ValidateStringParam(params, "url")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	checksum, err := util.ValidateStringParam(params, "checksum")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	prefix, err := util.ValidateOptionalStringParamStrict(params, "prefix", "/opt/toolchain")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	stripComponents, err := util.ValidateOptionalIntParam(params, "strip-components", 1)
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	download, err := DownloadVerifyExtract(map[string]any{
+		"url":              url,
+		"destination":      "/tmp/" + path.Base(url),
+		"checksum":         checksum,
+		"extract-dir":      prefix,
+		"strip-components": stripComponents,
+	})
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	steps := append(download.Steps, Step{
+		Name:    "Add toolchain to PATH",
+		Content: fmt.Sprintf("ENV PATH=\"%s:$PATH\"\n", path.Join(prefix, "bin")),
+	})
+
+	return PipelineResult{
+		Steps:     steps,
+		BuildDeps: download.BuildDeps,
+	}, nil
+}
+
+func RunMigrations(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("run-migrations", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	command, err := util.ValidateStringParam(params, "command")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	outputDB, err := util.ValidateStringParam(params, "output-db")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	workdir, err := util.ValidateOptionalStringParamStrict(params, "workdir", "")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	rootfs, err := util.ValidateOptionalStringParamStrict(params, "rootfs", "/rootfs")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	owner, err := util.ValidateOptionalStringParamStrict(params, "owner", "")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	migrate := []string{fmt.Sprintf("mkdir -p %s", path.Dir(outputDB))}
+	if workdir != "" {
+		migrate = append(migrate, fmt.Sprintf("cd %s", workdir))
+	}
+	migrate = append(migrate, command)
+
+	rootfsDB := rootfs + outputDB
+	install := []string{
+		fmt.Sprintf("mkdir -p %s", path.Dir(rootfsDB)),
+		fmt.Sprintf("cp %s %s", outputDB, rootfsDB),
+	}
+	if owner != "" {
+		install = append(install, fmt.Sprintf("chown %s %s", owner, rootfsDB))
+	}
+
+	return PipelineResult{
+		Steps: []Step{
+			{
+				Name:    "Run migrations",
+				Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(migrate, util.ShellSeparatorFailFast)),
+			},
+			{
+				Name:    "Copy database into rootfs",
+				Content: fmt.Sprintf("RUN %s\n", util.JoinShellCommands(install, util.ShellSeparatorFailFast)),
+			},
+		},
+		BuildDeps: append([]string{"busybox"}, util.ExtractStringSlice(params, "build-deps")...),
+	}, nil
+}
+
+func WriteSysctls(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("write-sysctls", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	tunables, err := parseSysctlTunables(params["tunables"])
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	rootfs, err := util.ValidateOptionalStringParamStrict(params, "rootfs", "/rootfs")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	file, err := util.ValidateOptionalStringParamStrict(params, "path", "/etc/sysctl.d/99-recommended.conf")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	keys := util.SortedKeys(tunables)
+
+	var content strings.Builder
+	content.WriteString("# Recommended kernel tunables. Containers cannot apply sysctls at build time;\n")
+	content.WriteString(fmt.Sprintf("# set them at runtime, e.g. docker run --sysctl %s=%s\n", keys[0], tunables[keys[0]]))
+	for _, key := range keys {
+		content.WriteString(fmt.Sprintf("%s = %s\n", key, tunables[key]))
+	}
+	delimiter := heredocDelimiter(content.String())
+
+	target := rootfs + file
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("RUN mkdir -p %s && cat > %s <<'%s'\n", path.Dir(target), target, delimiter))
+	b.WriteString(content.String())
+	b.WriteString(delimiter + "\n")
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Write sysctl tunables",
+			Content: b.String(),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
+
+func parseSysctlTunables(data any) (map[string]string, error) {
+	raw, ok := data.(map[string]any)
+	if !ok || len(raw) == 0 {
+		return nil, fmt.Errorf("tunables must contain at least one entry")
+	}
+
+	tunables := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if key == "" || strings.ContainsAny(key, " \t\n=") {
+			return nil, fmt.Errorf("invalid sysctl key %q", key)
+		}
+		str, ok := value.(string)
+		if !ok || strings.Contains(str, "\n") {
+			return nil, fmt.Errorf("sysctl %q must have a single-line string value", key)
+		}
+		tunables[key] = str
+	}
+	return tunables, nil
+}
+
+var nsswitchDatabases = []string{"passwd", "group", "shadow", "hosts", "networks", "protocols", "services"}
+
+func SetupNsswitch(params map[string]any) (PipelineResult, error) {
+	if err := ValidateParams("setup-nsswitch", params); err != nil {
+		return PipelineResult{}, err
+	}
+
+	root, err := util.ValidateOptionalStringParamStrict(params, "root", "/")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+
+	hosts, err := util.ValidateOptionalStringParamStrict(params, "hosts", "files dns")
+	if err != nil {
+		return PipelineResult{}, err
+	}
+	if strings.TrimSpace(hosts) == "" || strings.Contains(hosts, "\n") {
+		return PipelineResult{}, fmt.Errorf("hosts must be a single line of nsswitch sources")
+	}
+
+	var content strings.Builder
+	for _, database := range nsswitchDatabases {
+		sources := "files"
+		if database == "hosts" {
+			sources = hosts
+		}
+		content.WriteString(fmt.Sprintf("%-10s %s\n", database+":", sources))
+	}
+	delimiter := heredocDelimiter(content.String())
+
+	etc := path.Join(root, "etc")
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("RUN mkdir -p %s && cat > %s <<'%s'\n", etc, path.Join(etc, "nsswitch.conf"), delimiter))
+	b.WriteString(content.String())
+	b.WriteString(delimiter + "\n")
+
+	return PipelineResult{
+		Steps: []Step{{
+			Name:    "Write nsswitch.conf",
+			Content: b.String(),
+		}},
+		BuildDeps: []string{"busybox"},
+	}, nil
+}
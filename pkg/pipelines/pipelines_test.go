@@ -1,6 +1,9 @@
 package pipelines
 
 import (
+	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -134,9 +137,9 @@ func TestValidateArchiveFormat(t *testing.T) {
 			expectError: true,
 		},
 		{
-			name:        "unsupported 7z",
+			name:        "valid 7z",
 			filename:    "file.7z",
-			expectError: true,
+			expectError: false,
 		},
 	}
 
@@ -165,6 +168,13 @@ func TestBuildExtractCommand(t *testing.T) {
 			strip:       0,
 			contains:    "unzip -q",
 		},
+		{
+			name:        "7z archive",
+			destination: "file.7z",
+			extractDir:  "/opt",
+			strip:       0,
+			contains:    `7z x -y -o"/opt" "file.7z"`,
+		},
 		{
 			name:        "tar.gz with strip",
 			destination: "file.tar.gz",
@@ -207,8 +217,15 @@ func TestRegistry(t *testing.T) {
 	expectedPipelines := []string{
 		"create-user",
 		"set-ownership",
+		"set-permissions",
+		"remove-paths",
+		"trim-rootfs",
+		"write-file",
+		"build-info",
 		"download-verify-extract",
 		"make-executable",
+		"verify-static",
+		"validate-config-file",
 		"clone",
 		"clone-and-build-go",
 		"build-go-static",
@@ -216,9 +233,20 @@ func TestRegistry(t *testing.T) {
 		"clone-and-build-rust",
 		"clone-and-build-make",
 		"clone-and-build-autoconf",
+		"clone-and-build-node",
+		"clone-and-build-python",
+		"set-file-attributes",
+		"harden-writable-dirs",
 		"setup-users-groups",
 		"create-directories",
 		"copy-files",
+		"install-github-release",
+		"run-script",
+		"apk-world",
+		"install-toolchain",
+		"run-migrations",
+		"write-sysctls",
+		"setup-nsswitch",
 	}
 
 	for _, name := range expectedPipelines {
@@ -227,3 +255,2925 @@ func TestRegistry(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateAssetPattern(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		expectError bool
+	}{
+		{name: "suffix glob", pattern: "*_linux_amd64.tar.gz", expectError: false},
+		{name: "character class", pattern: "tool_[0-9]*_linux_amd64.zip", expectError: false},
+		{name: "exact name", pattern: "checksums.txt", expectError: false},
+		{name: "path separator", pattern: "dist/*.tar.gz", expectError: true},
+		{name: "unterminated class", pattern: "tool_[0-9.tar.gz", expectError: true},
+		{name: "shell metacharacters", pattern: "*.tar.gz;rm", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAssetPattern(tt.pattern)
+			if (err != nil) != tt.expectError {
+				t.Errorf("validateAssetPattern(%q) error = %v, expectError %v", tt.pattern, err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestInstallGitHubRelease(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        map[string]any
+		expectError   bool
+		contains      []string
+		notContains   []string
+		wantBuildDeps []string
+	}{
+		{
+			name: "resolved tarball",
+			params: map[string]any{
+				"repo":          "https://github.com/owner/tool",
+				"asset-pattern": "*_linux_amd64.tar.gz",
+				"binary":        "tool",
+				"destination":   "/usr/local/bin/tool",
+				"asset-url":     "https://github.com/owner/tool/releases/download/v1.2.3/tool_1.2.3_linux_amd64.tar.gz",
+				"checksum-url":  "https://github.com/owner/tool/releases/download/v1.2.3/tool_1.2.3_checksums.txt",
+			},
+			contains: []string{
+				`curl -fsSL -o "/tmp/github-release/tool_1.2.3_linux_amd64.tar.gz" "https://github.com/owner/tool/releases/download/v1.2.3/tool_1.2.3_linux_amd64.tar.gz"`,
+				`curl -fsSL -o /tmp/github-release/checksums "https://github.com/owner/tool/releases/download/v1.2.3/tool_1.2.3_checksums.txt"`,
+				`awk -v name="tool_1.2.3_linux_amd64.tar.gz" '$2 == name || $2 == "*" name' checksums > asset.sha256`,
+				`test -s asset.sha256 && \`,
+				`sha256sum -c asset.sha256`,
+				`tar -xf "/tmp/github-release/tool_1.2.3_linux_amd64.tar.gz" -C /tmp/github-release/extract "tool"`,
+				`install -D -m 0755 "/tmp/github-release/extract/tool" "/usr/local/bin/tool"`,
+			},
+			notContains:   []string{"unzip", "api.github.com"},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "resolved zip",
+			params: map[string]any{
+				"repo":             "owner/tool",
+				"tag":              "v1.2.3",
+				"asset-pattern":    "tool-*-linux-amd64.zip",
+				"checksum-pattern": "SHA256SUMS",
+				"binary":           "bin/tool",
+				"destination":      "/tool",
+				"asset-url":        "https://github.com/owner/tool/releases/download/v1.2.3/tool-1.2.3-linux-amd64.zip",
+				"checksum-url":     "https://github.com/owner/tool/releases/download/v1.2.3/SHA256SUMS",
+			},
+			contains: []string{
+				`"https://github.com/owner/tool/releases/download/v1.2.3/SHA256SUMS"`,
+				`unzip -q "/tmp/github-release/tool-1.2.3-linux-amd64.zip" "bin/tool" -d /tmp/github-release/extract`,
+			},
+			notContains:   []string{"api.github.com", "tar -xf"},
+			wantBuildDeps: []string{"busybox", "curl", "unzip"},
+		},
+		{
+			name: "unresolved assets",
+			params: map[string]any{
+				"repo":          "owner/tool",
+				"asset-pattern": "*_linux_amd64.tar.gz",
+				"binary":        "tool",
+				"destination":   "/tool",
+			},
+			expectError: true,
+		},
+		{
+			name: "non-GitHub repo",
+			params: map[string]any{
+				"repo":          "https://gitlab.com/owner/tool",
+				"asset-pattern": "*.tar.gz",
+				"binary":        "tool",
+				"destination":   "/tool",
+			},
+			expectError: true,
+		},
+		{
+			name: "unsupported archive pattern",
+			params: map[string]any{
+				"repo":          "owner/tool",
+				"asset-pattern": "*_linux_amd64",
+				"binary":        "tool",
+				"destination":   "/tool",
+			},
+			expectError: true,
+		},
+		{
+			name: "missing binary",
+			params: map[string]any{
+				"repo":          "owner/tool",
+				"asset-pattern": "*.tar.gz",
+				"destination":   "/tool",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := InstallGitHubRelease(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("InstallGitHubRelease() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if len(result.Steps) != 1 {
+				t.Fatalf("expected 1 step, got %d", len(result.Steps))
+			}
+			content := result.Steps[0].Content
+			for _, expected := range tt.contains {
+				if !strings.Contains(content, expected) {
+					t.Errorf("expected content to contain %q, got:\n%s", expected, content)
+				}
+			}
+			for _, unexpected := range tt.notContains {
+				if strings.Contains(content, unexpected) {
+					t.Errorf("expected content not to contain %q, got:\n%s", unexpected, content)
+				}
+			}
+			if strings.Join(result.BuildDeps, ",") != strings.Join(tt.wantBuildDeps, ",") {
+				t.Errorf("BuildDeps = %v, want %v", result.BuildDeps, tt.wantBuildDeps)
+			}
+		})
+	}
+}
+
+func TestDownloadVerifyExtract(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        map[string]any
+		expectError   bool
+		contains      []string
+		notContains   []string
+		wantBuildDeps []string
+	}{
+		{
+			name: "literal checksum",
+			params: map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": "/tmp/tool.tar.gz",
+				"checksum":    "abc123",
+			},
+			contains: []string{
+				`curl -fsSL -o /tmp/tool.tar.gz "https://example.com/tool.tar.gz"`,
+				`echo "abc123  /tmp/tool.tar.gz" | sha256sum -c`,
+			},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "checksum url with pattern",
+			params: map[string]any{
+				"url":              "https://example.com/tool.tar.gz",
+				"destination":      "/tmp/tool.tar.gz",
+				"checksum-url":     "https://example.com/SHA256SUMS",
+				"checksum-pattern": "linux-amd64",
+			},
+			contains: []string{
+				`curl -fsSL -o /tmp/tool.tar.gz.checksum "https://example.com/SHA256SUMS"`,
+				`grep "linux-amd64" /tmp/tool.tar.gz.checksum`,
+			},
+			notContains:   []string{"awk -v f="},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "checksum url matches destination basename",
+			params: map[string]any{
+				"url":          "https://example.com/tool.tar.gz",
+				"destination":  "/tmp/tool.tar.gz",
+				"checksum-url": "https://example.com/SHA256SUMS",
+			},
+			contains: []string{
+				`awk -v f="tool.tar.gz" 'NR == 1 { first = $1 } $2 == f || $2 == "*" f { print $1; found = 1; exit } END { if (!found) print first }' /tmp/tool.tar.gz.checksum`,
+				`*/tmp/tool.tar.gz" | sha256sum -wc -`,
+			},
+			notContains:   []string{"cat /tmp/tool.tar.gz.checksum", "grep"},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "zip extraction",
+			params: map[string]any{
+				"url":         "https://example.com/tool.zip",
+				"destination": "/tmp/tool.zip",
+				"checksum":    "abc123",
+				"extract-dir": "/opt/tool",
+			},
+			contains:      []string{`unzip -q "/tmp/tool.zip" -d "/opt/tool"`},
+			wantBuildDeps: []string{"busybox", "curl", "unzip"},
+		},
+		{
+			name: "7z extraction",
+			params: map[string]any{
+				"url":         "https://example.com/tool.7z",
+				"destination": "/tmp/tool.7z",
+				"checksum":    "abc123",
+				"extract-dir": "/opt/tool",
+			},
+			contains:      []string{`mkdir -p "/opt/tool" && 7z x -y -o"/opt/tool" "/tmp/tool.7z"`},
+			notContains:   []string{"unzip"},
+			wantBuildDeps: []string{"busybox", "curl", "7zip"},
+		},
+		{
+			name: "7z download without extraction",
+			params: map[string]any{
+				"url":         "https://example.com/tool.7z",
+				"destination": "/tmp/tool.7z",
+				"checksum":    "abc123",
+			},
+			notContains:   []string{"7z x"},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "tar extraction with strip components",
+			params: map[string]any{
+				"url":              "https://example.com/tool.tar.gz",
+				"destination":      "/tmp/tool.tar.gz",
+				"checksum":         "abc123",
+				"extract-dir":      "/opt/tool",
+				"strip-components": 1,
+			},
+			contains:      []string{`tar -xf "/tmp/tool.tar.gz" -C "/opt/tool" --strip-components=1`},
+			notContains:   []string{"unzip"},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "sha512 literal checksum",
+			params: map[string]any{
+				"url":                "https://example.com/tool.tar.gz",
+				"destination":        "/tmp/tool.tar.gz",
+				"checksum":           "abc123",
+				"checksum-algorithm": "sha512",
+			},
+			contains:      []string{`echo "abc123  /tmp/tool.tar.gz" | sha512sum -c`},
+			notContains:   []string{"sha256sum", "command -v"},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "sha512 checksum url",
+			params: map[string]any{
+				"url":                "https://example.com/tool.tar.gz",
+				"destination":        "/tmp/tool.tar.gz",
+				"checksum-url":       "https://example.com/SHA512SUMS",
+				"checksum-algorithm": "sha512",
+			},
+			contains:      []string{`*/tmp/tool.tar.gz" | sha512sum -wc -`},
+			notContains:   []string{"sha256sum"},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "sha1 checksum url with pattern",
+			params: map[string]any{
+				"url":                "https://example.com/tool.tar.gz",
+				"destination":        "/tmp/tool.tar.gz",
+				"checksum-url":       "https://example.com/SHA1SUMS",
+				"checksum-pattern":   "tool.tar.gz",
+				"checksum-algorithm": "sha1",
+			},
+			contains:      []string{`echo "$(grep "tool.tar.gz" /tmp/tool.tar.gz.checksum | awk '{print $1}') */tmp/tool.tar.gz" | sha1sum -wc -`},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "b2sum literal checksum",
+			params: map[string]any{
+				"url":                "https://example.com/tool.tar.gz",
+				"destination":        "/tmp/tool.tar.gz",
+				"checksum":           "abc123",
+				"checksum-algorithm": "b2sum",
+			},
+			contains: []string{
+				`command -v b2sum >/dev/null 2>&1 || { echo "checksum algorithm blake2 requires b2sum, which is not available on the base image" >&2; exit 1; }`,
+				`echo "abc123  /tmp/tool.tar.gz" | b2sum -c`,
+			},
+			notContains:   []string{"sha256sum"},
+			wantBuildDeps: []string{"busybox", "curl", "coreutils"},
+		},
+		{
+			name: "blake2 checksum url",
+			params: map[string]any{
+				"url":                "https://example.com/tool.tar.gz",
+				"destination":        "/tmp/tool.tar.gz",
+				"checksum-url":       "https://example.com/B2SUMS",
+				"checksum-pattern":   "tool.tar.gz",
+				"checksum-algorithm": "blake2",
+			},
+			contains:      []string{`echo "$(grep "tool.tar.gz" /tmp/tool.tar.gz.checksum | awk '{print $1}') */tmp/tool.tar.gz" | b2sum -wc -`},
+			wantBuildDeps: []string{"busybox", "curl", "coreutils"},
+		},
+		{
+			name: "sha3-256 literal checksum",
+			params: map[string]any{
+				"url":                "https://example.com/tool.tar.gz",
+				"destination":        "/tmp/tool.tar.gz",
+				"checksum":           "abc123",
+				"checksum-algorithm": "sha3-256",
+			},
+			contains:      []string{`test "abc123" = "$(openssl dgst -sha3-256 -r /tmp/tool.tar.gz | awk '{print $1}')"`},
+			wantBuildDeps: []string{"busybox", "curl", "openssl"},
+		},
+		{
+			name: "gpg signature with fingerprint",
+			params: map[string]any{
+				"url":           "https://example.com/tool.tar.gz",
+				"destination":   "/tmp/tool.tar.gz",
+				"checksum":      "abc123",
+				"signature-url": "https://example.com/tool.tar.gz.asc",
+				"gpg-key":       "0123456789ABCDEF0123456789ABCDEF01234567",
+				"extract-dir":   "/opt/tool",
+			},
+			contains: []string{
+				`curl -fsSL -o /tmp/tool.tar.gz.sig "https://example.com/tool.tar.gz.asc"`,
+				"gpg --batch --keyserver hkps://keys.openpgp.org --recv-keys 0123456789ABCDEF0123456789ABCDEF01234567",
+				"gpg --batch --verify /tmp/tool.tar.gz.sig /tmp/tool.tar.gz && \\\n    rm -rf \"$GNUPGHOME\" /tmp/tool.tar.gz.sig && \\\n    mkdir -p \"/opt/tool\"",
+				`echo "abc123  /tmp/tool.tar.gz" | sha256sum -c`,
+			},
+			wantBuildDeps: []string{"busybox", "curl", "gnupg"},
+		},
+		{
+			name: "gpg signature with key url and checksum url",
+			params: map[string]any{
+				"url":           "https://example.com/tool.tar.gz",
+				"destination":   "/tmp/tool.tar.gz",
+				"checksum-url":  "https://example.com/SHA256SUMS",
+				"signature-url": "https://example.com/tool.tar.gz.sig",
+				"gpg-key":       "https://example.com/release-key.asc",
+			},
+			contains: []string{
+				`curl -fsSL "https://example.com/release-key.asc" | gpg --batch --import`,
+				"gpg --batch --verify /tmp/tool.tar.gz.sig /tmp/tool.tar.gz",
+			},
+			notContains:   []string{"--recv-keys"},
+			wantBuildDeps: []string{"busybox", "curl", "gnupg"},
+		},
+		{
+			name: "gpg signature with fingerprint and keyserver",
+			params: map[string]any{
+				"url":           "https://example.com/tool.tar.gz",
+				"destination":   "/tmp/tool.tar.gz",
+				"checksum":      "abc123",
+				"signature-url": "https://example.com/tool.tar.gz.asc",
+				"gpg-key":       "0123456789ABCDEF0123456789ABCDEF01234567",
+				"keyserver":     "hkps://keyserver.ubuntu.com",
+			},
+			contains: []string{
+				"gpg --batch --keyserver hkps://keyserver.ubuntu.com --recv-keys 0123456789ABCDEF0123456789ABCDEF01234567",
+			},
+			notContains:   []string{"keys.openpgp.org"},
+			wantBuildDeps: []string{"busybox", "curl", "gnupg"},
+		},
+		{
+			name: "keyserver with key url",
+			params: map[string]any{
+				"url":           "https://example.com/tool.tar.gz",
+				"destination":   "/tmp/tool.tar.gz",
+				"checksum":      "abc123",
+				"signature-url": "https://example.com/tool.tar.gz.sig",
+				"gpg-key":       "https://example.com/release-key.asc",
+				"keyserver":     "hkps://keyserver.ubuntu.com",
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid keyserver",
+			params: map[string]any{
+				"url":           "https://example.com/tool.tar.gz",
+				"destination":   "/tmp/tool.tar.gz",
+				"checksum":      "abc123",
+				"signature-url": "https://example.com/tool.tar.gz.sig",
+				"gpg-key":       "0123456789ABCDEF0123456789ABCDEF01234567",
+				"keyserver":     "https://keys.example.com; rm -rf /",
+			},
+			expectError: true,
+		},
+		{
+			name: "signature url without gpg key",
+			params: map[string]any{
+				"url":           "https://example.com/tool.tar.gz",
+				"destination":   "/tmp/tool.tar.gz",
+				"checksum":      "abc123",
+				"signature-url": "https://example.com/tool.tar.gz.sig",
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid gpg key",
+			params: map[string]any{
+				"url":           "https://example.com/tool.tar.gz",
+				"destination":   "/tmp/tool.tar.gz",
+				"checksum":      "abc123",
+				"signature-url": "https://example.com/tool.tar.gz.sig",
+				"gpg-key":       "not a key; rm -rf /",
+			},
+			expectError: true,
+		},
+		{
+			name: "retries on every download",
+			params: map[string]any{
+				"url":          "https://example.com/tool.tar.gz",
+				"destination":  "/tmp/tool.tar.gz",
+				"checksum-url": "https://example.com/SHA256SUMS",
+				"retries":      3,
+			},
+			contains: []string{
+				`curl -fsSL --retry 3 --retry-delay 5 --retry-connrefused -o /tmp/tool.tar.gz.checksum "https://example.com/SHA256SUMS"`,
+				`curl -fsSL --retry 3 --retry-delay 5 --retry-connrefused -o /tmp/tool.tar.gz "https://example.com/tool.tar.gz"`,
+			},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "mirrors fall back in order",
+			params: map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": "/tmp/tool.tar.gz",
+				"checksum":    "abc123",
+				"mirrors":     []any{"https://mirror1.example.com/tool.tar.gz", "https://mirror2.example.com/tool.tar.gz"},
+			},
+			contains: []string{
+				`curl -fsSL -o /tmp/tool.tar.gz "https://example.com/tool.tar.gz" || curl -fsSL -o /tmp/tool.tar.gz "https://mirror1.example.com/tool.tar.gz" || curl -fsSL -o /tmp/tool.tar.gz "https://mirror2.example.com/tool.tar.gz" && \`,
+			},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "mirrors use retries",
+			params: map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": "/tmp/tool.tar.gz",
+				"checksum":    "abc123",
+				"mirrors":     []string{"https://mirror.example.com/tool.tar.gz"},
+				"retries":     2,
+			},
+			contains: []string{
+				`|| curl -fsSL --retry 2 --retry-delay 5 --retry-connrefused -o /tmp/tool.tar.gz "https://mirror.example.com/tool.tar.gz"`,
+			},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "no mirrors has no fallback",
+			params: map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": "/tmp/tool.tar.gz",
+				"checksum":    "abc123",
+			},
+			notContains:   []string{"||"},
+			wantBuildDeps: []string{"busybox", "curl"},
+		},
+		{
+			name: "negative retries",
+			params: map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": "/tmp/tool.tar.gz",
+				"checksum":    "abc123",
+				"retries":     -1,
+			},
+			expectError: true,
+		},
+		{
+			name: "unsupported checksum algorithm",
+			params: map[string]any{
+				"url":                "https://example.com/tool.tar.gz",
+				"destination":        "/tmp/tool.tar.gz",
+				"checksum":           "abc123",
+				"checksum-algorithm": "md5",
+			},
+			expectError: true,
+		},
+		{
+			name: "missing checksum",
+			params: map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": "/tmp/tool.tar.gz",
+			},
+			expectError: true,
+		},
+		{
+			name: "unsupported archive format",
+			params: map[string]any{
+				"url":         "https://example.com/tool.rar",
+				"destination": "/tmp/tool.rar",
+				"checksum":    "abc123",
+				"extract-dir": "/opt/tool",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := DownloadVerifyExtract(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("DownloadVerifyExtract() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if len(result.Steps) != 1 {
+				t.Fatalf("expected 1 step, got %d", len(result.Steps))
+			}
+			content := result.Steps[0].Content
+			for _, expected := range tt.contains {
+				if !strings.Contains(content, expected) {
+					t.Errorf("expected content to contain %q, got:\n%s", expected, content)
+				}
+			}
+			for _, unexpected := range tt.notContains {
+				if strings.Contains(content, unexpected) {
+					t.Errorf("expected content not to contain %q, got:\n%s", unexpected, content)
+				}
+			}
+			if strings.Join(result.BuildDeps, ",") != strings.Join(tt.wantBuildDeps, ",") {
+				t.Errorf("BuildDeps = %v, want %v", result.BuildDeps, tt.wantBuildDeps)
+			}
+		})
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        map[string]any
+		expectError   bool
+		expected      []string
+		wantBuildDeps []string
+	}{
+		{
+			name: "script without args",
+			params: map[string]any{
+				"script": "scripts/setup.sh",
+			},
+			expected: []string{
+				"COPY scripts/setup.sh /tmp/dfo-scripts/setup.sh\n",
+				"RUN chmod +x /tmp/dfo-scripts/setup.sh && \\\n    /tmp/dfo-scripts/setup.sh && \\\n    rm -f /tmp/dfo-scripts/setup.sh\n",
+			},
+			wantBuildDeps: []string{"busybox"},
+		},
+		{
+			name: "script with args and build deps",
+			params: map[string]any{
+				"script":     "build.sh",
+				"args":       []any{"--prefix", "/usr/local"},
+				"build-deps": []any{"make", "gcc"},
+			},
+			expected: []string{
+				"COPY build.sh /tmp/dfo-scripts/build.sh\n",
+				"RUN chmod +x /tmp/dfo-scripts/build.sh && \\\n    /tmp/dfo-scripts/build.sh \"--prefix\" \"/usr/local\" && \\\n    rm -f /tmp/dfo-scripts/build.sh\n",
+			},
+			wantBuildDeps: []string{"busybox", "make", "gcc"},
+		},
+		{
+			name:        "missing script",
+			params:      map[string]any{},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RunScript(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("RunScript() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if len(result.Steps) != len(tt.expected) {
+				t.Fatalf("expected %d steps, got %d", len(tt.expected), len(result.Steps))
+			}
+			for i, expected := range tt.expected {
+				if result.Steps[i].Content != expected {
+					t.Errorf("step %d content = %q, want %q", i, result.Steps[i].Content, expected)
+				}
+			}
+			if strings.Join(result.BuildDeps, ",") != strings.Join(tt.wantBuildDeps, ",") {
+				t.Errorf("BuildDeps = %v, want %v", result.BuildDeps, tt.wantBuildDeps)
+			}
+		})
+	}
+}
+
+func TestApkWorld(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name: "default root",
+			params: map[string]any{
+				"packages": []any{"musl", "ca-certificates", "musl"},
+			},
+			expected: "RUN mkdir -p /etc/apk && \\\n    printf '%s\\n' ca-certificates musl > /etc/apk/world\n",
+		},
+		{
+			name: "custom root",
+			params: map[string]any{
+				"packages": []any{"busybox"},
+				"root":     "/rootfs",
+			},
+			expected: "RUN mkdir -p /rootfs/etc/apk && \\\n    printf '%s\\n' busybox > /rootfs/etc/apk/world\n",
+		},
+		{
+			name: "empty packages",
+			params: map[string]any{
+				"packages": []any{},
+			},
+			expectError: true,
+		},
+		{
+			name:        "missing packages",
+			params:      map[string]any{},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApkWorld(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ApkWorld() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if len(result.Steps) != 1 {
+				t.Fatalf("expected 1 step, got %d", len(result.Steps))
+			}
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCopyFilesPreserveParents(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   map[string]any
+		expected string
+	}{
+		{
+			name: "plain copy",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"from": "conf/app/*.yaml", "to": "/etc/app/"},
+				},
+			},
+			expected: "COPY conf/app/*.yaml /etc/app/\n",
+		},
+		{
+			name: "copy with link",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"from": "app.conf", "to": "/etc/app.conf", "link": true},
+				},
+			},
+			expected: "COPY --link app.conf /etc/app.conf\n",
+		},
+		{
+			name: "copy with chown, chmod and link",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"from": "app.conf", "to": "/etc/app.conf", "chown": "1000:1000", "chmod": "0644", "link": true},
+				},
+			},
+			expected: "COPY --chown=1000:1000 --chmod=0644 --link app.conf /etc/app.conf\n",
+		},
+		{
+			name: "preserve parents",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"from": "conf/app/*.yaml", "to": "/etc/app", "chown": "1000:1000"},
+				},
+				"preserve-parents": true,
+			},
+			expected: "RUN --mount=type=bind,target=/tmp/dfo-context \\\n" +
+				"    mkdir -p /etc/app && \\\n" +
+				"    cd /tmp/dfo-context && \\\n" +
+				"    cp -R --parents conf/app/*.yaml /etc/app && \\\n" +
+				"    chown -R 1000:1000 /etc/app/conf/app/*.yaml\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := CopyFiles(tt.params)
+			if err != nil {
+				t.Fatalf("CopyFiles() error = %v", err)
+			}
+			if len(result.Steps) != 1 {
+				t.Fatalf("expected 1 step, got %d", len(result.Steps))
+			}
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCopyFilesPreserveParentsWithLink(t *testing.T) {
+	_, err := CopyFiles(map[string]any{
+		"files": []any{
+			map[string]any{"from": "conf/app.yaml", "to": "/etc/app", "link": true},
+		},
+		"preserve-parents": true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "link cannot be used with preserve-parents") {
+		t.Errorf("CopyFiles() error = %v, want link/preserve-parents conflict", err)
+	}
+}
+
+func TestDownloadVerifyExtractCombinedErrors(t *testing.T) {
+	_, err := DownloadVerifyExtract(map[string]any{
+		"url":                "https://example.com/tool.rar",
+		"destination":        "/tmp/tool.rar",
+		"extract-dir":        "/opt/tool",
+		"checksum-algorithm": "md5",
+	})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	msg := err.Error()
+	expected := []string{
+		"at least one of checksum, checksum-url, checksums is required",
+		"unsupported archive format: /tmp/tool.rar",
+		`unsupported checksum-algorithm "md5"`,
+	}
+	last := -1
+	for _, want := range expected {
+		idx := strings.Index(msg, want)
+		if idx == -1 {
+			t.Errorf("expected error to contain %q, got: %s", want, msg)
+			continue
+		}
+		if idx < last {
+			t.Errorf("expected %q to appear after previous problems, got: %s", want, msg)
+		}
+		last = idx
+	}
+}
+
+func TestDownloadVerifyExtractChecksums(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   map[string]any
+		expected string
+		wantErr  string
+	}{
+		{
+			name:     "single checksum",
+			params:   map[string]any{"checksums": []any{"abc123"}},
+			expected: `echo "abc123  /tmp/tool.tar.gz" | sha256sum -c`,
+		},
+		{
+			name:     "any of several checksums",
+			params:   map[string]any{"checksums": []any{"abc123", "def456"}},
+			expected: `{ echo "abc123  /tmp/tool.tar.gz" | sha256sum -c || echo "def456  /tmp/tool.tar.gz" | sha256sum -c; }`,
+		},
+		{
+			name:    "empty list",
+			params:  map[string]any{"checksums": []any{}},
+			wantErr: "checksums must contain at least one checksum",
+		},
+		{
+			name:    "with checksum",
+			params:  map[string]any{"checksum": "abc123", "checksums": []any{"def456"}},
+			wantErr: "cannot specify both checksum and checksums",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": "/tmp/tool.tar.gz",
+			}
+			maps.Copy(params, tt.params)
+
+			result, err := DownloadVerifyExtract(params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			content := result.Steps[0].Content
+			if !strings.Contains(content, " && \\\n    "+tt.expected+"\n") {
+				t.Errorf("content = %q, want verification %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDownloadVerifyExtractDestinationOverlap(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		extractDir  string
+		expectError bool
+	}{
+		{name: "separate directories", destination: "/tmp/tool.tar.gz", extractDir: "/opt/tool"},
+		{name: "sibling with shared prefix", destination: "/opt/tool.tar.gz", extractDir: "/opt/tool"},
+		{name: "relative separate directories", destination: "tool.tar.gz", extractDir: "out"},
+		{name: "destination inside extract-dir", destination: "/opt/tool/tool.tar.gz", extractDir: "/opt/tool", expectError: true},
+		{name: "destination nested inside extract-dir", destination: "/opt/tool/dl/tool.tar.gz", extractDir: "/opt/tool/", expectError: true},
+		{name: "extract to root", destination: "/tmp/tool.tar.gz", extractDir: "/", expectError: true},
+		{name: "extract to working directory", destination: "tool.tar.gz", extractDir: ".", expectError: true},
+		{name: "relative destination inside extract-dir", destination: "out/tool.tar.gz", extractDir: "./out", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DownloadVerifyExtract(map[string]any{
+				"url":         "https://example.com/tool.tar.gz",
+				"destination": tt.destination,
+				"checksum":    "abc123",
+				"extract-dir": tt.extractDir,
+			})
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), "must not be inside extract-dir") {
+					t.Errorf("error = %v, want overlap error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildGoOnlyNotices(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   map[string]any
+		expected string
+	}{
+		{
+			name:     "default from output",
+			params:   map[string]any{"workdir": "/src", "output": "/server"},
+			expected: "--save_path=/notices/server",
+		},
+		{
+			name:     "explicit notices",
+			params:   map[string]any{"workdir": "/src", "output": "/server", "notices": "/licenses/server"},
+			expected: "--save_path=/licenses/server",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := BuildGoOnly(tt.params)
+			if err != nil {
+				t.Fatalf("BuildGoOnly() error = %v", err)
+			}
+			last := result.Steps[len(result.Steps)-1].Content
+			if !strings.Contains(last, tt.expected) {
+				t.Errorf("expected license step to contain %q, got %q", tt.expected, last)
+			}
+		})
+	}
+}
+
+func TestInstallToolchain(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		contains    []string
+		env         string
+	}{
+		{
+			name: "default prefix",
+			params: map[string]any{
+				"url":      "https://musl.cc/x86_64-linux-musl-cross.tgz",
+				"checksum": "abc123",
+			},
+			contains: []string{
+				`curl -fsSL -o /tmp/x86_64-linux-musl-cross.tgz "https://musl.cc/x86_64-linux-musl-cross.tgz"`,
+				`echo "abc123  /tmp/x86_64-linux-musl-cross.tgz" | sha256sum -c`,
+				`tar -xf "/tmp/x86_64-linux-musl-cross.tgz" -C "/opt/toolchain" --strip-components=1`,
+			},
+			env: "ENV PATH=\"/opt/toolchain/bin:$PATH\"\n",
+		},
+		{
+			name: "custom prefix",
+			params: map[string]any{
+				"url":              "https://example.com/toolchain.tar.xz",
+				"checksum":         "def456",
+				"prefix":           "/usr/local/cross",
+				"strip-components": 2,
+			},
+			contains: []string{
+				`tar -xf "/tmp/toolchain.tar.xz" -C "/usr/local/cross" --strip-components=2`,
+			},
+			env: "ENV PATH=\"/usr/local/cross/bin:$PATH\"\n",
+		},
+		{
+			name: "missing checksum",
+			params: map[string]any{
+				"url": "https://example.com/toolchain.tar.xz",
+			},
+			expectError: true,
+		},
+		{
+			name: "unsupported archive",
+			params: map[string]any{
+				"url":      "https://example.com/toolchain.rar",
+				"checksum": "abc123",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := InstallToolchain(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("InstallToolchain() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if len(result.Steps) != 2 {
+				t.Fatalf("expected 2 steps, got %d", len(result.Steps))
+			}
+			for _, expected := range tt.contains {
+				if !strings.Contains(result.Steps[0].Content, expected) {
+					t.Errorf("expected download step to contain %q, got:\n%s", expected, result.Steps[0].Content)
+				}
+			}
+			if result.Steps[1].Content != tt.env {
+				t.Errorf("PATH step = %q, want %q", result.Steps[1].Content, tt.env)
+			}
+		})
+	}
+}
+
+func TestCloneAndBuildNode(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		contains    []string
+		buildDeps   []string
+	}{
+		{
+			name: "npm defaults",
+			params: map[string]any{
+				"repo":   "https://github.com/example/web",
+				"tag":    "v1.0.0",
+				"output": "/app",
+			},
+			contains: []string{
+				`RUN git clone --depth=1 --branch v1.0.0 "https://github.com/example/web" /src/example/web`,
+				"RUN cd /src/example/web && npm ci\n",
+				"RUN cd /src/example/web && npm run build\n",
+				"RUN mkdir -p /app && cp -R /src/example/web/dist/. /app/\n",
+			},
+			buildDeps: []string{"busybox", "git", "nodejs", "npm"},
+		},
+		{
+			name: "yarn with overrides",
+			params: map[string]any{
+				"repo":            "https://github.com/example/web",
+				"tag":             "v1.0.0",
+				"workdir":         "/build",
+				"package-manager": "yarn",
+				"build-command":   "yarn build:prod",
+				"dist":            "public",
+				"output":          "/app",
+			},
+			contains: []string{
+				"RUN cd /build && yarn install --frozen-lockfile\n",
+				"RUN cd /build && yarn build:prod\n",
+				"cp -R /build/public/. /app/",
+			},
+			buildDeps: []string{"busybox", "git", "nodejs", "yarn"},
+		},
+		{
+			name: "pnpm install override",
+			params: map[string]any{
+				"repo":            "https://github.com/example/web",
+				"tag":             "v1.0.0",
+				"package-manager": "pnpm",
+				"install-command": "pnpm install",
+				"output":          "/app",
+			},
+			contains: []string{
+				"RUN cd /src/example/web && pnpm install\n",
+				"RUN cd /src/example/web && pnpm run build\n",
+			},
+			buildDeps: []string{"busybox", "git", "nodejs", "pnpm"},
+		},
+		{
+			name: "unsupported package manager",
+			params: map[string]any{
+				"repo":            "https://github.com/example/web",
+				"tag":             "v1.0.0",
+				"package-manager": "bun",
+				"output":          "/app",
+			},
+			expectError: true,
+		},
+		{
+			name: "missing output",
+			params: map[string]any{
+				"repo": "https://github.com/example/web",
+				"tag":  "v1.0.0",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := CloneAndBuildNode(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("CloneAndBuildNode() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			var content strings.Builder
+			for _, step := range result.Steps {
+				content.WriteString(step.Content)
+			}
+			for _, expected := range tt.contains {
+				if !strings.Contains(content.String(), expected) {
+					t.Errorf("expected output to contain %q, got:\n%s", expected, content.String())
+				}
+			}
+			if strings.Join(result.BuildDeps, ",") != strings.Join(tt.buildDeps, ",") {
+				t.Errorf("BuildDeps = %v, want %v", result.BuildDeps, tt.buildDeps)
+			}
+		})
+	}
+}
+
+func TestSetFileAttributes(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name: "single file",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"path": "/etc/app/config.yaml", "attributes": "+i"},
+				},
+			},
+			expected: "RUN chattr +i /etc/app/config.yaml\n",
+		},
+		{
+			name: "multiple files",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"path": "/etc/app/config.yaml", "attributes": "+i"},
+					map[string]any{"path": "/var/log/app.log", "attributes": "+a"},
+				},
+			},
+			expected: "RUN chattr +i /etc/app/config.yaml; \\\n    chattr +a /var/log/app.log\n",
+		},
+		{
+			name: "invalid attributes",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"path": "/etc/app/config.yaml", "attributes": "i; rm -rf /"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "missing attributes",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"path": "/etc/app/config.yaml"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name:        "missing files",
+			params:      map[string]any{},
+			expectError: true,
+		},
+		{
+			name: "unknown param",
+			params: map[string]any{
+				"files": []any{
+					map[string]any{"path": "/etc/app/config.yaml", "attributes": "+i"},
+				},
+				"file": "/etc/app/other.yaml",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SetFileAttributes(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("SetFileAttributes() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+			if !slices.Contains(result.BuildDeps, "e2fsprogs") {
+				t.Errorf("BuildDeps = %v, want to contain e2fsprogs", result.BuildDeps)
+			}
+		})
+	}
+}
+
+func TestCloneAndBuildPython(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		contains    []string
+	}{
+		{
+			name: "defaults",
+			params: map[string]any{
+				"repo": "https://github.com/example/tool",
+				"tag":  "v2.0.0",
+			},
+			contains: []string{
+				`RUN git clone --depth=1 --branch v2.0.0 "https://github.com/example/tool" /src/example/tool`,
+				"RUN python3 -m venv /opt/venv\n",
+				"RUN cd /src/example/tool && /opt/venv/bin/pip install --no-cache-dir -r requirements.txt\n",
+				"RUN cd /src/example/tool && /opt/venv/bin/pip install --no-cache-dir .\n",
+			},
+		},
+		{
+			name: "custom venv, requirements and extras",
+			params: map[string]any{
+				"repo":           "https://github.com/example/tool",
+				"tag":            "v2.0.0",
+				"workdir":        "/build",
+				"venv":           "/app/venv",
+				"requirements":   "requirements/prod.txt",
+				"extras":         []any{"postgres", "redis"},
+				"python-version": "3.12",
+			},
+			contains: []string{
+				"RUN python3.12 -m venv /app/venv\n",
+				"RUN cd /build && /app/venv/bin/pip install --no-cache-dir -r requirements/prod.txt\n",
+				`RUN cd /build && /app/venv/bin/pip install --no-cache-dir ".[postgres,redis]"`,
+			},
+		},
+		{
+			name: "missing tag",
+			params: map[string]any{
+				"repo": "https://github.com/example/tool",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := CloneAndBuildPython(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("CloneAndBuildPython() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			var content strings.Builder
+			for _, step := range result.Steps {
+				content.WriteString(step.Content)
+			}
+			for _, expected := range tt.contains {
+				if !strings.Contains(content.String(), expected) {
+					t.Errorf("expected output to contain %q, got:\n%s", expected, content.String())
+				}
+			}
+			for _, dep := range []string{"git", "python3", "py3-pip"} {
+				if !slices.Contains(result.BuildDeps, dep) {
+					t.Errorf("BuildDeps = %v, want to contain %s", result.BuildDeps, dep)
+				}
+			}
+		})
+	}
+}
+
+func TestCloneDepth(t *testing.T) {
+	tests := []struct {
+		name        string
+		pipeline    Pipeline
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name:     "default shallow clone",
+			pipeline: Clone,
+			params:   map[string]any{"repo": "https://github.com/example/tool", "tag": "v1.0.0"},
+			expected: "RUN git clone --depth=1 --branch v1.0.0 \"https://github.com/example/tool\" /src\n",
+		},
+		{
+			name:     "custom depth",
+			pipeline: Clone,
+			params:   map[string]any{"repo": "https://github.com/example/tool", "tag": "v1.0.0", "depth": 50},
+			expected: "RUN git clone --depth=50 --branch v1.0.0 \"https://github.com/example/tool\" /src\n",
+		},
+		{
+			name:     "full clone keeps branch",
+			pipeline: Clone,
+			params:   map[string]any{"repo": "https://github.com/example/tool", "tag": "v1.0.0", "depth": 0},
+			expected: "RUN git clone --branch v1.0.0 \"https://github.com/example/tool\" /src\n",
+		},
+		{
+			name:     "full clone in build pipeline",
+			pipeline: CloneAndBuildGo,
+			params:   map[string]any{"repo": "https://github.com/example/tool", "tag": "v1.0.0", "depth": 0},
+			expected: "RUN git clone --branch v1.0.0 \"https://github.com/example/tool\" /src/example/tool\n",
+		},
+		{
+			name:     "float depth from yaml",
+			pipeline: CloneAndBuildMake,
+			params:   map[string]any{"repo": "https://github.com/example/tool", "tag": "v1.0.0", "depth": float64(0)},
+			expected: "RUN git clone --branch v1.0.0 \"https://github.com/example/tool\" /src/example/tool\n",
+		},
+		{
+			name:        "negative depth",
+			pipeline:    Clone,
+			params:      map[string]any{"repo": "https://github.com/example/tool", "tag": "v1.0.0", "depth": -1},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.pipeline(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			content := stepContent(result.Steps, "Clone repository")
+			if content != tt.expected {
+				t.Errorf("clone step = %q, want %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCloneSparseCheckout(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline Pipeline
+		params   map[string]any
+		expected string
+	}{
+		{
+			name:     "no sparse paths",
+			pipeline: Clone,
+			params:   map[string]any{"repo": "https://github.com/example/mono", "tag": "v1.0.0"},
+			expected: "RUN git clone --depth=1 --branch v1.0.0 \"https://github.com/example/mono\" /src\n",
+		},
+		{
+			name:     "sparse tag clone",
+			pipeline: Clone,
+			params: map[string]any{
+				"repo":         "https://github.com/example/mono",
+				"tag":          "v1.0.0",
+				"sparse-paths": []any{"cmd/server", "pkg"},
+			},
+			expected: "RUN git clone --depth=1 --filter=blob:none --sparse --branch v1.0.0 \"https://github.com/example/mono\" /src && \\\n" +
+				"    cd /src && \\\n" +
+				"    git sparse-checkout set cmd/server pkg\n",
+		},
+		{
+			name:     "sparse commit clone",
+			pipeline: Clone,
+			params: map[string]any{
+				"repo":         "https://github.com/example/mono",
+				"commit":       "abc123",
+				"workdir":      "/build",
+				"sparse-paths": []any{"tools"},
+			},
+			expected: "RUN git clone --filter=blob:none --sparse \"https://github.com/example/mono\" /build && \\\n" +
+				"    cd /build && \\\n" +
+				"    git sparse-checkout set tools && \\\n" +
+				"    git checkout abc123\n",
+		},
+		{
+			name:     "sparse full clone in build pipeline",
+			pipeline: CloneAndBuildMake,
+			params: map[string]any{
+				"repo":         "https://github.com/example/mono",
+				"tag":          "v1.0.0",
+				"depth":        0,
+				"sparse-paths": []any{"lib"},
+			},
+			expected: "RUN git clone --filter=blob:none --sparse --branch v1.0.0 \"https://github.com/example/mono\" /src/example/mono && \\\n" +
+				"    cd /src/example/mono && \\\n" +
+				"    git sparse-checkout set lib\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.pipeline(tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			content := stepContent(result.Steps, "Clone repository")
+			if content != tt.expected {
+				t.Errorf("clone step = %q, want %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPatchStrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		pipeline    Pipeline
+		patchStrip  any
+		expectError bool
+		expected    string
+	}{
+		{
+			name:     "default strip level",
+			pipeline: CloneAndBuildGo,
+			expected: "RUN cd /src/example/tool && patch -p1 < fix.patch\n",
+		},
+		{
+			name:       "go with p0",
+			pipeline:   CloneAndBuildGo,
+			patchStrip: 0,
+			expected:   "RUN cd /src/example/tool && patch -p0 < fix.patch\n",
+		},
+		{
+			name:       "rust with p2",
+			pipeline:   CloneAndBuildRust,
+			patchStrip: float64(2),
+			expected:   "RUN cd /src/example/tool && patch -p2 < fix.patch\n",
+		},
+		{
+			name:        "negative strip level",
+			pipeline:    CloneAndBuildRust,
+			patchStrip:  -1,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"repo":    "https://github.com/example/tool",
+				"tag":     "v1.0.0",
+				"patches": []any{"fix.patch"},
+			}
+			if tt.patchStrip != nil {
+				params["patch-strip"] = tt.patchStrip
+			}
+
+			result, err := tt.pipeline(params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			content := stepContent(result.Steps, "Apply patch fix.patch")
+			if !strings.HasSuffix(content, tt.expected) {
+				t.Errorf("patch step = %q, want suffix %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPatchMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		pipeline      Pipeline
+		patchMode     string
+		expectError   bool
+		expected      string
+		wantBuildDeps []string
+		notBuildDeps  []string
+	}{
+		{
+			name:          "default patch mode",
+			pipeline:      CloneAndBuildRust,
+			expected:      "RUN cd /src/example/tool && patch -p1 < fix.patch\n",
+			wantBuildDeps: []string{"git", "patch"},
+		},
+		{
+			name:          "git apply for rust",
+			pipeline:      CloneAndBuildRust,
+			patchMode:     "git-apply",
+			expected:      "RUN cd /src/example/tool && git apply -p1 fix.patch\n",
+			wantBuildDeps: []string{"git"},
+			notBuildDeps:  []string{"patch"},
+		},
+		{
+			name:          "git apply for go",
+			pipeline:      CloneAndBuildGo,
+			patchMode:     "git-apply",
+			expected:      "RUN cd /src/example/tool && git apply -p1 fix.patch\n",
+			wantBuildDeps: []string{"git"},
+			notBuildDeps:  []string{"patch"},
+		},
+		{
+			name:        "unsupported mode",
+			pipeline:    CloneAndBuildGo,
+			patchMode:   "quilt",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"repo":    "https://github.com/example/tool",
+				"tag":     "v1.0.0",
+				"patches": []any{"fix.patch"},
+			}
+			if tt.patchMode != "" {
+				params["patch-mode"] = tt.patchMode
+			}
+
+			result, err := tt.pipeline(params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			content := stepContent(result.Steps, "Apply patch fix.patch")
+			if !strings.HasSuffix(content, tt.expected) {
+				t.Errorf("patch step = %q, want suffix %q", content, tt.expected)
+			}
+			for _, dep := range tt.wantBuildDeps {
+				if !slices.Contains(result.BuildDeps, dep) {
+					t.Errorf("build deps = %v, want %q", result.BuildDeps, dep)
+				}
+			}
+			for _, dep := range tt.notBuildDeps {
+				if slices.Contains(result.BuildDeps, dep) {
+					t.Errorf("build deps = %v, should not contain %q", result.BuildDeps, dep)
+				}
+			}
+		})
+	}
+}
+
+func TestCloneAndBuildRuntimePackages(t *testing.T) {
+	tests := []struct {
+		name         string
+		pipeline     Pipeline
+		params       map[string]any
+		wantPackages []string
+	}{
+		{
+			name:     "make with runtime packages",
+			pipeline: CloneAndBuildMake,
+			params: map[string]any{
+				"repo":             "https://github.com/example/tool",
+				"tag":              "v1.0.0",
+				"runtime-packages": []any{"libcap", "pcre2"},
+			},
+			wantPackages: []string{"libcap", "pcre2"},
+		},
+		{
+			name:     "autoconf with runtime packages",
+			pipeline: CloneAndBuildAutoconf,
+			params: map[string]any{
+				"repo":             "https://github.com/example/tool",
+				"tag":              "v1.0.0",
+				"runtime-packages": []any{"libevent"},
+			},
+			wantPackages: []string{"libevent"},
+		},
+		{
+			name:     "make without runtime packages",
+			pipeline: CloneAndBuildMake,
+			params: map[string]any{
+				"repo": "https://github.com/example/tool",
+				"tag":  "v1.0.0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.pipeline(tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !slices.Equal(result.Packages, tt.wantPackages) {
+				t.Errorf("packages = %v, want %v", result.Packages, tt.wantPackages)
+			}
+			for _, pkg := range tt.wantPackages {
+				if slices.Contains(result.BuildDeps, pkg) {
+					t.Errorf("runtime package %q should not be a build dep", pkg)
+				}
+			}
+		})
+	}
+}
+
+func TestStripMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		pipeline    Pipeline
+		stripMode   string
+		expectError bool
+		expected    string
+	}{
+		{
+			name:     "default strips all symbols",
+			pipeline: CloneAndBuildMake,
+			expected: "-exec strip {} +",
+		},
+		{
+			name:      "all",
+			pipeline:  CloneAndBuildAutoconf,
+			stripMode: "all",
+			expected:  "-exec strip {} +",
+		},
+		{
+			name:      "debug",
+			pipeline:  CloneAndBuildMake,
+			stripMode: "debug",
+			expected:  "-exec strip --strip-debug {} +",
+		},
+		{
+			name:      "unneeded",
+			pipeline:  CloneAndBuildAutoconf,
+			stripMode: "unneeded",
+			expected:  "-exec strip --strip-unneeded {} +",
+		},
+		{
+			name:        "unsupported mode",
+			pipeline:    CloneAndBuildMake,
+			stripMode:   "everything",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"repo": "https://github.com/example/tool",
+				"tag":  "v1.0.0",
+			}
+			if tt.stripMode != "" {
+				params["strip-mode"] = tt.stripMode
+			}
+
+			result, err := tt.pipeline(params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			stripContent := stepContent(result.Steps, "Strip binaries")
+			if !strings.Contains(stripContent, tt.expected) {
+				t.Errorf("strip step = %q, want it to contain %q", stripContent, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGoBuildCacheSharedAcrossStages(t *testing.T) {
+	stages := []struct {
+		name     string
+		pipeline Pipeline
+		params   map[string]any
+	}{
+		{
+			name:     "clone-and-build-go",
+			pipeline: CloneAndBuildGo,
+			params: map[string]any{
+				"repo":        "https://github.com/example/server",
+				"tag":         "v1.0.0",
+				"build-cache": true,
+			},
+		},
+		{
+			name:     "build-go-static",
+			pipeline: BuildGo,
+			params: map[string]any{
+				"repo":        "https://github.com/example/client",
+				"tag":         "v2.0.0",
+				"build-cache": true,
+			},
+		},
+		{
+			name:     "build-go-only",
+			pipeline: BuildGoOnly,
+			params: map[string]any{
+				"workdir":     "/src/tool",
+				"output":      "/tool",
+				"build-cache": true,
+			},
+		},
+	}
+
+	mount := "RUN --mount=type=cache,id=dfo-go-build,target=/root/.cache/go-build "
+	for _, stage := range stages {
+		t.Run(stage.name, func(t *testing.T) {
+			result, err := stage.pipeline(stage.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			content := stepContent(result.Steps, "Build binary")
+			if !strings.HasPrefix(content, mount) {
+				t.Errorf("build step = %q, want prefix %q", content, mount)
+			}
+			if !strings.Contains(content, "GOCACHE=/root/.cache/go-build go build") {
+				t.Errorf("build step = %q, want GOCACHE pointing at the mount", content)
+			}
+		})
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		result, err := BuildGoOnly(map[string]any{"workdir": "/src"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if content := stepContent(result.Steps, "Build binary"); strings.Contains(content, "--mount") {
+			t.Errorf("build step = %q, want no cache mount", content)
+		}
+	})
+}
+
+func stepContent(steps []Step, name string) string {
+	for _, step := range steps {
+		if step.Name == name {
+			return step.Content
+		}
+	}
+	return ""
+}
+
+func TestVerifyStatic(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name:     "ldd check",
+			params:   map[string]any{"path": "/rootfs/app"},
+			expected: "RUN ldd /rootfs/app 2>&1 | grep -q \"not a dynamic executable\" || (ldd /rootfs/app; exit 1)\n",
+		},
+		{
+			name:        "missing path",
+			params:      map[string]any{},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := VerifyStatic(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("VerifyStatic() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+			if !slices.Contains(result.BuildDeps, "musl-utils") {
+				t.Errorf("build deps = %v, want musl-utils", result.BuildDeps)
+			}
+		})
+	}
+}
+
+func TestSetPermissions(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name: "single path",
+			params: map[string]any{
+				"paths": []any{
+					map[string]any{"path": "/app/run.sh", "mode": "0755"},
+				},
+			},
+			expected: "RUN chmod 0755 /app/run.sh\n",
+		},
+		{
+			name: "recursive and plain paths",
+			params: map[string]any{
+				"paths": []any{
+					map[string]any{"path": "/data", "mode": "u+rwX,go-rwx", "recursive": true},
+					map[string]any{"path": "/app/server", "mode": "0555"},
+				},
+			},
+			expected: "RUN chmod -R u+rwX,go-rwx /data; \\\n    chmod 0555 /app/server\n",
+		},
+		{
+			name: "missing mode",
+			params: map[string]any{
+				"paths": []any{
+					map[string]any{"path": "/data"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "empty path",
+			params: map[string]any{
+				"paths": []any{
+					map[string]any{"path": "", "mode": "0755"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "non-boolean recursive",
+			params: map[string]any{
+				"paths": []any{
+					map[string]any{"path": "/data", "mode": "0755", "recursive": "yes"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name:        "missing paths",
+			params:      map[string]any{},
+			expectError: true,
+		},
+		{
+			name: "empty paths",
+			params: map[string]any{
+				"paths": []any{},
+			},
+			expectError: true,
+		},
+		{
+			name: "unknown param",
+			params: map[string]any{
+				"paths": []any{
+					map[string]any{"path": "/data", "mode": "0755"},
+				},
+				"recursive": true,
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SetPermissions(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("SetPermissions() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRemovePaths(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name: "multiple paths",
+			params: map[string]any{
+				"paths": []any{"/src", "/root/.cache", "/usr/share/man"},
+			},
+			expected: "RUN rm -rf /src /root/.cache /usr/share/man\n",
+		},
+		{
+			name: "single path as string slice",
+			params: map[string]any{
+				"paths": []string{"/tmp/build"},
+			},
+			expected: "RUN rm -rf /tmp/build\n",
+		},
+		{
+			name:        "missing paths",
+			params:      map[string]any{},
+			expectError: true,
+		},
+		{
+			name: "empty paths",
+			params: map[string]any{
+				"paths": []any{},
+			},
+			expectError: true,
+		},
+		{
+			name: "empty path",
+			params: map[string]any{
+				"paths": []any{"/src", ""},
+			},
+			expectError: true,
+		},
+		{
+			name: "root path",
+			params: map[string]any{
+				"paths": []any{"/"},
+			},
+			expectError: true,
+		},
+		{
+			name: "root path in disguise",
+			params: map[string]any{
+				"paths": []any{"/src", "//."},
+			},
+			expectError: true,
+		},
+		{
+			name: "paths wrong type",
+			params: map[string]any{
+				"paths": 42,
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RemovePaths(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("RemovePaths() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrimRootfs(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name:     "defaults",
+			params:   map[string]any{},
+			expected: "RUN rm -rf /rootfs/usr/share/man /rootfs/usr/share/doc /rootfs/var/cache/apk/*\n",
+		},
+		{
+			name: "custom rootfs and items",
+			params: map[string]any{
+				"rootfs": "/out/",
+				"remove": []any{"info", "apk-cache"},
+			},
+			expected: "RUN rm -rf /out/usr/share/info /out/var/cache/apk/*\n",
+		},
+		{
+			name: "unsupported item",
+			params: map[string]any{
+				"remove": []any{"man", "everything"},
+			},
+			expectError: true,
+		},
+		{
+			name: "empty remove list",
+			params: map[string]any{
+				"remove": []any{},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := TrimRootfs(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("TrimRootfs() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name: "heredoc",
+			params: map[string]any{
+				"path":    "/etc/app.conf",
+				"content": "listen = 8080\nlog = stdout\n",
+			},
+			expected: "RUN cat > /etc/app.conf <<'EOF'\nlisten = 8080\nlog = stdout\nEOF\n",
+		},
+		{
+			name: "adds trailing newline and chmod",
+			params: map[string]any{
+				"path":    "/usr/local/bin/entrypoint",
+				"content": "#!/bin/sh\nexec /app \"$@\"",
+				"mode":    "0755",
+			},
+			expected: "RUN cat > /usr/local/bin/entrypoint <<'EOF'\n#!/bin/sh\nexec /app \"$@\"\nEOF\nRUN chmod 0755 /usr/local/bin/entrypoint\n",
+		},
+		{
+			name: "append",
+			params: map[string]any{
+				"path":    "/etc/hosts",
+				"content": "127.0.0.1 app\n",
+				"append":  true,
+			},
+			expected: "RUN cat >> /etc/hosts <<'EOF'\n127.0.0.1 app\nEOF\n",
+		},
+		{
+			name: "content containing the delimiter",
+			params: map[string]any{
+				"path":    "/usr/local/bin/gen",
+				"content": "cat <<EOF\nhello\nEOF\n",
+			},
+			expected: "RUN cat > /usr/local/bin/gen <<'EOF_1'\ncat <<EOF\nhello\nEOF\nEOF_1\n",
+		},
+		{
+			name: "missing path",
+			params: map[string]any{
+				"content": "hello",
+			},
+			expectError: true,
+		},
+		{
+			name: "missing content",
+			params: map[string]any{
+				"path": "/etc/app.conf",
+			},
+			expectError: true,
+		},
+		{
+			name: "non-boolean append",
+			params: map[string]any{
+				"path":    "/etc/app.conf",
+				"content": "hello",
+				"append":  "yes",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := WriteFile(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("WriteFile() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		wantPath    string
+		wantJSON    string
+		wantSed     bool
+	}{
+		{
+			name: "all fields by default",
+			params: map[string]any{
+				"versions": map[string]string{"alpine": "3.20.3", "go": "1.23.1"},
+				"bom-hash": "sha256:abc123",
+			},
+			wantPath: "/etc/build-info.json",
+			wantJSON: "{\n  \"bom-hash\": \"sha256:abc123\",\n  \"build-time\": \"@BUILD_TIME@\",\n  \"versions\": {\n    \"alpine\": \"3.20.3\",\n    \"go\": \"1.23.1\"\n  }\n}\n",
+			wantSed:  true,
+		},
+		{
+			name: "selected fields and custom path",
+			params: map[string]any{
+				"path":     "/build.json",
+				"fields":   []any{"versions"},
+				"versions": map[string]string{"app": "v1.0.0"},
+				"bom-hash": "sha256:abc123",
+			},
+			wantPath: "/build.json",
+			wantJSON: "{\n  \"versions\": {\n    \"app\": \"v1.0.0\"\n  }\n}\n",
+		},
+		{
+			name: "versions missing",
+			params: map[string]any{
+				"fields": []any{"versions"},
+			},
+			wantPath: "/etc/build-info.json",
+			wantJSON: "{\n  \"versions\": {}\n}\n",
+		},
+		{
+			name: "unsupported field",
+			params: map[string]any{
+				"fields": []any{"hostname"},
+			},
+			expectError: true,
+		},
+		{
+			name: "empty fields",
+			params: map[string]any{
+				"fields": []any{},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := BuildInfo(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("BuildInfo() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			content := result.Steps[0].Content
+			heredoc := fmt.Sprintf("RUN cat > %s <<'EOF'\n%sEOF\n", tt.wantPath, tt.wantJSON)
+			if !strings.HasPrefix(content, heredoc) {
+				t.Errorf("content = %q, want prefix %q", content, heredoc)
+			}
+
+			sed := fmt.Sprintf("RUN sed -i \"s/@BUILD_TIME@/$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)/\" %s\n", tt.wantPath)
+			if strings.Contains(content, sed) != tt.wantSed {
+				t.Errorf("content = %q, want build time substitution: %v", content, tt.wantSed)
+			}
+		})
+	}
+}
+
+func TestHardenWritableDirs(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expectError bool
+		expected    string
+	}{
+		{
+			name:     "defaults",
+			params:   map[string]any{},
+			expected: "RUN mkdir -p /tmp /var/tmp /run; \\\n    chmod 1777 /tmp; \\\n    chmod 1777 /var/tmp; \\\n    chmod 1777 /run\n",
+		},
+		{
+			name: "custom directories in rootfs",
+			params: map[string]any{
+				"rootfs": "/rootfs",
+				"directories": []any{
+					map[string]any{"path": "/tmp"},
+					map[string]any{"path": "/run", "mode": "0755"},
+				},
+			},
+			expected: "RUN mkdir -p /rootfs/tmp /rootfs/run; \\\n    chmod 1777 /rootfs/tmp; \\\n    chmod 0755 /rootfs/run\n",
+		},
+		{
+			name: "invalid mode",
+			params: map[string]any{
+				"directories": []any{
+					map[string]any{"path": "/tmp", "mode": "u+t"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "empty directories",
+			params: map[string]any{
+				"directories": []any{},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := HardenWritableDirs(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("HardenWritableDirs() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if result.Steps[0].Content != tt.expected {
+				t.Errorf("content = %q, want %q", result.Steps[0].Content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGoVersionLdflags(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline Pipeline
+		params   map[string]any
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "clone-and-build-go",
+			pipeline: CloneAndBuildGo,
+			params: map[string]any{
+				"repo":          "https://github.com/example/server",
+				"tag":           "v1.0.0",
+				"version-var":   "main.version",
+				"version-value": "v1.0.0",
+			},
+			expected: `-ldflags='-s -w -extldflags "-static" -X "main.version=v1.0.0"'`,
+		},
+		{
+			name:     "build-go-static",
+			pipeline: BuildGo,
+			params: map[string]any{
+				"repo":          "https://github.com/example/client",
+				"tag":           "v2.0.0",
+				"version-var":   "github.com/example/client/internal.Version",
+				"version-value": "2.0.0",
+			},
+			expected: `-X "github.com/example/client/internal.Version=2.0.0"'`,
+		},
+		{
+			name:     "without version",
+			pipeline: BuildGoOnly,
+			params:   map[string]any{"workdir": "/src/tool"},
+			expected: `-ldflags='-s -w -extldflags "-static"' `,
+		},
+		{
+			name:     "var without value",
+			pipeline: BuildGoOnly,
+			params:   map[string]any{"workdir": "/src/tool", "version-var": "main.version"},
+			wantErr:  true,
+		},
+		{
+			name:     "value with quotes",
+			pipeline: BuildGoOnly,
+			params:   map[string]any{"workdir": "/src/tool", "version-var": "main.version", "version-value": "it's"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.pipeline(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			content := stepContent(result.Steps, "Build binary")
+			if !strings.Contains(content, tt.expected) {
+				t.Errorf("build step = %q, want it to contain %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCloneAndBuildRustTarget(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		expectedDir  string
+		expectedFlag string
+	}{
+		{
+			name:         "default target",
+			expectedFlag: "--target x86_64-unknown-linux-musl\n",
+			expectedDir:  "/src/example/tool/target/x86_64-unknown-linux-musl/release ",
+		},
+		{
+			name:         "custom target",
+			target:       "aarch64-unknown-linux-musl",
+			expectedFlag: "--target aarch64-unknown-linux-musl\n",
+			expectedDir:  "/src/example/tool/target/aarch64-unknown-linux-musl/release ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"repo": "https://github.com/example/tool",
+				"tag":  "v1.0.0",
+			}
+			if tt.target != "" {
+				params["target"] = tt.target
+			}
+
+			result, err := CloneAndBuildRust(params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if build := stepContent(result.Steps, "Build binary"); !strings.HasSuffix(build, tt.expectedFlag) {
+				t.Errorf("build step = %q, want suffix %q", build, tt.expectedFlag)
+			}
+			if cp := stepContent(result.Steps, "Copy binary to final location"); !strings.Contains(cp, tt.expectedDir) {
+				t.Errorf("copy step = %q, want it to contain %q", cp, tt.expectedDir)
+			}
+		})
+	}
+}
+
+func TestMakeJobs(t *testing.T) {
+	tests := []struct {
+		name        string
+		pipeline    Pipeline
+		params      map[string]any
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "make defaults to nproc",
+			pipeline: CloneAndBuildMake,
+			expected: "RUN make -j$(nproc); \\\n    make install\n",
+		},
+		{
+			name:     "autoconf with explicit jobs",
+			pipeline: CloneAndBuildAutoconf,
+			params:   map[string]any{"jobs": 4},
+			expected: "RUN make -j4; \\\n    make install\n",
+		},
+		{
+			name:     "custom make steps untouched",
+			pipeline: CloneAndBuildMake,
+			params:   map[string]any{"jobs": 4, "make-steps": []any{"make static"}},
+			expected: "RUN make static\n",
+		},
+		{
+			name:        "negative jobs",
+			pipeline:    CloneAndBuildMake,
+			params:      map[string]any{"jobs": -1},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"repo": "https://github.com/example/tool",
+				"tag":  "v1.0.0",
+			}
+			for key, value := range tt.params {
+				params[key] = value
+			}
+
+			result, err := tt.pipeline(params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if content := stepContent(result.Steps, "Build with make"); !strings.HasSuffix(content, tt.expected) {
+				t.Errorf("make step = %q, want suffix %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCloneAndBuildAutoconfAutogen(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        map[string]any
+		expected      string
+		expectLibtool bool
+		expectError   bool
+	}{
+		{
+			name:     "disabled by default",
+			params:   map[string]any{},
+			expected: "",
+		},
+		{
+			name:          "autogen.sh",
+			params:        map[string]any{"autogen": true},
+			expected:      "RUN cd /src/example/tool && ./autogen.sh\n",
+			expectLibtool: true,
+		},
+		{
+			name:          "custom command",
+			params:        map[string]any{"autogen": true, "autogen-command": "autoreconf -fi"},
+			expected:      "RUN cd /src/example/tool && autoreconf -fi\n",
+			expectLibtool: true,
+		},
+		{
+			name:          "custom command implies autogen",
+			params:        map[string]any{"autogen-command": "autoreconf -fi"},
+			expected:      "RUN cd /src/example/tool && autoreconf -fi\n",
+			expectLibtool: true,
+		},
+		{
+			name:        "custom command with autogen disabled",
+			params:      map[string]any{"autogen": false, "autogen-command": "autoreconf -fi"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"repo": "https://github.com/example/tool",
+				"tag":  "v1.0.0",
+			}
+			for key, value := range tt.params {
+				params[key] = value
+			}
+
+			result, err := CloneAndBuildAutoconf(params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("CloneAndBuildAutoconf() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if content := stepContent(result.Steps, "Generate configure script"); content != tt.expected {
+				t.Errorf("autogen step = %q, want %q", content, tt.expected)
+			}
+			if slices.Contains(result.BuildDeps, "libtool") != tt.expectLibtool {
+				t.Errorf("BuildDeps = %v, expect libtool %v", result.BuildDeps, tt.expectLibtool)
+			}
+
+			if tt.expected == "" {
+				return
+			}
+			autogenIndex := slices.IndexFunc(result.Steps, func(step Step) bool { return step.Name == "Generate configure script" })
+			configureIndex := slices.IndexFunc(result.Steps, func(step Step) bool { return step.Name == "Configure" })
+			if autogenIndex == -1 || configureIndex == -1 || autogenIndex > configureIndex {
+				t.Errorf("autogen step at %d should precede configure step at %d", autogenIndex, configureIndex)
+			}
+		})
+	}
+}
+
+func TestCreateUserGroups(t *testing.T) {
+	tests := []struct {
+		name        string
+		groups      any
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "no supplementary groups",
+			expected: "RUN addgroup -g 1000 app && \\\n    adduser -D -u 1000 -G app app\n",
+		},
+		{
+			name:     "supplementary groups",
+			groups:   []any{"docker", "tty"},
+			expected: "RUN addgroup -g 1000 app && \\\n    adduser -D -u 1000 -G app app && \\\n    addgroup app docker && \\\n    addgroup app tty\n",
+		},
+		{
+			name:        "non-string group",
+			groups:      []any{"docker", 999},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{"username": "app", "uid": 1000, "gid": 1000}
+			if tt.groups != nil {
+				params["groups"] = tt.groups
+			}
+
+			result, err := CreateUser(params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if content := stepContent(result.Steps, "Create application user"); content != tt.expected {
+				t.Errorf("content = %q, want %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCreateUserHomeAndShell(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "defaults",
+			expected: "adduser -D -u 1000 -G app app",
+		},
+		{
+			name:     "home",
+			params:   map[string]any{"home": "/var/lib/app"},
+			expected: "adduser -D -h /var/lib/app -u 1000 -G app app",
+		},
+		{
+			name:     "shell",
+			params:   map[string]any{"shell": "/sbin/nologin"},
+			expected: "adduser -D -s /sbin/nologin -u 1000 -G app app",
+		},
+		{
+			name:     "no create home",
+			params:   map[string]any{"no-create-home": true},
+			expected: "adduser -D -H -u 1000 -G app app",
+		},
+		{
+			name:     "all options",
+			params:   map[string]any{"home": "/data", "shell": "/sbin/nologin", "no-create-home": true},
+			expected: "adduser -D -h /data -s /sbin/nologin -H -u 1000 -G app app",
+		},
+		{
+			name:        "invalid shell type",
+			params:      map[string]any{"shell": 1},
+			expectError: true,
+		},
+		{
+			name:        "invalid no-create-home type",
+			params:      map[string]any{"no-create-home": "yes"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{"username": "app", "uid": 1000, "gid": 1000}
+			for key, value := range tt.params {
+				params[key] = value
+			}
+
+			result, err := CreateUser(params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if content := stepContent(result.Steps, "Create application user"); !strings.Contains(content, tt.expected+"\n") {
+				t.Errorf("content = %q, want it to contain %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCreateDirectoriesOwner(t *testing.T) {
+	tests := []struct {
+		name        string
+		directories []any
+		expected    string
+	}{
+		{
+			name:        "without owner",
+			directories: []any{map[string]any{"path": "/data", "permissions": "750"}},
+			expected:    "RUN mkdir -p /data; \\\n    chmod 750 /data\n",
+		},
+		{
+			name:        "with owner",
+			directories: []any{map[string]any{"path": "/data", "owner": "1000:1000", "permissions": "750"}},
+			expected:    "RUN mkdir -p /data; \\\n    chmod 750 /data; \\\n    chown 1000:1000 /data\n",
+		},
+		{
+			name: "owner from volume template step",
+			directories: []any{
+				map[string]any{"path": "/data", "owner": "65532:65532", "permissions": ""},
+				map[string]any{"path": "/config", "owner": "", "permissions": ""},
+			},
+			expected: "RUN mkdir -p /data /config; \\\n    chown 65532:65532 /data\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := CreateDirectories(map[string]any{"directories": tt.directories})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if content := stepContent(result.Steps, "Create directories"); content != tt.expected {
+				t.Errorf("content = %q, want %q", content, tt.expected)
+			}
+			if !slices.Equal(result.BuildDeps, []string{"busybox"}) {
+				t.Errorf("BuildDeps = %v, want [busybox]", result.BuildDeps)
+			}
+		})
+	}
+}
+
+func TestSetupUsersGroupsMembers(t *testing.T) {
+	tests := []struct {
+		name        string
+		group       map[string]any
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "no members",
+			group:    map[string]any{"name": "docker", "gid": 998},
+			expected: `echo "docker:x:998:" >> /rootfs/etc/group`,
+		},
+		{
+			name:     "members",
+			group:    map[string]any{"name": "docker", "gid": 998, "members": []any{"alice", "bob"}},
+			expected: `echo "docker:x:998:alice,bob" >> /rootfs/etc/group`,
+		},
+		{
+			name:        "non-string member",
+			group:       map[string]any{"name": "docker", "gid": 998, "members": []any{"alice", 1000}},
+			expectError: true,
+		},
+		{
+			name:        "member with separator",
+			group:       map[string]any{"name": "docker", "gid": 998, "members": []any{"alice,bob"}},
+			expectError: true,
+		},
+		{
+			name:        "members not an array",
+			group:       map[string]any{"name": "docker", "gid": 998, "members": "alice"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SetupUsersGroups(map[string]any{
+				"rootfs": "/rootfs",
+				"groups": []any{tt.group},
+			})
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if len(result.Steps) != 1 || !strings.Contains(result.Steps[0].Content, tt.expected) {
+				t.Errorf("steps = %+v, want group line %q", result.Steps, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        map[string]any
+		expected      string
+		wantBuildDeps []string
+		expectError   bool
+	}{
+		{
+			name:          "json",
+			params:        map[string]any{"path": "/etc/app/config.json", "format": "json"},
+			expected:      "RUN jq empty /etc/app/config.json\n",
+			wantBuildDeps: []string{"busybox", "jq"},
+		},
+		{
+			name:          "yaml",
+			params:        map[string]any{"path": "/etc/app/config.yaml", "format": "yaml"},
+			expected:      "RUN python3 -c 'import sys, yaml; yaml.safe_load(open(sys.argv[1]))' /etc/app/config.yaml\n",
+			wantBuildDeps: []string{"busybox", "python3", "py3-yaml"},
+		},
+		{
+			name:        "unsupported format",
+			params:      map[string]any{"path": "/etc/app/config.toml", "format": "toml"},
+			expectError: true,
+		},
+		{
+			name:        "missing format",
+			params:      map[string]any{"path": "/etc/app/config.json"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ValidateConfigFile(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if content := stepContent(result.Steps, "Validate config file"); content != tt.expected {
+				t.Errorf("content = %q, want %q", content, tt.expected)
+			}
+			if !slices.Equal(result.BuildDeps, tt.wantBuildDeps) {
+				t.Errorf("BuildDeps = %v, want %v", result.BuildDeps, tt.wantBuildDeps)
+			}
+		})
+	}
+}
+
+func TestSetupUsersGroupsSkipExisting(t *testing.T) {
+	tests := []struct {
+		name         string
+		skipExisting any
+		expected     []string
+	}{
+		{
+			name: "default appends unconditionally",
+			expected: []string{
+				"    echo \"nonroot:x:65532:\" >> /rootfs/etc/group",
+				"    echo \"nonroot:x:65532:65532:nonroot:/nonexistent:/sbin/nologin\" >> /rootfs/etc/passwd",
+			},
+		},
+		{
+			name:         "skip existing guards each line",
+			skipExisting: true,
+			expected: []string{
+				"    grep -q \"^nonroot:\" /rootfs/etc/group || echo \"nonroot:x:65532:\" >> /rootfs/etc/group",
+				"    grep -q \"^nonroot:\" /rootfs/etc/passwd || echo \"nonroot:x:65532:65532:nonroot:/nonexistent:/sbin/nologin\" >> /rootfs/etc/passwd",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"rootfs": "/rootfs",
+				"groups": []any{map[string]any{"name": "nonroot", "gid": 65532}},
+				"users":  []any{map[string]any{"username": "nonroot", "uid": 65532, "gid": 65532}},
+			}
+			if tt.skipExisting != nil {
+				params["skip-existing"] = tt.skipExisting
+			}
+
+			result, err := SetupUsersGroups(params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			content := result.Steps[0].Content
+			for _, line := range tt.expected {
+				if !strings.Contains(content, line+"; \\\n") && !strings.HasSuffix(content, line+"\n") {
+					t.Errorf("content = %q, want line %q", content, line)
+				}
+			}
+			if tt.skipExisting == nil && strings.Contains(content, "grep -q") {
+				t.Errorf("content = %q, want no existence guard", content)
+			}
+		})
+	}
+}
+
+func TestRunMigrations(t *testing.T) {
+	tests := []struct {
+		name              string
+		params            map[string]any
+		expectedMigrate   string
+		expectedCopy      string
+		expectedBuildDeps []string
+		expectError       bool
+	}{
+		{
+			name: "minimal",
+			params: map[string]any{
+				"command":   "/main migrate --db /data/app.db",
+				"output-db": "/data/app.db",
+			},
+			expectedMigrate: "RUN mkdir -p /data && \\\n" +
+				"    /main migrate --db /data/app.db\n",
+			expectedCopy: "RUN mkdir -p /rootfs/data && \\\n" +
+				"    cp /data/app.db /rootfs/data/app.db\n",
+			expectedBuildDeps: []string{"busybox"},
+		},
+		{
+			name: "workdir, owner and build deps",
+			params: map[string]any{
+				"command":    "sqlite3 /var/lib/soju/main.db < schema.sql",
+				"output-db":  "/var/lib/soju/main.db",
+				"workdir":    "/src/db",
+				"rootfs":     "/out",
+				"owner":      "65532:65532",
+				"build-deps": []any{"sqlite"},
+			},
+			expectedMigrate: "RUN mkdir -p /var/lib/soju && \\\n" +
+				"    cd /src/db && \\\n" +
+				"    sqlite3 /var/lib/soju/main.db < schema.sql\n",
+			expectedCopy: "RUN mkdir -p /out/var/lib/soju && \\\n" +
+				"    cp /var/lib/soju/main.db /out/var/lib/soju/main.db && \\\n" +
+				"    chown 65532:65532 /out/var/lib/soju/main.db\n",
+			expectedBuildDeps: []string{"busybox", "sqlite"},
+		},
+		{
+			name:        "missing output-db",
+			params:      map[string]any{"command": "/main migrate"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RunMigrations(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("RunMigrations() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if migrate := stepContent(result.Steps, "Run migrations"); migrate != tt.expectedMigrate {
+				t.Errorf("migration step = %q, want %q", migrate, tt.expectedMigrate)
+			}
+			if cp := stepContent(result.Steps, "Copy database into rootfs"); cp != tt.expectedCopy {
+				t.Errorf("copy step = %q, want %q", cp, tt.expectedCopy)
+			}
+			if !slices.Equal(result.BuildDeps, tt.expectedBuildDeps) {
+				t.Errorf("BuildDeps = %v, want %v", result.BuildDeps, tt.expectedBuildDeps)
+			}
+		})
+	}
+}
+
+func TestWriteSysctls(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "default path",
+			params: map[string]any{
+				"tunables": map[string]any{
+					"net.ipv4.ip_unprivileged_port_start": "0",
+					"net.core.somaxconn":                  "1024",
+				},
+			},
+			expected: "RUN mkdir -p /rootfs/etc/sysctl.d && cat > /rootfs/etc/sysctl.d/99-recommended.conf <<'EOF'\n" +
+				"# Recommended kernel tunables. Containers cannot apply sysctls at build time;\n" +
+				"# set them at runtime, e.g. docker run --sysctl net.core.somaxconn=1024\n" +
+				"net.core.somaxconn = 1024\n" +
+				"net.ipv4.ip_unprivileged_port_start = 0\n" +
+				"EOF\n",
+		},
+		{
+			name: "custom rootfs and path",
+			params: map[string]any{
+				"tunables": map[string]any{"vm.overcommit_memory": "1"},
+				"rootfs":   "/out",
+				"path":     "/usr/lib/sysctl.d/50-redis.conf",
+			},
+			expected: "RUN mkdir -p /out/usr/lib/sysctl.d && cat > /out/usr/lib/sysctl.d/50-redis.conf <<'EOF'\n" +
+				"# Recommended kernel tunables. Containers cannot apply sysctls at build time;\n" +
+				"# set them at runtime, e.g. docker run --sysctl vm.overcommit_memory=1\n" +
+				"vm.overcommit_memory = 1\n" +
+				"EOF\n",
+		},
+		{
+			name:        "empty tunables",
+			params:      map[string]any{"tunables": map[string]any{}},
+			expectError: true,
+		},
+		{
+			name:        "invalid key",
+			params:      map[string]any{"tunables": map[string]any{"net.core.somaxconn=1": "1024"}},
+			expectError: true,
+		},
+		{
+			name:        "non-string value",
+			params:      map[string]any{"tunables": map[string]any{"net.core.somaxconn": 1024}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := WriteSysctls(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("WriteSysctls() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if content := stepContent(result.Steps, "Write sysctl tunables"); content != tt.expected {
+				t.Errorf("content = %q, want %q", content, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetupNsswitch(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]any
+		expected    string
+		expectError bool
+	}{
+		{
+			name:   "default root",
+			params: map[string]any{},
+			expected: "RUN mkdir -p /etc && cat > /etc/nsswitch.conf <<'EOF'\n" +
+				"passwd:    files\n" +
+				"group:     files\n" +
+				"shadow:    files\n" +
+				"hosts:     files dns\n" +
+				"networks:  files\n" +
+				"protocols: files\n" +
+				"services:  files\n" +
+				"EOF\n",
+		},
+		{
+			name:   "rootfs with custom hosts",
+			params: map[string]any{"root": "/rootfs", "hosts": "dns files"},
+			expected: "RUN mkdir -p /rootfs/etc && cat > /rootfs/etc/nsswitch.conf <<'EOF'\n" +
+				"passwd:    files\n" +
+				"group:     files\n" +
+				"shadow:    files\n" +
+				"hosts:     dns files\n" +
+				"networks:  files\n" +
+				"protocols: files\n" +
+				"services:  files\n" +
+				"EOF\n",
+		},
+		{
+			name:        "empty hosts",
+			params:      map[string]any{"hosts": " "},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SetupNsswitch(tt.params)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("SetupNsswitch() error = %v, expectError %v", err, tt.expectError)
+			}
+			if tt.expectError {
+				return
+			}
+
+			if content := stepContent(result.Steps, "Write nsswitch.conf"); content != tt.expected {
+				t.Errorf("content = %q, want %q", content, tt.expected)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package pipelines
 import (
 	"fmt"
 	"strings"
+
+	"github.com/greboid/dfo/pkg/util"
 )
 
 type ParamType string
@@ -13,6 +15,7 @@ const (
 	TypeBool        ParamType = "bool"
 	TypeStringArray ParamType = "string_array"
 	TypeObjectArray ParamType = "object_array"
+	TypeStringMap   ParamType = "string_map"
 )
 
 type ParamSpec struct {
@@ -27,6 +30,8 @@ type PipelineSignature struct {
 	Parameters        map[string]ParamSpec
 	MutuallyExclusive [][]string
 	AtLeastOne        [][]string
+	// RejectUnknown makes parameters missing from Parameters an error.
+	RejectUnknown bool
 }
 
 var Signatures = map[string]PipelineSignature{
@@ -34,9 +39,13 @@ var Signatures = map[string]PipelineSignature{
 		Name:        "create-user",
 		Description: "Create a user and group in the container",
 		Parameters: map[string]ParamSpec{
-			"username": {Type: TypeString, Required: true, Description: "Username to create"},
-			"uid":      {Type: TypeInt, Required: true, Description: "User ID"},
-			"gid":      {Type: TypeInt, Required: true, Description: "Group ID"},
+			"username":       {Type: TypeString, Required: true, Description: "Username to create"},
+			"uid":            {Type: TypeInt, Required: true, Description: "User ID"},
+			"gid":            {Type: TypeInt, Required: true, Description: "Group ID"},
+			"groups":         {Type: TypeStringArray, Required: false, Description: "Supplementary groups to add the user to"},
+			"home":           {Type: TypeString, Required: false, Description: "Home directory (default: /home/USERNAME)"},
+			"shell":          {Type: TypeString, Required: false, Description: "Login shell (default: /bin/sh)"},
+			"no-create-home": {Type: TypeBool, Required: false, Description: "Do not create the home directory (default: false)"},
 		},
 	},
 	"set-ownership": {
@@ -48,20 +57,68 @@ var Signatures = map[string]PipelineSignature{
 			"path":  {Type: TypeString, Required: true, Description: "Path to change ownership of"},
 		},
 	},
+	"set-permissions": {
+		Name:        "set-permissions",
+		Description: "Change permissions of existing paths without changing ownership",
+		Parameters: map[string]ParamSpec{
+			"paths": {Type: TypeObjectArray, Required: true, Description: "Paths to update (path, mode, recursive)"},
+		},
+		RejectUnknown: true,
+	},
+	"remove-paths": {
+		Name:        "remove-paths",
+		Description: "Remove build leftovers to shrink the image",
+		Parameters: map[string]ParamSpec{
+			"paths": {Type: TypeStringArray, Required: true, Description: "Paths to remove (the root directory is rejected)"},
+		},
+	},
+	"trim-rootfs": {
+		Name:        "trim-rootfs",
+		Description: "Remove documentation and the apk cache from a rootfs to slim the final image",
+		Parameters: map[string]ParamSpec{
+			"rootfs": {Type: TypeString, Required: false, Description: "Root filesystem to trim (default: /rootfs)"},
+			"remove": {Type: TypeStringArray, Required: false, Description: "What to remove: man, doc, info and/or apk-cache (default: man, doc and apk-cache)"},
+		},
+	},
+	"write-file": {
+		Name:        "write-file",
+		Description: "Write inline content to a file using a heredoc",
+		Parameters: map[string]ParamSpec{
+			"path":    {Type: TypeString, Required: true, Description: "Path of the file to write"},
+			"content": {Type: TypeString, Required: true, Description: "Content to write"},
+			"mode":    {Type: TypeString, Required: false, Description: "Permissions to set on the file after writing"},
+			"append":  {Type: TypeBool, Required: false, Description: "Append to the file instead of overwriting it (default: false)"},
+		},
+	},
+	"build-info": {
+		Name:        "build-info",
+		Description: "Write a JSON file describing the build (resolved versions, BOM hash and build time)",
+		Parameters: map[string]ParamSpec{
+			"path":   {Type: TypeString, Required: false, Description: "Path of the JSON file (default: /etc/build-info.json)"},
+			"fields": {Type: TypeStringArray, Required: false, Description: "Fields to include: versions, bom-hash and/or build-time (default: all)"},
+		},
+	},
 	"download-verify-extract": {
 		Name:        "download-verify-extract",
-		Description: "Download a file, verify its checksum, and optionally extract it",
+		Description: "Download a file, verify its checksum and optional GPG signature, and optionally extract it",
 		Parameters: map[string]ParamSpec{
-			"url":              {Type: TypeString, Required: true, Description: "URL to download"},
-			"destination":      {Type: TypeString, Required: true, Description: "Destination path for downloaded file"},
-			"checksum":         {Type: TypeString, Required: false, Description: "Expected SHA256 checksum"},
-			"checksum-url":     {Type: TypeString, Required: false, Description: "URL to fetch checksum from"},
-			"checksum-pattern": {Type: TypeString, Required: false, Description: "Pattern to extract checksum from checksum file"},
-			"extract-dir":      {Type: TypeString, Required: false, Description: "Directory to extract archive to"},
-			"strip-components": {Type: TypeInt, Required: false, Description: "Number of path components to strip during extraction"},
+			"url":                {Type: TypeString, Required: true, Description: "URL to download"},
+			"mirrors":            {Type: TypeStringArray, Required: false, Description: "Fallback URLs tried in order if the primary download fails"},
+			"destination":        {Type: TypeString, Required: true, Description: "Destination path for downloaded file"},
+			"checksum":           {Type: TypeString, Required: false, Description: "Expected checksum (see checksum-algorithm)"},
+			"checksums":          {Type: TypeStringArray, Required: false, Description: "Accepted checksums; verification passes if the file matches any of them"},
+			"checksum-url":       {Type: TypeString, Required: false, Description: "URL to fetch checksum from"},
+			"checksum-pattern":   {Type: TypeString, Required: false, Description: "Pattern to extract checksum from checksum file (default: match the destination's basename, e.g. in SHA256SUMS)"},
+			"checksum-algorithm": {Type: TypeString, Required: false, Description: "Checksum algorithm: sha256 (default, busybox sha256sum), sha512 (busybox sha512sum), sha1 (busybox sha1sum), blake2/b2sum (coreutils b2sum) or sha3-256 (openssl dgst)"},
+			"signature-url":      {Type: TypeString, Required: false, Description: "URL of a detached GPG signature to verify before extraction (requires gpg-key)"},
+			"gpg-key":            {Type: TypeString, Required: false, Description: "Signing key fingerprint (fetched from keyserver) or URL to the public key"},
+			"keyserver":          {Type: TypeString, Required: false, Description: "Keyserver to fetch a fingerprint gpg-key from, as hkp:// or hkps:// host (default: hkps://keys.openpgp.org)"},
+			"retries":            {Type: TypeInt, Required: false, Description: "Number of times curl retries a failed download (default: 0)"},
+			"extract-dir":        {Type: TypeString, Required: false, Description: "Directory to extract archive to"},
+			"strip-components":   {Type: TypeInt, Required: false, Description: "Number of path components to strip during extraction"},
 		},
-		MutuallyExclusive: [][]string{{"checksum", "checksum-url"}},
-		AtLeastOne:        [][]string{{"checksum", "checksum-url"}},
+		MutuallyExclusive: [][]string{{"checksum", "checksum-url", "checksums"}},
+		AtLeastOne:        [][]string{{"checksum", "checksum-url", "checksums"}},
 	},
 	"make-executable": {
 		Name:        "make-executable",
@@ -70,14 +127,31 @@ var Signatures = map[string]PipelineSignature{
 			"path": {Type: TypeString, Required: true, Description: "Path to make executable"},
 		},
 	},
+	"verify-static": {
+		Name:        "verify-static",
+		Description: "Fail the build if a binary has dynamic library dependencies",
+		Parameters: map[string]ParamSpec{
+			"path": {Type: TypeString, Required: true, Description: "Path to the binary to check"},
+		},
+	},
+	"validate-config-file": {
+		Name:        "validate-config-file",
+		Description: "Fail the build if a YAML or JSON config file does not parse",
+		Parameters: map[string]ParamSpec{
+			"path":   {Type: TypeString, Required: true, Description: "Path to the config file"},
+			"format": {Type: TypeString, Required: true, Description: "File format: yaml or json"},
+		},
+	},
 	"clone": {
 		Name:        "clone",
 		Description: "Clone a git repository",
 		Parameters: map[string]ParamSpec{
-			"repo":    {Type: TypeString, Required: true, Description: "Repository URL"},
-			"workdir": {Type: TypeString, Required: false, Description: "Working directory for clone (default: /src)"},
-			"tag":     {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
-			"commit":  {Type: TypeString, Required: false, Description: "Specific commit to checkout"},
+			"repo":         {Type: TypeString, Required: true, Description: "Repository URL"},
+			"workdir":      {Type: TypeString, Required: false, Description: "Working directory for clone (default: /src)"},
+			"tag":          {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
+			"commit":       {Type: TypeString, Required: false, Description: "Specific commit to checkout"},
+			"depth":        {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths": {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
 		},
 		MutuallyExclusive: [][]string{{"tag", "commit"}},
 	},
@@ -85,14 +159,22 @@ var Signatures = map[string]PipelineSignature{
 		Name:        "clone-and-build-go",
 		Description: "Clone a Go repository and build it",
 		Parameters: map[string]ParamSpec{
-			"repo":    {Type: TypeString, Required: true, Description: "Repository URL"},
-			"package": {Type: TypeString, Required: false, Description: "Go package to build (default: .)"},
-			"output":  {Type: TypeString, Required: false, Description: "Output binary path (default: /main)"},
-			"tag":     {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
-			"go-tags": {Type: TypeString, Required: false, Description: "Additional Go build tags (default: netgo,osusergo)"},
-			"cgo":     {Type: TypeBool, Required: false, Description: "Enable CGO (default: true)"},
-			"ignore":  {Type: TypeStringArray, Required: false, Description: "Packages to ignore for license generation"},
-			"patches": {Type: TypeStringArray, Required: false, Description: "Patch files to apply"},
+			"repo":          {Type: TypeString, Required: true, Description: "Repository URL"},
+			"package":       {Type: TypeString, Required: false, Description: "Go package to build (default: .)"},
+			"output":        {Type: TypeString, Required: false, Description: "Output binary path (default: /main)"},
+			"notices":       {Type: TypeString, Required: false, Description: "License notices directory (default: /notices followed by the output path)"},
+			"tag":           {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
+			"go-tags":       {Type: TypeString, Required: false, Description: "Additional Go build tags (default: netgo,osusergo)"},
+			"cgo":           {Type: TypeBool, Required: false, Description: "Enable CGO (default: true)"},
+			"ignore":        {Type: TypeStringArray, Required: false, Description: "Packages to ignore for license generation"},
+			"patches":       {Type: TypeStringArray, Required: false, Description: "Patch files to apply"},
+			"patch-strip":   {Type: TypeInt, Required: false, Description: "Leading path components to strip when applying patches, as in patch -pN (default: 1)"},
+			"patch-mode":    {Type: TypeString, Required: false, Description: "How patches are applied: patch (default) or git-apply"},
+			"build-cache":   {Type: TypeBool, Required: false, Description: "Mount the shared dfo-go-build cache so Go build stages reuse compiled packages (default: false)"},
+			"version-var":   {Type: TypeString, Required: false, Description: "Go variable to set to version-value via -X ldflags (e.g., main.Version)"},
+			"version-value": {Type: TypeString, Required: false, Description: "Value for version-var (e.g., %{versions.REPO_URL})"},
+			"depth":         {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths":  {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
 		},
 	},
 	"build-go-static": {
@@ -103,50 +185,72 @@ var Signatures = map[string]PipelineSignature{
 			"workdir":       {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
 			"package":       {Type: TypeString, Required: false, Description: "Go package to build (default: .)"},
 			"output":        {Type: TypeString, Required: false, Description: "Output binary path (default: /main)"},
+			"notices":       {Type: TypeString, Required: false, Description: "License notices directory (default: /notices followed by the output path)"},
 			"ignore":        {Type: TypeStringArray, Required: false, Description: "Packages to ignore for license generation"},
 			"tag":           {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
 			"go-tags":       {Type: TypeString, Required: false, Description: "Additional Go build tags (default: netgo,osusergo)"},
 			"go-experiment": {Type: TypeString, Required: false, Description: "GOEXPERIMENT value for experimental features"},
 			"cgo":           {Type: TypeBool, Required: false, Description: "Enable CGO (default: true)"},
 			"patches":       {Type: TypeStringArray, Required: false, Description: "Patch files to apply"},
+			"patch-strip":   {Type: TypeInt, Required: false, Description: "Leading path components to strip when applying patches, as in patch -pN (default: 1)"},
+			"patch-mode":    {Type: TypeString, Required: false, Description: "How patches are applied: patch (default) or git-apply"},
 			"packages":      {Type: TypeStringArray, Required: false, Description: "Additional Alpine packages to install"},
 			"go-generate":   {Type: TypeStringArray, Required: false, Description: "Paths to run go generate on (e.g., ./..., ./pkg/...)"},
 			"go-install":    {Type: TypeStringArray, Required: false, Description: "Go tools to install with versions (e.g., github.com/user/tool@v1.0.0)"},
+			"build-cache":   {Type: TypeBool, Required: false, Description: "Mount the shared dfo-go-build cache so Go build stages reuse compiled packages (default: false)"},
+			"version-var":   {Type: TypeString, Required: false, Description: "Go variable to set to version-value via -X ldflags (e.g., main.Version)"},
+			"version-value": {Type: TypeString, Required: false, Description: "Value for version-var (e.g., %{versions.REPO_URL})"},
+			"depth":         {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths":  {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
 		},
 	},
 	"build-go-only": {
 		Name:        "build-go-only",
 		Description: "Build a statically linked Go binary (without cloning - repo must already be cloned)",
 		Parameters: map[string]ParamSpec{
-			"workdir": {Type: TypeString, Required: true, Description: "Working directory where repo is already cloned"},
-			"package": {Type: TypeString, Required: false, Description: "Go package to build (default: .)"},
-			"output":  {Type: TypeString, Required: false, Description: "Output binary path (default: /main)"},
-			"ignore":  {Type: TypeStringArray, Required: false, Description: "Packages to ignore for license generation"},
-			"go-tags": {Type: TypeString, Required: false, Description: "Additional Go build tags (default: netgo,osusergo)"},
-			"cgo":     {Type: TypeBool, Required: false, Description: "Enable CGO (default: false)"},
+			"workdir":       {Type: TypeString, Required: true, Description: "Working directory where repo is already cloned"},
+			"package":       {Type: TypeString, Required: false, Description: "Go package to build (default: .)"},
+			"output":        {Type: TypeString, Required: false, Description: "Output binary path (default: /main)"},
+			"notices":       {Type: TypeString, Required: false, Description: "License notices directory (default: /notices followed by the output path)"},
+			"ignore":        {Type: TypeStringArray, Required: false, Description: "Packages to ignore for license generation"},
+			"go-tags":       {Type: TypeString, Required: false, Description: "Additional Go build tags (default: netgo,osusergo)"},
+			"cgo":           {Type: TypeBool, Required: false, Description: "Enable CGO (default: false)"},
+			"build-cache":   {Type: TypeBool, Required: false, Description: "Mount the shared dfo-go-build cache so Go build stages reuse compiled packages (default: false)"},
+			"version-var":   {Type: TypeString, Required: false, Description: "Go variable to set to version-value via -X ldflags (e.g., main.Version)"},
+			"version-value": {Type: TypeString, Required: false, Description: "Value for version-var (e.g., %{versions.REPO_URL})"},
 		},
 	},
 	"clone-and-build-rust": {
 		Name:        "clone-and-build-rust",
 		Description: "Clone a Rust repository and build it",
 		Parameters: map[string]ParamSpec{
-			"repo":     {Type: TypeString, Required: true, Description: "Repository URL"},
-			"workdir":  {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
-			"features": {Type: TypeString, Required: false, Description: "Cargo features to enable"},
-			"output":   {Type: TypeString, Required: false, Description: "Output binary path (default: /main)"},
-			"tag":      {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
-			"patches":  {Type: TypeStringArray, Required: false, Description: "Patch files to apply"},
+			"repo":         {Type: TypeString, Required: true, Description: "Repository URL"},
+			"workdir":      {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
+			"features":     {Type: TypeString, Required: false, Description: "Cargo features to enable"},
+			"target":       {Type: TypeString, Required: false, Description: "Rust target triple (default: x86_64-unknown-linux-musl)"},
+			"output":       {Type: TypeString, Required: false, Description: "Output binary path (default: /main)"},
+			"tag":          {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
+			"patches":      {Type: TypeStringArray, Required: false, Description: "Patch files to apply"},
+			"patch-strip":  {Type: TypeInt, Required: false, Description: "Leading path components to strip when applying patches, as in patch -pN (default: 1)"},
+			"patch-mode":   {Type: TypeString, Required: false, Description: "How patches are applied: patch (default) or git-apply"},
+			"depth":        {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths": {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
 		},
 	},
 	"clone-and-build-make": {
 		Name:        "clone-and-build-make",
 		Description: "Clone a repository and build with make",
 		Parameters: map[string]ParamSpec{
-			"repo":       {Type: TypeString, Required: true, Description: "Repository URL"},
-			"workdir":    {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
-			"tag":        {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
-			"make-steps": {Type: TypeStringArray, Required: false, Description: "Make commands to run"},
-			"strip":      {Type: TypeBool, Required: false, Description: "Strip binaries after build (default: true)"},
+			"repo":             {Type: TypeString, Required: true, Description: "Repository URL"},
+			"workdir":          {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
+			"tag":              {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
+			"make-steps":       {Type: TypeStringArray, Required: false, Description: "Make commands to run (default: make -jN and make install)"},
+			"jobs":             {Type: TypeInt, Required: false, Description: "Parallel make jobs when make-steps is not set (default: 0 for $(nproc))"},
+			"strip":            {Type: TypeBool, Required: false, Description: "Strip binaries after build (default: true)"},
+			"strip-mode":       {Type: TypeString, Required: false, Description: "Symbols to strip: all (default), debug or unneeded"},
+			"runtime-packages": {Type: TypeStringArray, Required: false, Description: "Packages kept installed after the build for runtime use"},
+			"depth":            {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths":     {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
 		},
 	},
 	"clone-and-build-autoconf": {
@@ -156,33 +260,157 @@ var Signatures = map[string]PipelineSignature{
 			"repo":              {Type: TypeString, Required: true, Description: "Repository URL"},
 			"workdir":           {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
 			"tag":               {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
+			"autogen":           {Type: TypeBool, Required: false, Description: "Generate the configure script before configuring (default: false)"},
+			"autogen-command":   {Type: TypeString, Required: false, Description: "Command used to generate the configure script; implies autogen (default: ./autogen.sh)"},
 			"configure-options": {Type: TypeStringArray, Required: false, Description: "Options to pass to configure"},
-			"make-steps":        {Type: TypeStringArray, Required: false, Description: "Make commands to run"},
+			"make-steps":        {Type: TypeStringArray, Required: false, Description: "Make commands to run (default: make -jN and make install)"},
+			"jobs":              {Type: TypeInt, Required: false, Description: "Parallel make jobs when make-steps is not set (default: 0 for $(nproc))"},
 			"strip":             {Type: TypeBool, Required: false, Description: "Strip binaries after build (default: true)"},
+			"strip-mode":        {Type: TypeString, Required: false, Description: "Symbols to strip: all (default), debug or unneeded"},
+			"runtime-packages":  {Type: TypeStringArray, Required: false, Description: "Packages kept installed after the build for runtime use"},
+			"depth":             {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths":      {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
+		},
+	},
+	"clone-and-build-node": {
+		Name:        "clone-and-build-node",
+		Description: "Clone a Node.js repository, install dependencies and build it",
+		Parameters: map[string]ParamSpec{
+			"repo":            {Type: TypeString, Required: true, Description: "Repository URL"},
+			"workdir":         {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
+			"tag":             {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
+			"package-manager": {Type: TypeString, Required: false, Description: "Package manager: npm (default), yarn or pnpm"},
+			"install-command": {Type: TypeString, Required: false, Description: "Command to install dependencies (default depends on package-manager)"},
+			"build-command":   {Type: TypeString, Required: false, Description: "Command to build the project (default depends on package-manager)"},
+			"dist":            {Type: TypeString, Required: false, Description: "Build output directory relative to workdir (default: dist)"},
+			"output":          {Type: TypeString, Required: true, Description: "Directory to copy the build output to"},
+			"depth":           {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths":    {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
+		},
+	},
+	"clone-and-build-python": {
+		Name:        "clone-and-build-python",
+		Description: "Clone a Python repository and install it into a virtualenv",
+		Parameters: map[string]ParamSpec{
+			"repo":           {Type: TypeString, Required: true, Description: "Repository URL"},
+			"workdir":        {Type: TypeString, Required: false, Description: "Working directory (default: /src)"},
+			"tag":            {Type: TypeString, Required: false, Description: "Tag or branch to checkout"},
+			"venv":           {Type: TypeString, Required: false, Description: "Virtualenv path (default: /opt/venv)"},
+			"requirements":   {Type: TypeString, Required: false, Description: "Requirements file relative to workdir (default: requirements.txt)"},
+			"extras":         {Type: TypeStringArray, Required: false, Description: "Optional extras to install with the project"},
+			"python-version": {Type: TypeString, Required: false, Description: "Python interpreter version used to create the virtualenv (default: 3)"},
+			"depth":          {Type: TypeInt, Required: false, Description: "Clone depth (default: 1, 0 for a full clone)"},
+			"sparse-paths":   {Type: TypeStringArray, Required: false, Description: "Paths to check out with git sparse-checkout (default: everything)"},
 		},
 	},
 	"setup-users-groups": {
 		Name:        "setup-users-groups",
 		Description: "Set up users and groups in a rootfs",
 		Parameters: map[string]ParamSpec{
-			"rootfs": {Type: TypeString, Required: false, Description: "Root filesystem path"},
-			"groups": {Type: TypeObjectArray, Required: false, Description: "Groups to create (name, gid)"},
-			"users":  {Type: TypeObjectArray, Required: false, Description: "Users to create (username, uid, gid, home, shell)"},
+			"rootfs":        {Type: TypeString, Required: false, Description: "Root filesystem path"},
+			"groups":        {Type: TypeObjectArray, Required: false, Description: "Groups to create (name, gid, members)"},
+			"users":         {Type: TypeObjectArray, Required: false, Description: "Users to create (username, uid, gid, home, shell)"},
+			"skip-existing": {Type: TypeBool, Required: false, Description: "Skip users and groups already present in passwd/group (default: false)"},
 		},
 		AtLeastOne: [][]string{{"groups", "users"}},
 	},
 	"create-directories": {
 		Name:        "create-directories",
-		Description: "Create directories with optional permissions",
+		Description: "Create directories with optional permissions and ownership",
+		Parameters: map[string]ParamSpec{
+			"directories": {Type: TypeObjectArray, Required: true, Description: "Directories to create (path, owner, permissions)"},
+		},
+	},
+	"harden-writable-dirs": {
+		Name:        "harden-writable-dirs",
+		Description: "Create world-writable directories such as /tmp with the sticky bit set, optionally in a rootfs",
+		Parameters: map[string]ParamSpec{
+			"directories": {Type: TypeObjectArray, Required: false, Description: "Directories to create (path, mode; default: /tmp, /var/tmp and /run with mode 1777)"},
+			"rootfs":      {Type: TypeString, Required: false, Description: "Root filesystem prefix for the directories"},
+		},
+	},
+	"set-file-attributes": {
+		Name:        "set-file-attributes",
+		Description: "Set file attributes with chattr (e.g. +i); only supported on ext2/3/4 filesystems, so it may fail on overlay or tmpfs build storage",
 		Parameters: map[string]ParamSpec{
-			"directories": {Type: TypeObjectArray, Required: true, Description: "Directories to create (path, permissions)"},
+			"files": {Type: TypeObjectArray, Required: true, Description: "Files to update (path, attributes)"},
 		},
+		RejectUnknown: true,
 	},
 	"copy-files": {
 		Name:        "copy-files",
 		Description: "Copy files into the container",
 		Parameters: map[string]ParamSpec{
-			"files": {Type: TypeObjectArray, Required: true, Description: "Files to copy (from, to, chown, chmod)"},
+			"files":            {Type: TypeObjectArray, Required: true, Description: "Files to copy (from, to, chown, chmod, link)"},
+			"preserve-parents": {Type: TypeBool, Required: false, Description: "Keep the source directory structure under the destination (uses a RUN with a build context bind mount and cp --parents, as COPY cannot; requires BuildKit or buildah and cannot be combined with link)"},
+		},
+	},
+	"install-github-release": {
+		Name:        "install-github-release",
+		Description: "Install a binary from a GitHub release asset, verified against the release checksums",
+		Parameters: map[string]ParamSpec{
+			"repo":             {Type: TypeString, Required: true, Description: "GitHub repository (owner/repo or URL)"},
+			"tag":              {Type: TypeString, Required: false, Description: "Release tag (default: latest tag, resolved when generating; use %{versions.REPO_URL} to pin)"},
+			"asset-pattern":    {Type: TypeString, Required: true, Description: "Glob matching the release asset name (e.g., *_linux_amd64.tar.gz)"},
+			"checksum-pattern": {Type: TypeString, Required: false, Description: "Glob matching the release checksums asset (default: *checksums.txt)"},
+			"binary":           {Type: TypeString, Required: true, Description: "Path of the binary inside the archive"},
+			"destination":      {Type: TypeString, Required: true, Description: "Path to install the binary to"},
+		},
+	},
+	"run-script": {
+		Name:        "run-script",
+		Description: "Copy a script from the build context, run it, and remove it",
+		Parameters: map[string]ParamSpec{
+			"script":     {Type: TypeString, Required: true, Description: "Path to the script in the build context"},
+			"args":       {Type: TypeStringArray, Required: false, Description: "Arguments to pass to the script"},
+			"build-deps": {Type: TypeStringArray, Required: false, Description: "Packages needed while the script runs"},
+		},
+	},
+	"install-toolchain": {
+		Name:        "install-toolchain",
+		Description: "Download a verified toolchain tarball, extract it to a prefix and add it to PATH",
+		Parameters: map[string]ParamSpec{
+			"url":              {Type: TypeString, Required: true, Description: "URL of the toolchain tarball"},
+			"checksum":         {Type: TypeString, Required: true, Description: "Expected SHA256 checksum of the tarball"},
+			"prefix":           {Type: TypeString, Required: false, Description: "Installation prefix (default: /opt/toolchain)"},
+			"strip-components": {Type: TypeInt, Required: false, Description: "Number of leading path components to strip (default: 1)"},
+		},
+	},
+	"run-migrations": {
+		Name:        "run-migrations",
+		Description: "Run a migration command to build a database file and copy it into the rootfs",
+		Parameters: map[string]ParamSpec{
+			"command":    {Type: TypeString, Required: true, Description: "Migration command that creates or updates output-db"},
+			"output-db":  {Type: TypeString, Required: true, Description: "Path of the database file written by the command"},
+			"workdir":    {Type: TypeString, Required: false, Description: "Directory to run the command in"},
+			"rootfs":     {Type: TypeString, Required: false, Description: "Root filesystem to copy the database into (default: /rootfs)"},
+			"owner":      {Type: TypeString, Required: false, Description: "Owner (user:group) of the copied database"},
+			"build-deps": {Type: TypeStringArray, Required: false, Description: "Packages needed while the migrations run"},
+		},
+	},
+	"write-sysctls": {
+		Name:        "write-sysctls",
+		Description: "Write recommended kernel tunables to a sysctl.d fragment in the rootfs; they must still be applied at runtime",
+		Parameters: map[string]ParamSpec{
+			"tunables": {Type: TypeStringMap, Required: true, Description: "Map of sysctl keys to values"},
+			"path":     {Type: TypeString, Required: false, Description: "Path of the fragment within the rootfs (default: /etc/sysctl.d/99-recommended.conf)"},
+			"rootfs":   {Type: TypeString, Required: false, Description: "Root filesystem to write the fragment into (default: /rootfs)"},
+		},
+	},
+	"setup-nsswitch": {
+		Name:        "setup-nsswitch",
+		Description: "Write /etc/nsswitch.conf so static binaries using the Go or musl resolver consult /etc/hosts and DNS",
+		Parameters: map[string]ParamSpec{
+			"root":  {Type: TypeString, Required: false, Description: "Root directory to write etc/nsswitch.conf into (default: /)"},
+			"hosts": {Type: TypeString, Required: false, Description: "Sources for the hosts database (default: files dns)"},
+		},
+	},
+	"apk-world": {
+		Name:        "apk-world",
+		Description: "Write /etc/apk/world with an explicit set of packages",
+		Parameters: map[string]ParamSpec{
+			"packages": {Type: TypeStringArray, Required: true, Description: "Packages to record in the world file"},
+			"root":     {Type: TypeString, Required: false, Description: "Root directory containing etc/apk (default: /)"},
 		},
 	},
 }
@@ -203,6 +431,9 @@ func ValidateSignature(sig PipelineSignature, params map[string]any) error {
 	var errors []string
 
 	errors = append(errors, validateRequiredParams(sig, params)...)
+	if sig.RejectUnknown {
+		errors = append(errors, validateKnownParams(sig, params)...)
+	}
 	errors = append(errors, validateParamTypes(sig, params)...)
 	errors = append(errors, validateMutuallyExclusive(sig.MutuallyExclusive, params)...)
 	errors = append(errors, validateAtLeastOne(sig.AtLeastOne, params)...)
@@ -215,8 +446,8 @@ func ValidateSignature(sig PipelineSignature, params map[string]any) error {
 
 func validateRequiredParams(sig PipelineSignature, params map[string]any) []string {
 	var errors []string
-	for paramName, spec := range sig.Parameters {
-		if spec.Required {
+	for _, paramName := range util.SortedKeys(sig.Parameters) {
+		if sig.Parameters[paramName].Required {
 			val, exists := params[paramName]
 			if !exists || val == nil {
 				errors = append(errors, fmt.Sprintf("required parameter %q is missing", paramName))
@@ -226,11 +457,21 @@ func validateRequiredParams(sig PipelineSignature, params map[string]any) []stri
 	return errors
 }
 
+func validateKnownParams(sig PipelineSignature, params map[string]any) []string {
+	var errors []string
+	for _, paramName := range util.SortedKeys(params) {
+		if _, ok := sig.Parameters[paramName]; !ok {
+			errors = append(errors, fmt.Sprintf("unknown parameter %q", paramName))
+		}
+	}
+	return errors
+}
+
 func validateParamTypes(sig PipelineSignature, params map[string]any) []string {
 	var errors []string
-	for paramName, spec := range sig.Parameters {
+	for _, paramName := range util.SortedKeys(sig.Parameters) {
 		if val, exists := params[paramName]; exists && val != nil {
-			if err := CheckType(paramName, val, spec.Type); err != nil {
+			if err := CheckType(paramName, val, sig.Parameters[paramName].Type); err != nil {
 				errors = append(errors, err.Error())
 			}
 		}
@@ -294,6 +535,8 @@ func CheckType(paramName string, value any, expectedType ParamType) error {
 		return checkStringArrayType(paramName, value)
 	case TypeObjectArray:
 		return checkObjectArrayType(paramName, value)
+	case TypeStringMap:
+		return checkStringMapType(paramName, value)
 	}
 	return nil
 }
@@ -354,3 +597,18 @@ func checkObjectArrayType(paramName string, value any) error {
 	}
 	return nil
 }
+
+func checkStringMapType(paramName string, value any) error {
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range util.SortedKeys(v) {
+			if _, ok := v[key].(string); !ok {
+				return fmt.Errorf("parameter %q[%q] must be a string, got %T", paramName, key, v[key])
+			}
+		}
+	case map[string]string:
+	default:
+		return fmt.Errorf("parameter %q must be a map of strings, got %T", paramName, value)
+	}
+	return nil
+}
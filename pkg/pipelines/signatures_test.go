@@ -506,6 +506,27 @@ func TestCheckType(t *testing.T) {
 			expectedType: TypeObjectArray,
 			expectError:  false,
 		},
+		{
+			name:         "valid string map",
+			paramName:    "test",
+			value:        map[string]any{"key": "value"},
+			expectedType: TypeStringMap,
+			expectError:  false,
+		},
+		{
+			name:         "string map with non-string value",
+			paramName:    "test",
+			value:        map[string]any{"key": 1},
+			expectedType: TypeStringMap,
+			expectError:  true,
+		},
+		{
+			name:         "invalid string map",
+			paramName:    "test",
+			value:        []string{"key=value"},
+			expectedType: TypeStringMap,
+			expectError:  true,
+		},
 		{
 			name:         "unknown type",
 			paramName:    "test",
@@ -527,6 +548,25 @@ func TestCheckType(t *testing.T) {
 	}
 }
 
+func TestValidateSignatureRejectUnknown(t *testing.T) {
+	sig := PipelineSignature{
+		Name: "test-pipeline",
+		Parameters: map[string]ParamSpec{
+			"path": {Type: TypeString, Required: true},
+		},
+	}
+	params := map[string]any{"path": "/app", "pth": "/other"}
+
+	if err := ValidateSignature(sig, params); err != nil {
+		t.Errorf("ValidateSignature() error = %v, want unknown params allowed", err)
+	}
+
+	sig.RejectUnknown = true
+	if err := ValidateSignature(sig, params); err == nil || !strings.Contains(err.Error(), `unknown parameter "pth"`) {
+		t.Errorf("ValidateSignature() error = %v, want unknown parameter error", err)
+	}
+}
+
 func TestValidateSignature(t *testing.T) {
 	sig := PipelineSignature{
 		Name: "test-pipeline",
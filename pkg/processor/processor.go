@@ -15,6 +15,29 @@ import (
 
 type ProcessResult struct {
 	PackageName string
+	Stats       generator.Stats
+}
+
+// ProcessOptions holds the generation settings that are exposed as command
+// line flags.
+type ProcessOptions struct {
+	ShellOptions     bool
+	Strict           bool
+	DigestOnly       bool
+	ComputeChecksums bool
+	Attestation      bool
+	OnlyStage        string
+	Syntax           string
+}
+
+func (o ProcessOptions) apply(gen *generator.Generator) {
+	gen.SetShellOptions(o.ShellOptions)
+	gen.SetStrict(o.Strict)
+	gen.SetDigestOnly(o.DigestOnly)
+	gen.SetComputeChecksums(o.ComputeChecksums)
+	gen.SetAttestation(o.Attestation)
+	gen.SetOnlyStage(o.OnlyStage)
+	gen.SetSyntaxDirective(o.Syntax)
 }
 
 type WritableFS = util.WritableFS
@@ -23,7 +46,7 @@ type WalkableFS = util.WalkableFS
 
 type StatFS = fs.StatFS
 
-func ProcessConfig(fs util.WritableFS, configPath, outputDir string, alpineClient *packages.AlpineClient, alpineVersion, gitUser, gitPass, registry string, imageResolver *images.Resolver) (*ProcessResult, error) {
+func ProcessConfig(fs util.WritableFS, configPath, outputDir string, alpineClient *packages.AlpineClient, alpineVersion, gitUser, gitPass, registry string, imageResolver *images.Resolver, opts ProcessOptions) (*ProcessResult, error) {
 	slog.Debug("processing config",
 		"config_path", configPath,
 		"output_dir", outputDir,
@@ -40,16 +63,17 @@ func ProcessConfig(fs util.WritableFS, configPath, outputDir string, alpineClien
 	packageDir := path.Join(outputDir, cfg.Package.Name)
 
 	gen := generator.New(cfg, packageDir, fs, alpineClient, alpineVersion, gitUser, gitPass, registry, imageResolver)
+	opts.apply(gen)
 	if err := gen.Generate(); err != nil {
 		return nil, fmt.Errorf("generating templates: %w", err)
 	}
 
 	slog.Debug("generated templates", "package_name", cfg.Package.Name)
 
-	return &ProcessResult{PackageName: cfg.Package.Name}, nil
+	return &ProcessResult{PackageName: cfg.Package.Name, Stats: gen.Stats()}, nil
 }
 
-func ProcessConfigInPlace(fs util.WritableFS, configPath string, alpineClient *packages.AlpineClient, alpineVersion, gitUser, gitPass, registry string, imageResolver *images.Resolver, localImageNames []string) (*ProcessResult, error) {
+func ProcessConfigInPlace(fs util.WritableFS, configPath string, alpineClient *packages.AlpineClient, alpineVersion, gitUser, gitPass, registry string, imageResolver *images.Resolver, localImageNames []string, opts ProcessOptions) (*ProcessResult, error) {
 	cfg, err := config.Load(fs, configPath)
 	if err != nil {
 		return nil, fmt.Errorf("loading config: %w", err)
@@ -59,14 +83,15 @@ func ProcessConfigInPlace(fs util.WritableFS, configPath string, alpineClient *p
 
 	gen := generator.New(cfg, outputDir, fs, alpineClient, alpineVersion, gitUser, gitPass, registry, imageResolver)
 	gen.SetLocalImageNames(localImageNames)
+	opts.apply(gen)
 	if err := gen.Generate(); err != nil {
 		return nil, fmt.Errorf("generating templates: %w", err)
 	}
 
-	return &ProcessResult{PackageName: cfg.Package.Name}, nil
+	return &ProcessResult{PackageName: cfg.Package.Name, Stats: gen.Stats()}, nil
 }
 
-func ProcessConfigWithBuiltImages(fs util.WritableFS, configPath, outputDir string, alpineClient *packages.AlpineClient, alpineVersion, gitUser, gitPass, registry string, imageResolver *images.Resolver, builtImages map[string]string, localImageNames []string) (*ProcessResult, error) {
+func ProcessConfigWithBuiltImages(fs util.WritableFS, configPath, outputDir string, alpineClient *packages.AlpineClient, alpineVersion, gitUser, gitPass, registry string, imageResolver *images.Resolver, builtImages map[string]string, localImageNames []string, opts ProcessOptions) (*ProcessResult, error) {
 	slog.Debug("processing config with built images",
 		"config_path", configPath,
 		"output_dir", outputDir,
@@ -92,11 +117,33 @@ func ProcessConfigWithBuiltImages(fs util.WritableFS, configPath, outputDir stri
 	if localImageNames != nil {
 		gen.SetLocalImageNames(localImageNames)
 	}
+	opts.apply(gen)
 	if err := gen.Generate(); err != nil {
 		return nil, fmt.Errorf("generating templates: %w", err)
 	}
 
 	slog.Debug("generated templates", "package_name", cfg.Package.Name)
 
-	return &ProcessResult{PackageName: cfg.Package.Name}, nil
+	return &ProcessResult{PackageName: cfg.Package.Name, Stats: gen.Stats()}, nil
+}
+
+func ResolvePackages(fs util.WritableFS, configPath string, alpineClient *packages.AlpineClient, alpineVersion string) ([]generator.PackageResolution, error) {
+	cfg, err := config.Load(fs, configPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+
+	gen := generator.New(cfg, path.Dir(configPath), fs, alpineClient, alpineVersion, "", "", "", nil)
+	return gen.ResolvePackageSpecs()
+}
+
+func PlanConfig(fs util.WritableFS, configPath, onlyStage string) (string, error) {
+	cfg, err := config.Load(fs, configPath)
+	if err != nil {
+		return "", fmt.Errorf("loading config: %w", err)
+	}
+
+	gen := generator.New(cfg, path.Dir(configPath), fs, nil, "", "", "", "", nil)
+	gen.SetOnlyStage(onlyStage)
+	return gen.Plan()
 }
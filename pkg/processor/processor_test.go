@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greboid/dfo/pkg/util"
+)
+
+const multiLineRunConfig = `package:
+  name: app
+stages:
+  - name: final
+    environment:
+      external-image: alpine
+    pipeline:
+      - run: |
+          make
+          make install
+`
+
+func writeConfig(t *testing.T, config string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "dfo.yaml")
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	return dir, configPath
+}
+
+func readContainerfile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading Containerfile: %v", err)
+	}
+	return string(data)
+}
+
+func TestProcessOptionsAppliedOnEveryPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		process func(configPath, dir string, opts ProcessOptions) (string, error)
+	}{
+		{
+			name: "ProcessConfig",
+			process: func(configPath, dir string, opts ProcessOptions) (string, error) {
+				_, err := ProcessConfig(util.OSFS{}, configPath, dir, nil, "3.20", "", "", "", nil, opts)
+				return filepath.Join(dir, "app", "Containerfile"), err
+			},
+		},
+		{
+			name: "ProcessConfigInPlace",
+			process: func(configPath, dir string, opts ProcessOptions) (string, error) {
+				_, err := ProcessConfigInPlace(util.OSFS{}, configPath, nil, "3.20", "", "", "", nil, nil, opts)
+				return filepath.Join(dir, "Containerfile"), err
+			},
+		},
+		{
+			name: "ProcessConfigWithBuiltImages",
+			process: func(configPath, dir string, opts ProcessOptions) (string, error) {
+				_, err := ProcessConfigWithBuiltImages(util.OSFS{}, configPath, dir, nil, "3.20", "", "", "", nil, nil, nil, opts)
+				return filepath.Join(dir, "Containerfile"), err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, shellOptions := range []bool{false, true} {
+				dir, configPath := writeConfig(t, multiLineRunConfig)
+				containerfile, err := tt.process(configPath, dir, ProcessOptions{ShellOptions: shellOptions})
+				if err != nil {
+					t.Fatalf("%s() error = %v", tt.name, err)
+				}
+
+				content := readContainerfile(t, containerfile)
+				if got := strings.Contains(content, "RUN set -eux; \\\n"); got != shellOptions {
+					t.Errorf("ShellOptions = %v, set -eux emitted = %v:\n%s", shellOptions, got, content)
+				}
+			}
+		})
+	}
+}
+
+const relativeWorkdirConfig = `package:
+  name: app
+stages:
+  - name: final
+    environment:
+      external-image: alpine
+      workdir: app
+    pipeline:
+      - run: make
+`
+
+func TestProcessConfigWithBuiltImagesStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		wantErr string
+	}{
+		{name: "warnings allowed"},
+		{name: "strict", strict: true, wantErr: `workdir "app" is not an absolute path`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, configPath := writeConfig(t, relativeWorkdirConfig)
+			_, err := ProcessConfigWithBuiltImages(util.OSFS{}, configPath, dir, nil, "3.20", "", "", "", nil, nil, nil, ProcessOptions{Strict: tt.strict})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ProcessConfigWithBuiltImages() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ProcessConfigWithBuiltImages() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+const builtBaseConfig = `package:
+  name: app
+stages:
+  - name: final
+    environment:
+      base-image: base:3.20
+    pipeline:
+      - run: make
+`
+
+func TestProcessConfigWithBuiltImagesDigestOnly(t *testing.T) {
+	builtImages := map[string]string{"base:3.20": "sha256:0123456789abcdef"}
+
+	tests := []struct {
+		name       string
+		digestOnly bool
+		expected   string
+	}{
+		{name: "tagged", expected: "FROM base:3.20@sha256:0123456789abcdef\n"},
+		{name: "digest only", digestOnly: true, expected: "FROM base@sha256:0123456789abcdef\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, configPath := writeConfig(t, builtBaseConfig)
+			if _, err := ProcessConfigWithBuiltImages(util.OSFS{}, configPath, dir, nil, "3.20", "", "", "", nil, builtImages, nil, ProcessOptions{DigestOnly: tt.digestOnly}); err != nil {
+				t.Fatalf("ProcessConfigWithBuiltImages() error = %v", err)
+			}
+
+			if content := readContainerfile(t, filepath.Join(dir, "Containerfile")); !strings.Contains(content, tt.expected) {
+				t.Errorf("Containerfile missing %q:\n%s", tt.expected, content)
+			}
+		})
+	}
+}
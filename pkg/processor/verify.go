@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/greboid/dfo/pkg/config"
+)
+
+type DigestLookup func(ctx context.Context, image string) (string, error)
+
+type DigestCheck struct {
+	Stage   string
+	Image   string
+	Pinned  string
+	Current string
+	// Skipped explains why the stage's image could not be checked.
+	Skipped string
+}
+
+func (c DigestCheck) Drifted() bool {
+	return c.Skipped == "" && c.Pinned != c.Current
+}
+
+func VerifyPinnedDigests(ctx context.Context, cfg *config.BuildConfig, containerfile string, lookup DigestLookup) ([]DigestCheck, error) {
+	refs := parseFromRefs(containerfile)
+
+	var checks []DigestCheck
+	for i, stage := range cfg.Stages {
+		ref, ok := refs[stage.Name]
+		if !ok && i == len(cfg.Stages)-1 {
+			// The final stage is rendered without an AS name.
+			ref, ok = refs[""]
+		}
+		if !ok {
+			return nil, fmt.Errorf("stage %q is missing from the generated file; regenerate it first", stage.Name)
+		}
+
+		image := stage.Environment.BaseImage
+		if external := stage.Environment.ExternalImage; external != "" {
+			// External images are emitted as written, so any digest was pinned in
+			// the config and is checked against the tag it was given with.
+			image, _, _ = strings.Cut(external, "@")
+		}
+
+		check := DigestCheck{Stage: stage.Name, Image: image}
+		_, pinned, ok := strings.Cut(ref, "@")
+		switch {
+		case image == "scratch":
+			check.Skipped = "scratch has no digest"
+		case !ok:
+			check.Skipped = "not pinned by digest"
+		default:
+			current, err := lookup(ctx, image)
+			if err != nil {
+				return nil, fmt.Errorf("resolving %s for stage %q: %w", image, stage.Name, err)
+			}
+			check.Pinned = pinned
+			check.Current = current
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// parseFromRefs maps each FROM line's stage name to its image reference. A FROM
+// without an AS name is stored under the empty key.
+func parseFromRefs(content string) map[string]string {
+	refs := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		var name string
+		if len(fields) >= 4 && strings.EqualFold(fields[2], "AS") {
+			name = fields[3]
+		}
+		refs[name] = fields[1]
+	}
+	return refs
+}
@@ -0,0 +1,200 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/greboid/dfo/pkg/config"
+)
+
+const verifyConfig = `package:
+  name: app
+stages:
+  - name: build
+    environment:
+      base-image: golang
+    pipeline:
+      - run: make
+  - name: final
+    environment:
+      base-image: base
+      cmd: ["/app"]
+`
+
+const verifyContainerfile = `# BOM: {}
+
+FROM registry.example.com/golang@sha256:aaa AS build
+
+RUN make
+
+FROM registry.example.com/base@sha256:bbb
+
+CMD ["/app"]
+`
+
+func loadVerifyConfig(t *testing.T) *config.BuildConfig {
+	t.Helper()
+	cfg, err := config.Parse([]byte(verifyConfig))
+	if err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+	return cfg
+}
+
+func TestVerifyPinnedDigests(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     map[string]string
+		wantDrifted []string
+	}{
+		{
+			name:    "matching digests",
+			current: map[string]string{"golang": "sha256:aaa", "base": "sha256:bbb"},
+		},
+		{
+			name:        "drifted digest",
+			current:     map[string]string{"golang": "sha256:aaa", "base": "sha256:ccc"},
+			wantDrifted: []string{"final"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks, err := VerifyPinnedDigests(context.Background(), loadVerifyConfig(t), verifyContainerfile, func(_ context.Context, image string) (string, error) {
+				return tt.current[image], nil
+			})
+			if err != nil {
+				t.Fatalf("VerifyPinnedDigests() error = %v", err)
+			}
+			if len(checks) != 2 {
+				t.Fatalf("got %d checks, want 2", len(checks))
+			}
+
+			var drifted []string
+			for _, check := range checks {
+				if check.Drifted() {
+					drifted = append(drifted, check.Stage)
+				}
+			}
+			if len(drifted) != len(tt.wantDrifted) {
+				t.Fatalf("drifted stages = %v, want %v", drifted, tt.wantDrifted)
+			}
+			for i := range drifted {
+				if drifted[i] != tt.wantDrifted[i] {
+					t.Errorf("drifted stages = %v, want %v", drifted, tt.wantDrifted)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyPinnedDigestsStageMismatch(t *testing.T) {
+	_, err := VerifyPinnedDigests(context.Background(), loadVerifyConfig(t), "FROM registry.example.com/golang@sha256:aaa AS build\n", func(context.Context, string) (string, error) {
+		return "sha256:aaa", nil
+	})
+	if err == nil {
+		t.Error("VerifyPinnedDigests() expected error for stage count mismatch")
+	}
+}
+
+func TestVerifyPinnedDigestsMatchesStagesByName(t *testing.T) {
+	const reordered = `FROM registry.example.com/base@sha256:bbb
+
+FROM registry.example.com/golang@sha256:aaa AS build
+`
+	checks, err := VerifyPinnedDigests(context.Background(), loadVerifyConfig(t), reordered, func(_ context.Context, image string) (string, error) {
+		return map[string]string{"golang": "sha256:aaa", "base": "sha256:bbb"}[image], nil
+	})
+	if err != nil {
+		t.Fatalf("VerifyPinnedDigests() error = %v", err)
+	}
+	for _, check := range checks {
+		if check.Drifted() {
+			t.Errorf("stage %q paired with the wrong FROM line: pinned %s, current %s", check.Stage, check.Pinned, check.Current)
+		}
+	}
+}
+
+const verifyExternalConfig = `package:
+  name: app
+stages:
+  - name: tools
+    environment:
+      external-image: alpine:3.20@sha256:ddd
+    pipeline:
+      - run: make
+  - name: final
+    environment:
+      external-image: scratch
+      cmd: ["/app"]
+`
+
+const verifyExternalContainerfile = `FROM alpine:3.20@sha256:ddd AS tools
+
+RUN make
+
+FROM scratch
+
+CMD ["/app"]
+`
+
+func TestVerifyPinnedDigestsExternalImages(t *testing.T) {
+	cfg, err := config.Parse([]byte(verifyExternalConfig))
+	if err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		current     string
+		wantDrifted bool
+	}{
+		{name: "matching digest", current: "sha256:ddd"},
+		{name: "drifted digest", current: "sha256:eee", wantDrifted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var looked []string
+			checks, err := VerifyPinnedDigests(context.Background(), cfg, verifyExternalContainerfile, func(_ context.Context, image string) (string, error) {
+				looked = append(looked, image)
+				return tt.current, nil
+			})
+			if err != nil {
+				t.Fatalf("VerifyPinnedDigests() error = %v", err)
+			}
+			if len(looked) != 1 || looked[0] != "alpine:3.20" {
+				t.Errorf("looked up %v, want [alpine:3.20]", looked)
+			}
+			if len(checks) != 2 {
+				t.Fatalf("got %d checks, want 2", len(checks))
+			}
+
+			if checks[0].Drifted() != tt.wantDrifted {
+				t.Errorf("tools drifted = %v, want %v (pinned %s, current %s)", checks[0].Drifted(), tt.wantDrifted, checks[0].Pinned, checks[0].Current)
+			}
+			if checks[1].Skipped == "" {
+				t.Errorf("scratch stage was not reported as skipped: %+v", checks[1])
+			}
+		})
+	}
+}
+
+func TestVerifyPinnedDigestsSkipsUnpinnedExternalImage(t *testing.T) {
+	cfg, err := config.Parse([]byte(strings.Replace(verifyExternalConfig, "alpine:3.20@sha256:ddd", "alpine:3.20", 1)))
+	if err != nil {
+		t.Fatalf("parsing config: %v", err)
+	}
+
+	checks, err := VerifyPinnedDigests(context.Background(), cfg, strings.Replace(verifyExternalContainerfile, "alpine:3.20@sha256:ddd", "alpine:3.20", 1), func(context.Context, string) (string, error) {
+		t.Error("lookup called for an unpinned image")
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("VerifyPinnedDigests() error = %v", err)
+	}
+	if checks[0].Skipped == "" || checks[0].Drifted() {
+		t.Errorf("unpinned external image check = %+v, want skipped", checks[0])
+	}
+}
@@ -42,20 +42,29 @@ var Signatures = map[string]pipelines.PipelineSignature{
 		Name:        "go-app",
 		Description: "Complete Go application with build, rootfs, and final stages",
 		Parameters: map[string]pipelines.ParamSpec{
-			"repo":          {Type: pipelines.TypeString, Required: true},
-			"package":       {Type: pipelines.TypeString, Required: false},
-			"binary":        {Type: pipelines.TypeString, Required: true},
-			"workdir":       {Type: pipelines.TypeString, Required: false},
-			"ignore":        {Type: pipelines.TypeStringArray, Required: false},
-			"go-tags":       {Type: pipelines.TypeString, Required: false},
-			"go-experiment": {Type: pipelines.TypeString, Required: false},
-			"packages":      {Type: pipelines.TypeStringArray, Required: false},
-			"go-generate":   {Type: pipelines.TypeStringArray, Required: false},
-			"go-install":    {Type: pipelines.TypeStringArray, Required: false},
-			"expose":        {Type: pipelines.TypeStringArray, Required: false},
-			"cmd":           {Type: pipelines.TypeStringArray, Required: false},
-			"extra-copies":  {Type: pipelines.TypeObjectArray, Required: false},
+			"repo":             {Type: pipelines.TypeString, Required: true},
+			"package":          {Type: pipelines.TypeString, Required: false},
+			"binary":           {Type: pipelines.TypeString, Required: true},
+			"workdir":          {Type: pipelines.TypeString, Required: false},
+			"ignore":           {Type: pipelines.TypeStringArray, Required: false},
+			"go-tags":          {Type: pipelines.TypeString, Required: false},
+			"go-experiment":    {Type: pipelines.TypeString, Required: false},
+			"cgo":              {Type: pipelines.TypeBool, Required: false},
+			"output":           {Type: pipelines.TypeString, Required: false},
+			"packages":         {Type: pipelines.TypeStringArray, Required: false},
+			"go-generate":      {Type: pipelines.TypeStringArray, Required: false},
+			"go-install":       {Type: pipelines.TypeStringArray, Required: false},
+			"expose":           {Type: pipelines.TypeStringArray, Required: false},
+			"cmd":              {Type: pipelines.TypeStringArray, Required: false},
+			"extra-copies":     {Type: pipelines.TypeObjectArray, Required: false},
+			"per-stage-output": {Type: pipelines.TypeBool, Required: false},
+			"notices-from":     {Type: pipelines.TypeString, Required: false},
+			"volumes":          {Type: pipelines.TypeObjectArray, Required: false},
+			"stopsignal":       {Type: pipelines.TypeString, Required: false},
+			"final-image":      {Type: pipelines.TypeString, Required: false},
+			"labels":           {Type: pipelines.TypeStringMap, Required: false},
 		},
+		MutuallyExclusive: [][]string{{"output", "per-stage-output"}},
 	},
 	"multi-go-app": {
 		Name:        "multi-go-app",
@@ -67,22 +76,49 @@ var Signatures = map[string]pipelines.PipelineSignature{
 			"expose":       {Type: pipelines.TypeStringArray, Required: false},
 			"cmd":          {Type: pipelines.TypeStringArray, Required: false},
 			"entrypoint":   {Type: pipelines.TypeStringArray, Required: false},
+			"labels":       {Type: pipelines.TypeStringMap, Required: false},
 		},
 	},
 	"rust-app": {
 		Name:        "rust-app",
 		Description: "Complete Rust application with build, rootfs, and final stages",
 		Parameters: map[string]pipelines.ParamSpec{
-			"repo":     {Type: pipelines.TypeString, Required: true},
-			"binary":   {Type: pipelines.TypeString, Required: true},
-			"workdir":  {Type: pipelines.TypeString, Required: false},
-			"features": {Type: pipelines.TypeString, Required: false},
-			"patches":  {Type: pipelines.TypeStringArray, Required: false},
-			"packages": {Type: pipelines.TypeStringArray, Required: false},
-			"tag":      {Type: pipelines.TypeString, Required: false},
-			"expose":   {Type: pipelines.TypeStringArray, Required: false},
-			"cmd":      {Type: pipelines.TypeStringArray, Required: false},
+			"repo":             {Type: pipelines.TypeString, Required: true},
+			"binary":           {Type: pipelines.TypeString, Required: true},
+			"workdir":          {Type: pipelines.TypeString, Required: false},
+			"features":         {Type: pipelines.TypeString, Required: false},
+			"patches":          {Type: pipelines.TypeStringArray, Required: false},
+			"packages":         {Type: pipelines.TypeStringArray, Required: false},
+			"tag":              {Type: pipelines.TypeString, Required: false},
+			"expose":           {Type: pipelines.TypeStringArray, Required: false},
+			"cmd":              {Type: pipelines.TypeStringArray, Required: false},
+			"extra-copies":     {Type: pipelines.TypeObjectArray, Required: false},
+			"per-stage-output": {Type: pipelines.TypeBool, Required: false},
+			"notices-from":     {Type: pipelines.TypeString, Required: false},
+			"volumes":          {Type: pipelines.TypeObjectArray, Required: false},
+			"stopsignal":       {Type: pipelines.TypeString, Required: false},
+			"final-image":      {Type: pipelines.TypeString, Required: false},
+			"labels":           {Type: pipelines.TypeStringMap, Required: false},
+		},
+	},
+	"python-app": {
+		Name:        "python-app",
+		Description: "Complete Python application installed into a virtualenv with build, rootfs, and final stages",
+		Parameters: map[string]pipelines.ParamSpec{
+			"repo":           {Type: pipelines.TypeString, Required: true},
+			"tag":            {Type: pipelines.TypeString, Required: false},
+			"binary":         {Type: pipelines.TypeString, Required: false},
+			"entrypoint":     {Type: pipelines.TypeStringArray, Required: false},
+			"workdir":        {Type: pipelines.TypeString, Required: false},
+			"venv":           {Type: pipelines.TypeString, Required: false},
+			"requirements":   {Type: pipelines.TypeString, Required: false},
+			"extras":         {Type: pipelines.TypeStringArray, Required: false},
+			"python-version": {Type: pipelines.TypeString, Required: false},
+			"expose":         {Type: pipelines.TypeStringArray, Required: false},
+			"cmd":            {Type: pipelines.TypeStringArray, Required: false},
+			"extra-copies":   {Type: pipelines.TypeObjectArray, Required: false},
 		},
+		AtLeastOne: [][]string{{"binary", "entrypoint"}},
 	},
 }
 
@@ -2,6 +2,7 @@ package templates
 
 import (
 	"fmt"
+	"path"
 
 	"github.com/greboid/dfo/pkg/pipelines"
 )
@@ -9,10 +10,16 @@ import (
 const (
 	DefaultVolumeOwner       = "65532:65532"
 	DefaultVolumePermissions = "777"
+	DefaultOutput            = "/main"
+	DefaultNoticesPath       = "/notices"
+	DefaultVenv              = "/opt/venv"
+	DefaultFinalImage        = "base"
+	StageOutputRoot          = "/out"
 )
 
 type TemplateResult struct {
 	Stages []StageResult
+	Labels map[string]string
 }
 
 type StageResult struct {
@@ -31,6 +38,8 @@ type EnvironmentResult struct {
 	Expose         []string
 	Entrypoint     []string
 	Cmd            []string
+	Volume         []string
+	StopSignal     string
 }
 
 type PipelineStepResult struct {
@@ -45,8 +54,11 @@ type CopyStepResult struct {
 	From      string
 	To        string
 	Chown     string
+	Chmod     string
 }
 
+const binaryMode = "0755"
+
 type TemplateFunc func(params map[string]any) (TemplateResult, error)
 
 var Registry = map[string]TemplateFunc{
@@ -55,6 +67,7 @@ var Registry = map[string]TemplateFunc{
 	"go-app":       goApp,
 	"multi-go-app": multiGoApp,
 	"rust-app":     rustApp,
+	"python-app":   pythonApp,
 }
 
 func goBuilder(params map[string]any) (TemplateResult, error) {
@@ -113,7 +126,9 @@ func rustBuilder(params map[string]any) (TemplateResult, error) {
 func goApp(params map[string]any) (TemplateResult, error) {
 	binary, _ := params["binary"].(string)
 
+	output := getStringOrDefault(params, "output", stageOutput(params, "build"))
 	buildParams := prepareGoBuildParams(params)
+	buildParams["output"] = output
 
 	volumes, err := ParseVolumes(params)
 	if err != nil {
@@ -126,11 +141,15 @@ func goApp(params map[string]any) (TemplateResult, error) {
 	}
 
 	buildStage := createGoBuildStage(buildParams, volumes)
-	rootfsStage := createGoRootfsStage(binary, volumes, extraCopies)
+	noticesFrom := getStringOrDefault(params, "notices-from", DefaultNoticesPath)
+	rootfsStage := createGoRootfsStage(binary, output, noticesFrom, volumes, extraCopies)
 	finalStage := createFinalStage(binary, params)
+	finalStage.Environment.Volume = volumePaths(volumes)
+	setFinalImage(params, &rootfsStage, &finalStage)
 
 	return TemplateResult{
 		Stages: []StageResult{buildStage, rootfsStage, finalStage},
+		Labels: parseLabels(params),
 	}, nil
 }
 
@@ -160,6 +179,9 @@ func prepareGoBuildParams(params map[string]any) map[string]any {
 	if goExperiment, ok := params["go-experiment"].(string); ok {
 		buildParams["go-experiment"] = goExperiment
 	}
+	if cgo, ok := params["cgo"].(bool); ok {
+		buildParams["cgo"] = cgo
+	}
 	if packages, ok := params["packages"].([]any); ok {
 		buildParams["packages"] = packages
 	}
@@ -173,13 +195,26 @@ func prepareGoBuildParams(params map[string]any) map[string]any {
 	return buildParams
 }
 
-func createGoBuildStage(buildParams map[string]any, volumes []VolumeSpec) StageResult {
-	buildPipeline := []PipelineStepResult{
-		{
-			Uses: "build-go-static",
-			With: buildParams,
-		},
+func stageOutput(params map[string]any, stage string) string {
+	if perStage, _ := params["per-stage-output"].(bool); perStage {
+		return path.Join(StageOutputRoot, stage, "main")
+	}
+	return DefaultOutput
+}
+
+func createOutputDirStep(output string) []PipelineStepResult {
+	if output == DefaultOutput {
+		return nil
 	}
+	return []PipelineStepResult{{Run: "mkdir -p " + path.Dir(output)}}
+}
+
+func createGoBuildStage(buildParams map[string]any, volumes []VolumeSpec) StageResult {
+	output, _ := buildParams["output"].(string)
+	buildPipeline := append(createOutputDirStep(output), PipelineStepResult{
+		Uses: "build-go-static",
+		With: buildParams,
+	})
 
 	if volumeStep := CreateVolumesStep(volumes); volumeStep != nil {
 		buildPipeline = append(buildPipeline, *volumeStep)
@@ -194,20 +229,21 @@ func createGoBuildStage(buildParams map[string]any, volumes []VolumeSpec) StageR
 	}
 }
 
-func createGoRootfsStage(binary string, volumes []VolumeSpec, extraCopies []ExtraCopySpec) StageResult {
+func createGoRootfsStage(binary, output, noticesFrom string, volumes []VolumeSpec, extraCopies []ExtraCopySpec) StageResult {
 	rootfsPipeline := []PipelineStepResult{
 		{
 			Copy: &CopyStepResult{
 				FromStage: "build",
-				From:      "/main",
+				From:      output,
 				To:        "/rootfs/" + binary,
+				Chmod:     binaryMode,
 			},
 		},
 		{
 			Copy: &CopyStepResult{
 				FromStage: "build",
-				From:      "/notices",
-				To:        "/rootfs/notices",
+				From:      noticesFrom,
+				To:        "/rootfs" + DefaultNoticesPath,
 			},
 		},
 	}
@@ -271,9 +307,45 @@ func createFinalStage(binary string, params map[string]any) StageResult {
 		finalStage.Environment.Cmd = convertStringArray(cmd)
 	}
 
+	if stopSignal, ok := params["stopsignal"].(string); ok {
+		finalStage.Environment.StopSignal = stopSignal
+	}
+
 	return finalStage
 }
 
+func setFinalImage(params map[string]any, stages ...*StageResult) {
+	finalImage := getStringOrDefault(params, "final-image", DefaultFinalImage)
+	for _, stage := range stages {
+		stage.Environment.BaseImage = finalImage
+	}
+}
+
+func parseLabels(params map[string]any) map[string]string {
+	labelsParam, ok := params["labels"].(map[string]any)
+	if !ok || len(labelsParam) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(labelsParam))
+	for key, value := range labelsParam {
+		if str, ok := value.(string); ok {
+			labels[key] = str
+		}
+	}
+	return labels
+}
+
+func volumePaths(volumes []VolumeSpec) []string {
+	if len(volumes) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		paths = append(paths, vol.Path)
+	}
+	return paths
+}
+
 func getStringOrDefault(params map[string]any, key, defaultValue string) string {
 	if val, ok := params[key].(string); ok {
 		return val
@@ -294,7 +366,9 @@ func convertStringArray(arr []any) []string {
 func rustApp(params map[string]any) (TemplateResult, error) {
 	binary, _ := params["binary"].(string)
 
+	output := stageOutput(params, "build")
 	buildParams := prepareRustBuildParams(params)
+	buildParams["output"] = output
 	packages := preparePackages(params)
 
 	volumes, err := ParseVolumes(params)
@@ -302,12 +376,21 @@ func rustApp(params map[string]any) (TemplateResult, error) {
 		return TemplateResult{}, fmt.Errorf("parsing volumes: %w", err)
 	}
 
+	extraCopies, err := ParseExtraCopies(params)
+	if err != nil {
+		return TemplateResult{}, fmt.Errorf("parsing extra-copies: %w", err)
+	}
+
 	buildStage := createRustBuildStage(buildParams, packages, volumes)
-	rootfsStage := createRustRootfsStage(binary, volumes)
+	noticesFrom := getStringOrDefault(params, "notices-from", "")
+	rootfsStage := createRustRootfsStage(binary, output, noticesFrom, volumes, extraCopies)
 	finalStage := createFinalStage(binary, params)
+	finalStage.Environment.Volume = volumePaths(volumes)
+	setFinalImage(params, &rootfsStage, &finalStage)
 
 	return TemplateResult{
 		Stages: []StageResult{buildStage, rootfsStage, finalStage},
+		Labels: parseLabels(params),
 	}, nil
 }
 
@@ -347,12 +430,11 @@ func preparePackages(params map[string]any) []string {
 }
 
 func createRustBuildStage(buildParams map[string]any, packages []string, volumes []VolumeSpec) StageResult {
-	buildPipeline := []PipelineStepResult{
-		{
-			Uses: "clone-and-build-rust",
-			With: buildParams,
-		},
-	}
+	output, _ := buildParams["output"].(string)
+	buildPipeline := append(createOutputDirStep(output), PipelineStepResult{
+		Uses: "clone-and-build-rust",
+		With: buildParams,
+	})
 
 	if volumeStep := CreateVolumesStep(volumes); volumeStep != nil {
 		buildPipeline = append(buildPipeline, *volumeStep)
@@ -368,17 +450,28 @@ func createRustBuildStage(buildParams map[string]any, packages []string, volumes
 	}
 }
 
-func createRustRootfsStage(binary string, volumes []VolumeSpec) StageResult {
+func createRustRootfsStage(binary, output, noticesFrom string, volumes []VolumeSpec, extraCopies []ExtraCopySpec) StageResult {
 	rootfsPipeline := []PipelineStepResult{
 		{
 			Copy: &CopyStepResult{
 				FromStage: "build",
-				From:      "/main",
+				From:      output,
 				To:        "/rootfs/" + binary,
+				Chmod:     binaryMode,
 			},
 		},
 	}
 
+	if noticesFrom != "" {
+		rootfsPipeline = append(rootfsPipeline, PipelineStepResult{
+			Copy: &CopyStepResult{
+				FromStage: "build",
+				From:      noticesFrom,
+				To:        "/rootfs" + DefaultNoticesPath,
+			},
+		})
+	}
+
 	for _, vol := range volumes {
 		rootfsPipeline = append(rootfsPipeline, PipelineStepResult{
 			Copy: &CopyStepResult{
@@ -389,6 +482,16 @@ func createRustRootfsStage(binary string, volumes []VolumeSpec) StageResult {
 		})
 	}
 
+	for _, ec := range extraCopies {
+		rootfsPipeline = append(rootfsPipeline, PipelineStepResult{
+			Copy: &CopyStepResult{
+				FromStage: "build",
+				From:      ec.From,
+				To:        "/rootfs" + ec.To,
+			},
+		})
+	}
+
 	return StageResult{
 		Name: "rootfs",
 		Environment: EnvironmentResult{
@@ -398,6 +501,93 @@ func createRustRootfsStage(binary string, volumes []VolumeSpec) StageResult {
 	}
 }
 
+func pythonApp(params map[string]any) (TemplateResult, error) {
+	binary, _ := params["binary"].(string)
+	venv := getStringOrDefault(params, "venv", DefaultVenv)
+
+	extraCopies, err := ParseExtraCopies(params)
+	if err != nil {
+		return TemplateResult{}, fmt.Errorf("parsing extra-copies: %w", err)
+	}
+
+	buildStage := createPythonBuildStage(preparePythonBuildParams(params, venv))
+	rootfsStage := createPythonRootfsStage(venv, extraCopies)
+	finalStage := createFinalStage(binary, params)
+	if _, ok := params["entrypoint"]; !ok {
+		finalStage.Environment.Entrypoint = []string{path.Join(venv, "bin", binary)}
+	}
+
+	return TemplateResult{
+		Stages: []StageResult{buildStage, rootfsStage, finalStage},
+	}, nil
+}
+
+func preparePythonBuildParams(params map[string]any, venv string) map[string]any {
+	repo, _ := params["repo"].(string)
+
+	buildParams := map[string]any{
+		"repo": repo,
+		"venv": venv,
+	}
+
+	for _, key := range []string{"tag", "workdir", "requirements", "python-version"} {
+		if value, ok := params[key].(string); ok {
+			buildParams[key] = value
+		}
+	}
+	if extras, ok := params["extras"].([]any); ok {
+		buildParams["extras"] = extras
+	}
+
+	return buildParams
+}
+
+func createPythonBuildStage(buildParams map[string]any) StageResult {
+	return StageResult{
+		Name: "build",
+		Environment: EnvironmentResult{
+			BaseImage: "base",
+		},
+		Pipeline: []PipelineStepResult{
+			{
+				Uses: "clone-and-build-python",
+				With: buildParams,
+			},
+		},
+	}
+}
+
+func createPythonRootfsStage(venv string, extraCopies []ExtraCopySpec) StageResult {
+	rootfsPipeline := []PipelineStepResult{
+		{
+			Copy: &CopyStepResult{
+				FromStage: "build",
+				From:      venv + "/",
+				To:        "/rootfs" + venv + "/",
+			},
+		},
+	}
+
+	for _, ec := range extraCopies {
+		rootfsPipeline = append(rootfsPipeline, PipelineStepResult{
+			Copy: &CopyStepResult{
+				FromStage: "build",
+				From:      ec.From,
+				To:        "/rootfs" + ec.To,
+			},
+		})
+	}
+
+	return StageResult{
+		Name: "rootfs",
+		Environment: EnvironmentResult{
+			BaseImage:      "base",
+			RootfsPackages: []string{"python3"},
+		},
+		Pipeline: rootfsPipeline,
+	}
+}
+
 type BinarySpec struct {
 	Repo         string
 	Tag          string
@@ -544,6 +734,7 @@ func multiGoApp(params map[string]any) (TemplateResult, error) {
 
 	return TemplateResult{
 		Stages: []StageResult{buildStage, rootfsStage, finalStage},
+		Labels: parseLabels(params),
 	}, nil
 }
 
@@ -552,15 +743,17 @@ func createMultiBuildPipeline(binaries []BinarySpec) []PipelineStepResult {
 	var buildPipeline []PipelineStepResult
 
 	for _, bin := range binaries {
-		workdir := getWorkdirForBin(bin, clonedRepos)
-		buildPipeline = append(buildPipeline, createCloneStep(bin, workdir)...)
+		workdir, alreadyCloned := getWorkdirForBin(bin, clonedRepos)
+		if !alreadyCloned {
+			buildPipeline = append(buildPipeline, createCloneStep(bin, workdir)...)
+		}
 		buildPipeline = append(buildPipeline, createBuildOnlyStep(bin, workdir))
 	}
 
 	return buildPipeline
 }
 
-func getWorkdirForBin(bin BinarySpec, clonedRepos map[string]string) string {
+func getWorkdirForBin(bin BinarySpec, clonedRepos map[string]string) (string, bool) {
 	workdir, alreadyCloned := clonedRepos[bin.Repo]
 	if !alreadyCloned {
 		workdir = "/src"
@@ -569,7 +762,7 @@ func getWorkdirForBin(bin BinarySpec, clonedRepos map[string]string) string {
 		}
 		clonedRepos[bin.Repo] = workdir
 	}
-	return workdir
+	return workdir, alreadyCloned
 }
 
 func createCloneStep(bin BinarySpec, workdir string) []PipelineStepResult {
@@ -590,6 +783,7 @@ func createBuildOnlyStep(bin BinarySpec, workdir string) PipelineStepResult {
 		"workdir": workdir,
 		"package": bin.Package,
 		"output":  "/" + bin.Binary,
+		"notices": binaryNoticesPath(bin),
 	}
 
 	if len(bin.Ignore) > 0 {
@@ -611,6 +805,10 @@ func createBuildOnlyStep(bin BinarySpec, workdir string) PipelineStepResult {
 	}
 }
 
+func binaryNoticesPath(bin BinarySpec) string {
+	return "/notices/" + bin.Binary
+}
+
 func createMultiBuildStage(buildPipeline []PipelineStepResult, volumes []VolumeSpec) StageResult {
 	if volumeStep := CreateVolumesStep(volumes); volumeStep != nil {
 		buildPipeline = append(buildPipeline, *volumeStep)
@@ -634,6 +832,7 @@ func createMultiRootfsStage(binaries []BinarySpec, volumes []VolumeSpec, extraCo
 				FromStage: "build",
 				From:      "/" + bin.Binary,
 				To:        "/rootfs/" + bin.Binary,
+				Chmod:     binaryMode,
 			},
 		})
 	}
@@ -642,8 +841,8 @@ func createMultiRootfsStage(binaries []BinarySpec, volumes []VolumeSpec, extraCo
 		rootfsPipeline = append(rootfsPipeline, PipelineStepResult{
 			Copy: &CopyStepResult{
 				FromStage: "build",
-				From:      "/notices/" + bin.Binary,
-				To:        "/rootfs/notices/" + bin.Binary,
+				From:      binaryNoticesPath(bin),
+				To:        "/rootfs" + binaryNoticesPath(bin),
 			},
 		})
 	}
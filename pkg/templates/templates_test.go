@@ -0,0 +1,662 @@
+package templates
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/greboid/dfo/pkg/pipelines"
+)
+
+func TestPerStageOutput(t *testing.T) {
+	tests := []struct {
+		name           string
+		template       string
+		params         map[string]any
+		expectedOutput string
+		expectMkdir    bool
+	}{
+		{
+			name:           "go-app default output",
+			template:       "go-app",
+			params:         map[string]any{"repo": "https://github.com/owner/app", "binary": "app"},
+			expectedOutput: "/main",
+		},
+		{
+			name:           "go-app per-stage output",
+			template:       "go-app",
+			params:         map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "per-stage-output": true},
+			expectedOutput: "/out/build/main",
+			expectMkdir:    true,
+		},
+		{
+			name:           "rust-app per-stage output",
+			template:       "rust-app",
+			params:         map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "per-stage-output": true},
+			expectedOutput: "/out/build/main",
+			expectMkdir:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Registry[tt.template](tt.params)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.template, err)
+			}
+
+			build := result.Stages[0]
+			if tt.expectMkdir {
+				if build.Pipeline[0].Run != "mkdir -p /out/build" {
+					t.Errorf("first build step Run = %q, want %q", build.Pipeline[0].Run, "mkdir -p /out/build")
+				}
+			} else if build.Pipeline[0].Run != "" {
+				t.Errorf("unexpected run step %q before build", build.Pipeline[0].Run)
+			}
+
+			buildStep := build.Pipeline[len(build.Pipeline)-1]
+			if buildStep.With["output"] != tt.expectedOutput {
+				t.Errorf("build output = %v, want %q", buildStep.With["output"], tt.expectedOutput)
+			}
+
+			rootfsCopy := result.Stages[1].Pipeline[0].Copy
+			if rootfsCopy == nil || rootfsCopy.From != tt.expectedOutput {
+				t.Errorf("rootfs copy = %+v, want from %q", rootfsCopy, tt.expectedOutput)
+			}
+			if rootfsCopy != nil && rootfsCopy.To != "/rootfs/app" {
+				t.Errorf("rootfs copy to = %q, want %q", rootfsCopy.To, "/rootfs/app")
+			}
+		})
+	}
+}
+
+func TestMultiGoAppNotices(t *testing.T) {
+	result, err := multiGoApp(map[string]any{
+		"binaries": []any{
+			map[string]any{"repo": "https://github.com/owner/app", "binary": "server"},
+			map[string]any{"repo": "https://github.com/owner/app", "binary": "client", "package": "./cmd/client"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("multiGoApp() error = %v", err)
+	}
+
+	buildNotices := make(map[string]bool)
+	for _, step := range result.Stages[0].Pipeline {
+		if step.Uses == "build-go-only" {
+			notices, _ := step.With["notices"].(string)
+			buildNotices[notices] = true
+		}
+	}
+
+	copiedNotices := make(map[string]string)
+	for _, step := range result.Stages[1].Pipeline {
+		if step.Copy != nil && strings.HasPrefix(step.Copy.From, "/notices/") {
+			copiedNotices[step.Copy.From] = step.Copy.To
+		}
+	}
+
+	for _, binary := range []string{"server", "client"} {
+		notices := "/notices/" + binary
+		if !buildNotices[notices] {
+			t.Errorf("expected build step with notices %q, got %v", notices, buildNotices)
+		}
+		if copiedNotices[notices] != "/rootfs"+notices {
+			t.Errorf("expected %q copied to %q, got %q", notices, "/rootfs"+notices, copiedNotices[notices])
+		}
+	}
+}
+
+func TestNoticesFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		params   map[string]any
+		expected string
+	}{
+		{
+			name:     "go-app default",
+			template: "go-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app"},
+			expected: "/notices",
+		},
+		{
+			name:     "go-app custom source",
+			template: "go-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "notices-from": "/src/LICENSES"},
+			expected: "/src/LICENSES",
+		},
+		{
+			name:     "rust-app default",
+			template: "rust-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app"},
+		},
+		{
+			name:     "rust-app custom source",
+			template: "rust-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "notices-from": "/src/licenses"},
+			expected: "/src/licenses",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTemplateParams(tt.template, tt.params); err != nil {
+				t.Fatalf("ValidateTemplateParams() error = %v", err)
+			}
+
+			result, err := Registry[tt.template](tt.params)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.template, err)
+			}
+
+			var source string
+			for _, step := range result.Stages[1].Pipeline {
+				if step.Copy != nil && step.Copy.To == "/rootfs/notices" {
+					source = step.Copy.From
+				}
+			}
+			if source != tt.expected {
+				t.Errorf("notices copied from %q, want %q", source, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGoAppBuildPassthrough(t *testing.T) {
+	tests := []struct {
+		name           string
+		params         map[string]any
+		expectedWith   map[string]any
+		expectedOutput string
+		wantErr        string
+	}{
+		{
+			name:           "defaults",
+			params:         map[string]any{},
+			expectedWith:   map[string]any{},
+			expectedOutput: "/main",
+		},
+		{
+			name:           "cgo enabled",
+			params:         map[string]any{"cgo": true},
+			expectedWith:   map[string]any{"cgo": true},
+			expectedOutput: "/main",
+		},
+		{
+			name:           "go tags and output",
+			params:         map[string]any{"cgo": false, "go-tags": "sqlite_omit_load_extension", "output": "/out/app"},
+			expectedWith:   map[string]any{"cgo": false, "go-tags": "sqlite_omit_load_extension"},
+			expectedOutput: "/out/app",
+		},
+		{
+			name:    "cgo must be a bool",
+			params:  map[string]any{"cgo": "yes"},
+			wantErr: "cgo",
+		},
+		{
+			name:    "output with per-stage-output",
+			params:  map[string]any{"output": "/out/app", "per-stage-output": true},
+			wantErr: "cannot specify both output and per-stage-output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{"repo": "https://github.com/owner/app", "binary": "app"}
+			for key, value := range tt.params {
+				params[key] = value
+			}
+
+			err := ValidateTemplateParams("go-app", params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ValidateTemplateParams() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateTemplateParams() error = %v", err)
+			}
+
+			result, err := goApp(params)
+			if err != nil {
+				t.Fatalf("goApp() error = %v", err)
+			}
+
+			build := result.Stages[0].Pipeline[len(result.Stages[0].Pipeline)-1]
+			if build.Uses != "build-go-static" {
+				t.Fatalf("last build step uses %q, want build-go-static", build.Uses)
+			}
+			for key, want := range tt.expectedWith {
+				if build.With[key] != want {
+					t.Errorf("With[%q] = %v, want %v", key, build.With[key], want)
+				}
+			}
+			if _, ok := tt.expectedWith["cgo"]; !ok {
+				if _, present := build.With["cgo"]; present {
+					t.Errorf("With[\"cgo\"] = %v, want unset", build.With["cgo"])
+				}
+			}
+			if build.With["output"] != tt.expectedOutput {
+				t.Errorf("With[\"output\"] = %v, want %q", build.With["output"], tt.expectedOutput)
+			}
+			if rootfsCopy := result.Stages[1].Pipeline[0].Copy; rootfsCopy == nil || rootfsCopy.From != tt.expectedOutput {
+				t.Errorf("rootfs copy = %+v, want from %q", rootfsCopy, tt.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestExtraCopies(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{name: "go-app", template: "go-app"},
+		{name: "rust-app", template: "rust-app"},
+		{name: "python-app", template: "python-app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{
+				"repo":   "https://github.com/owner/app",
+				"binary": "app",
+				"extra-copies": []any{
+					map[string]any{"from": "/src/static/", "to": "/usr/share/app/static/"},
+					map[string]any{"from": "/src/config.toml", "to": "/etc/app/config.toml"},
+				},
+			}
+			if err := ValidateTemplateParams(tt.template, params); err != nil {
+				t.Fatalf("ValidateTemplateParams() error = %v", err)
+			}
+
+			result, err := Registry[tt.template](params)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.template, err)
+			}
+
+			expected := []CopyStepResult{
+				{FromStage: "build", From: "/src/static/", To: "/rootfs/usr/share/app/static/"},
+				{FromStage: "build", From: "/src/config.toml", To: "/rootfs/etc/app/config.toml"},
+			}
+			for _, want := range expected {
+				found := slices.ContainsFunc(result.Stages[1].Pipeline, func(step PipelineStepResult) bool {
+					return step.Copy != nil && *step.Copy == want
+				})
+				if !found {
+					t.Errorf("rootfs stage missing copy %+v", want)
+				}
+			}
+		})
+	}
+}
+
+func TestBinaryCopyChmod(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		params   map[string]any
+		binaries []string
+	}{
+		{
+			name:     "go-app",
+			template: "go-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app"},
+			binaries: []string{"/rootfs/app"},
+		},
+		{
+			name:     "rust-app",
+			template: "rust-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app"},
+			binaries: []string{"/rootfs/app"},
+		},
+		{
+			name:     "multi-go-app",
+			template: "multi-go-app",
+			params: map[string]any{
+				"binaries": []any{
+					map[string]any{"repo": "https://github.com/owner/app", "binary": "server"},
+					map[string]any{"repo": "https://github.com/owner/app", "binary": "client", "package": "./cmd/client"},
+				},
+			},
+			binaries: []string{"/rootfs/server", "/rootfs/client"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Registry[tt.template](tt.params)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.template, err)
+			}
+
+			modes := make(map[string]string)
+			for _, step := range result.Stages[1].Pipeline {
+				if step.Copy != nil {
+					modes[step.Copy.To] = step.Copy.Chmod
+				}
+			}
+
+			for _, binary := range tt.binaries {
+				if modes[binary] != "0755" {
+					t.Errorf("copy to %s chmod = %q, want %q", binary, modes[binary], "0755")
+				}
+			}
+			if mode := modes["/rootfs/notices"]; mode != "" {
+				t.Errorf("notices copy chmod = %q, want none", mode)
+			}
+		})
+	}
+}
+
+func TestMultiGoAppSharedClone(t *testing.T) {
+	result, err := multiGoApp(map[string]any{
+		"binaries": []any{
+			map[string]any{"repo": "https://github.com/owner/app", "binary": "server", "package": "./cmd/server"},
+			map[string]any{"repo": "https://github.com/owner/app", "binary": "client", "package": "./cmd/client"},
+			map[string]any{"repo": "https://github.com/owner/tool", "binary": "tool"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("multiGoApp() error = %v", err)
+	}
+
+	var clones []string
+	builds := make(map[string]map[string]any)
+	for _, step := range result.Stages[0].Pipeline {
+		switch step.Uses {
+		case "clone":
+			clones = append(clones, step.With["workdir"].(string))
+		case "build-go-only":
+			builds[step.With["output"].(string)] = step.With
+		}
+	}
+
+	if want := []string{"/src/owner/app", "/src/owner/tool"}; !slices.Equal(clones, want) {
+		t.Errorf("clone workdirs = %v, want %v", clones, want)
+	}
+
+	expected := map[string][2]string{
+		"/server": {"/src/owner/app", "./cmd/server"},
+		"/client": {"/src/owner/app", "./cmd/client"},
+		"/tool":   {"/src/owner/tool", "."},
+	}
+	for output, want := range expected {
+		build, ok := builds[output]
+		if !ok {
+			t.Errorf("missing build step for %s", output)
+			continue
+		}
+		if build["workdir"] != want[0] {
+			t.Errorf("%s workdir = %v, want %q", output, build["workdir"], want[0])
+		}
+		if build["package"] != want[1] {
+			t.Errorf("%s package = %v, want %q", output, build["package"], want[1])
+		}
+	}
+}
+
+func TestCreateVolumesStepOwnership(t *testing.T) {
+	step := CreateVolumesStep([]VolumeSpec{{Path: "/data", Owner: "1000:1000", Permissions: "750"}})
+	if step == nil {
+		t.Fatal("CreateVolumesStep() returned nil")
+	}
+
+	result, err := pipelines.CreateDirectories(step.With)
+	if err != nil {
+		t.Fatalf("CreateDirectories() error = %v", err)
+	}
+
+	if len(result.Steps) != 1 || !strings.Contains(result.Steps[0].Content, "chown 1000:1000 /data") {
+		t.Errorf("volume ownership not applied: %+v", result.Steps)
+	}
+}
+
+func TestFinalStageVolumeAndStopSignal(t *testing.T) {
+	tests := []struct {
+		name               string
+		template           string
+		params             map[string]any
+		expectedVolume     []string
+		expectedStopSignal string
+	}{
+		{
+			name:     "go-app without volumes",
+			template: "go-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app"},
+		},
+		{
+			name:     "go-app volumes and stopsignal",
+			template: "go-app",
+			params: map[string]any{
+				"repo":       "https://github.com/owner/app",
+				"binary":     "app",
+				"volumes":    []any{map[string]any{"path": "/data"}, map[string]any{"path": "/config"}},
+				"stopsignal": "SIGINT",
+			},
+			expectedVolume:     []string{"/data", "/config"},
+			expectedStopSignal: "SIGINT",
+		},
+		{
+			name:     "rust-app volumes and stopsignal",
+			template: "rust-app",
+			params: map[string]any{
+				"repo":       "https://github.com/owner/app",
+				"binary":     "app",
+				"volumes":    []any{map[string]any{"path": "/data"}},
+				"stopsignal": "SIGQUIT",
+			},
+			expectedVolume:     []string{"/data"},
+			expectedStopSignal: "SIGQUIT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTemplateParams(tt.template, tt.params); err != nil {
+				t.Fatalf("ValidateTemplateParams() error = %v", err)
+			}
+
+			result, err := Registry[tt.template](tt.params)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.template, err)
+			}
+
+			final := result.Stages[len(result.Stages)-1].Environment
+			if !slices.Equal(final.Volume, tt.expectedVolume) {
+				t.Errorf("Volume = %v, want %v", final.Volume, tt.expectedVolume)
+			}
+			if final.StopSignal != tt.expectedStopSignal {
+				t.Errorf("StopSignal = %q, want %q", final.StopSignal, tt.expectedStopSignal)
+			}
+		})
+	}
+}
+
+func TestFinalImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		params   map[string]any
+		expected string
+	}{
+		{
+			name:     "go-app default",
+			template: "go-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app"},
+			expected: "base",
+		},
+		{
+			name:     "go-app custom",
+			template: "go-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "final-image": "base-debug"},
+			expected: "base-debug",
+		},
+		{
+			name:     "rust-app custom",
+			template: "rust-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "final-image": "gcr.io/distroless/static"},
+			expected: "gcr.io/distroless/static",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTemplateParams(tt.template, tt.params); err != nil {
+				t.Fatalf("ValidateTemplateParams() error = %v", err)
+			}
+
+			result, err := Registry[tt.template](tt.params)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.template, err)
+			}
+
+			if build := result.Stages[0].Environment.BaseImage; build == tt.expected {
+				t.Errorf("build stage base image = %q, want it unchanged", build)
+			}
+			for _, stage := range result.Stages[1:] {
+				if stage.Environment.BaseImage != tt.expected {
+					t.Errorf("%s stage base image = %q, want %q", stage.Name, stage.Environment.BaseImage, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateLabels(t *testing.T) {
+	labels := map[string]any{
+		"org.opencontainers.image.source": "https://github.com/owner/app",
+		"org.opencontainers.image.title":  "app",
+	}
+	expected := map[string]string{
+		"org.opencontainers.image.source": "https://github.com/owner/app",
+		"org.opencontainers.image.title":  "app",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		params   map[string]any
+	}{
+		{
+			name:     "go-app",
+			template: "go-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "labels": labels},
+		},
+		{
+			name:     "rust-app",
+			template: "rust-app",
+			params:   map[string]any{"repo": "https://github.com/owner/app", "binary": "app", "labels": labels},
+		},
+		{
+			name:     "multi-go-app",
+			template: "multi-go-app",
+			params: map[string]any{
+				"binaries": []any{map[string]any{"repo": "https://github.com/owner/app", "binary": "app"}},
+				"labels":   labels,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTemplateParams(tt.template, tt.params); err != nil {
+				t.Fatalf("ValidateTemplateParams() error = %v", err)
+			}
+
+			result, err := Registry[tt.template](tt.params)
+			if err != nil {
+				t.Fatalf("%s() error = %v", tt.template, err)
+			}
+
+			if !maps.Equal(result.Labels, expected) {
+				t.Errorf("Labels = %v, want %v", result.Labels, expected)
+			}
+		})
+	}
+}
+
+func TestTemplateLabelsMustBeStrings(t *testing.T) {
+	err := ValidateTemplateParams("go-app", map[string]any{
+		"repo":   "https://github.com/owner/app",
+		"binary": "app",
+		"labels": map[string]any{"version": 1},
+	})
+	if err == nil {
+		t.Error("ValidateTemplateParams() expected error for non-string label value")
+	}
+}
+
+func TestPythonApp(t *testing.T) {
+	tests := []struct {
+		name               string
+		params             map[string]any
+		expectedVenv       string
+		expectedEntrypoint []string
+	}{
+		{
+			name:               "binary in default venv",
+			params:             map[string]any{"repo": "https://github.com/owner/app", "tag": "v1.0.0", "binary": "app"},
+			expectedVenv:       "/opt/venv",
+			expectedEntrypoint: []string{"/opt/venv/bin/app"},
+		},
+		{
+			name:               "custom venv",
+			params:             map[string]any{"repo": "https://github.com/owner/app", "tag": "v1.0.0", "binary": "app", "venv": "/app"},
+			expectedVenv:       "/app",
+			expectedEntrypoint: []string{"/app/bin/app"},
+		},
+		{
+			name:               "explicit entrypoint",
+			params:             map[string]any{"repo": "https://github.com/owner/app", "tag": "v1.0.0", "entrypoint": []any{"/opt/venv/bin/python3", "-m", "app"}},
+			expectedVenv:       "/opt/venv",
+			expectedEntrypoint: []string{"/opt/venv/bin/python3", "-m", "app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTemplateParams("python-app", tt.params); err != nil {
+				t.Fatalf("ValidateTemplateParams() error = %v", err)
+			}
+
+			result, err := pythonApp(tt.params)
+			if err != nil {
+				t.Fatalf("pythonApp() error = %v", err)
+			}
+
+			names := make([]string, 0, len(result.Stages))
+			for _, stage := range result.Stages {
+				names = append(names, stage.Name)
+			}
+			if !slices.Equal(names, []string{"build", "rootfs", "final"}) {
+				t.Fatalf("stages = %v, want build, rootfs, final", names)
+			}
+
+			build := result.Stages[0].Pipeline[0]
+			if build.Uses != "clone-and-build-python" || build.With["venv"] != tt.expectedVenv {
+				t.Errorf("build step = %+v, want clone-and-build-python into %q", build, tt.expectedVenv)
+			}
+
+			venvCopy := result.Stages[1].Pipeline[0].Copy
+			expectedCopy := CopyStepResult{FromStage: "build", From: tt.expectedVenv + "/", To: "/rootfs" + tt.expectedVenv + "/"}
+			if venvCopy == nil || *venvCopy != expectedCopy {
+				t.Errorf("venv copy = %+v, want %+v", venvCopy, expectedCopy)
+			}
+
+			if entrypoint := result.Stages[2].Environment.Entrypoint; !slices.Equal(entrypoint, tt.expectedEntrypoint) {
+				t.Errorf("entrypoint = %v, want %v", entrypoint, tt.expectedEntrypoint)
+			}
+		})
+	}
+}
+
+func TestPythonAppRequiresBinaryOrEntrypoint(t *testing.T) {
+	err := ValidateTemplateParams("python-app", map[string]any{"repo": "https://github.com/owner/app"})
+	if err == nil || !strings.Contains(err.Error(), "at least one of binary, entrypoint is required") {
+		t.Errorf("ValidateTemplateParams() error = %v, want binary/entrypoint requirement", err)
+	}
+}
@@ -2,6 +2,8 @@ package util
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -23,19 +25,53 @@ func FormatDockerfileArray(directive string, values []string) string {
 	return b.String()
 }
 
-func FormatMapDirectives(directive string, values map[string]string) string {
+// FormatMapDirectives emits one directive per key. Values are taken literally
+// unless their key is listed in expand.
+func FormatMapDirectives(directive string, values map[string]string, expand []string) string {
 	if len(values) == 0 {
 		return ""
 	}
 
 	var b strings.Builder
 	for _, key := range SortedKeys(values) {
-		b.WriteString(fmt.Sprintf("%s %s=%q\n", directive, key, values[key]))
+		quote := QuoteDirectiveValue
+		if slices.Contains(expand, key) {
+			quote = QuoteExpandableValue
+		}
+		b.WriteString(fmt.Sprintf("%s %s=%s\n", directive, key, quote(values[key])))
 	}
 	b.WriteString("\n")
 	return b.String()
 }
 
+var (
+	directiveValueEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"\n", `\n`,
+		"\r", `\r`,
+	)
+	expandableValueEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+	)
+)
+
+// QuoteDirectiveValue quotes an ENV, ARG or LABEL value so the builder takes it
+// literally, without variable expansion or line breaks.
+func QuoteDirectiveValue(value string) string {
+	return `"` + directiveValueEscaper.Replace(value) + `"`
+}
+
+// QuoteExpandableValue quotes a value like QuoteDirectiveValue but leaves
+// variable references such as $PATH for the builder to expand.
+func QuoteExpandableValue(value string) string {
+	return `"` + expandableValueEscaper.Replace(value) + `"`
+}
+
 func WrapRun(command string) string {
 	if command == "" {
 		return ""
@@ -72,7 +108,90 @@ func NormalizeShellLine(line string) (normalized string, hasContinuation bool) {
 	return trimmedLine, false
 }
 
+const (
+	ShellSeparator         = ";"
+	ShellSeparatorFailFast = " &&"
+	ShellOptions           = "set -eux"
+	CurlRetryDelay         = 5
+)
+
+// RunIndent is the default indentation for RUN continuation lines.
+const RunIndent = "    "
+
+var heredocPattern = regexp.MustCompile(`<<-?['"]?([A-Za-z_]\w*)['"]?(\s|$)`)
+
+// HasHeredoc reports whether line opens a heredoc (<<EOF, <<-'EOF', ...).
+func HasHeredoc(line string) bool {
+	return heredocPattern.MatchString(line)
+}
+
+func JoinShellCommands(commands []string, separator string) string {
+	return JoinShellCommandsIndent(commands, separator, RunIndent)
+}
+
+func JoinShellCommandsIndent(commands []string, separator, indent string) string {
+	return strings.Join(commands, separator+" \\\n"+indent)
+}
+
+// FormatRunLines renders lines as a single RUN instruction, joining them with
+// separator unless a line already ends with its own continuation.
+func FormatRunLines(lines []string, separator, indent string) string {
+	var b strings.Builder
+	for i, line := range lines {
+		prefix := indent
+		if i == 0 {
+			prefix = "RUN "
+		}
+		if i < len(lines)-1 {
+			b.WriteString(FormatShellLineWithSeparator(line, prefix, separator))
+			continue
+		}
+		normalized, _ := NormalizeShellLine(line)
+		b.WriteString(fmt.Sprintf("%s%s\n", prefix, normalized))
+	}
+	return b.String()
+}
+
+// ReindentContinuations replaces the default RunIndent on continuation lines
+// with indent, leaving heredoc bodies untouched.
+func ReindentContinuations(content, indent string) string {
+	if indent == RunIndent {
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	var heredocEnd string
+	continuation := false
+	for i, line := range lines {
+		if heredocEnd != "" {
+			if strings.TrimSpace(line) == heredocEnd {
+				heredocEnd = ""
+			}
+			continue
+		}
+		if continuation && strings.HasPrefix(line, RunIndent) {
+			lines[i] = indent + strings.TrimPrefix(line, RunIndent)
+		}
+		if match := heredocPattern.FindStringSubmatch(line); match != nil {
+			heredocEnd = match[1]
+		}
+		continuation = strings.HasSuffix(line, "\\")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func CurlFlags(retries int) string {
+	if retries <= 0 {
+		return "-fsSL"
+	}
+	return fmt.Sprintf("-fsSL --retry %d --retry-delay %d --retry-connrefused", retries, CurlRetryDelay)
+}
+
 func FormatShellLineWithContinuation(line, prefix string) string {
+	return FormatShellLineWithSeparator(line, prefix, ShellSeparator)
+}
+
+func FormatShellLineWithSeparator(line, prefix, separator string) string {
 	normalized, hasContinuation := NormalizeShellLine(line)
 	if normalized == "" {
 		return ""
@@ -80,5 +199,5 @@ func FormatShellLineWithContinuation(line, prefix string) string {
 	if hasContinuation {
 		return fmt.Sprintf("%s%s\n", prefix, normalized)
 	}
-	return fmt.Sprintf("%s%s; \\\n", prefix, normalized)
+	return fmt.Sprintf("%s%s%s \\\n", prefix, normalized, separator)
 }
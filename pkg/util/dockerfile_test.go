@@ -82,6 +82,7 @@ func TestFormatMapDirectives(t *testing.T) {
 		name      string
 		directive string
 		values    map[string]string
+		expand    []string
 		expected  string
 	}{
 		{
@@ -124,6 +125,32 @@ func TestFormatMapDirectives(t *testing.T) {
 			values:    map[string]string{"MULTI": "line1\nline2"},
 			expected:  "ENV MULTI=\"line1\\nline2\"\n\n",
 		},
+		{
+			name:      "value with dollar signs",
+			directive: "ENV",
+			values:    map[string]string{"PRICE": "$5 or ${HOME}"},
+			expected:  "ENV PRICE=\"\\$5 or \\${HOME}\"\n\n",
+		},
+		{
+			name:      "expanded variable reference",
+			directive: "ENV",
+			values:    map[string]string{"PATH": "/app/bin:$PATH", "PRICE": "$5"},
+			expand:    []string{"PATH"},
+			expected:  "ENV PATH=\"/app/bin:$PATH\"\nENV PRICE=\"\\$5\"\n\n",
+		},
+		{
+			name:      "expanded braced variable reference",
+			directive: "LABEL",
+			values:    map[string]string{"version": "${VERSION}"},
+			expand:    []string{"version"},
+			expected:  "LABEL version=\"${VERSION}\"\n\n",
+		},
+		{
+			name:      "value with backslash",
+			directive: "LABEL",
+			values:    map[string]string{"path": `C:\app\"bin"`},
+			expected:  "LABEL path=\"C:\\\\app\\\\\\\"bin\\\"\"\n\n",
+		},
 		{
 			name:      "empty value",
 			directive: "ENV",
@@ -159,7 +186,7 @@ func TestFormatMapDirectives(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := FormatMapDirectives(tt.directive, tt.values)
+			result := FormatMapDirectives(tt.directive, tt.values, tt.expand)
 			if result != tt.expected {
 				t.Errorf("expected:\n%q\ngot:\n%q", tt.expected, result)
 			}
@@ -354,6 +381,55 @@ func TestFormatShellLineWithContinuation(t *testing.T) {
 	}
 }
 
+func TestFormatShellLineWithSeparator(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		separator string
+		expected  string
+	}{
+		{
+			name:      "semicolon separator",
+			line:      "echo hello",
+			separator: ShellSeparator,
+			expected:  "    echo hello; \\\n",
+		},
+		{
+			name:      "fail-fast separator",
+			line:      "echo hello",
+			separator: ShellSeparatorFailFast,
+			expected:  "    echo hello && \\\n",
+		},
+		{
+			name:      "fail-fast replaces trailing semicolon",
+			line:      "set -eux;",
+			separator: ShellSeparatorFailFast,
+			expected:  "    set -eux && \\\n",
+		},
+		{
+			name:      "fail-fast does not double trailing and",
+			line:      "make &&",
+			separator: ShellSeparatorFailFast,
+			expected:  "    make && \\\n",
+		},
+		{
+			name:      "continuation kept as-is",
+			line:      "rm -rf \\",
+			separator: ShellSeparatorFailFast,
+			expected:  "    rm -rf \\\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FormatShellLineWithSeparator(tt.line, "    ", tt.separator)
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestNormalizeShellLine(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -441,3 +517,140 @@ func TestNormalizeShellLine(t *testing.T) {
 		})
 	}
 }
+
+func TestJoinShellCommandsIndent(t *testing.T) {
+	tests := []struct {
+		name      string
+		commands  []string
+		separator string
+		indent    string
+		expected  string
+	}{
+		{
+			name:      "single command",
+			commands:  []string{"echo hello"},
+			separator: ShellSeparator,
+			indent:    "    ",
+			expected:  "echo hello",
+		},
+		{
+			name:      "fail fast separator",
+			commands:  []string{"apk update", "apk add curl"},
+			separator: ShellSeparatorFailFast,
+			indent:    "    ",
+			expected:  "apk update && \\\n    apk add curl",
+		},
+		{
+			name:      "custom indent",
+			commands:  []string{"set -eux", "make", "make install"},
+			separator: ShellSeparator,
+			indent:    "\t",
+			expected:  "set -eux; \\\n\tmake; \\\n\tmake install",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := JoinShellCommandsIndent(tt.commands, tt.separator, tt.indent)
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestFormatRunLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		indent   string
+		expected string
+	}{
+		{
+			name:     "single line",
+			lines:    []string{"make"},
+			indent:   RunIndent,
+			expected: "RUN make\n",
+		},
+		{
+			name:     "default indent",
+			lines:    []string{"make", "make install"},
+			indent:   RunIndent,
+			expected: "RUN make; \\\n    make install\n",
+		},
+		{
+			name:     "custom indent keeps explicit continuations",
+			lines:    []string{"./configure \\", "--prefix=/usr", "make"},
+			indent:   "\t",
+			expected: "RUN ./configure \\\n\t--prefix=/usr; \\\n\tmake\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FormatRunLines(tt.lines, ShellSeparator, tt.indent)
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestReindentContinuations(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		indent   string
+		expected string
+	}{
+		{
+			name:     "default indent is unchanged",
+			content:  "RUN a && \\\n    b\n",
+			indent:   RunIndent,
+			expected: "RUN a && \\\n    b\n",
+		},
+		{
+			name:     "continuation lines",
+			content:  "RUN a && \\\n    b && \\\n    c\n",
+			indent:   "  ",
+			expected: "RUN a && \\\n  b && \\\n  c\n",
+		},
+		{
+			name:     "heredoc body untouched",
+			content:  "RUN cat > /etc/app.conf <<'EOF'\n    key = value \\\n    other\nEOF\nRUN a && \\\n    b\n",
+			indent:   "\t",
+			expected: "RUN cat > /etc/app.conf <<'EOF'\n    key = value \\\n    other\nEOF\nRUN a && \\\n\tb\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ReindentContinuations(tt.content, tt.indent)
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestHasHeredoc(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected bool
+	}{
+		{line: "RUN cat > /etc/app.conf <<'EOF'", expected: true},
+		{line: "RUN cat <<EOF", expected: true},
+		{line: `COPY <<-"END" /etc/motd`, expected: true},
+		{line: "RUN <<-END", expected: true},
+		{line: "RUN echo $((1 << 2)) > /tmp/x", expected: false},
+		{line: "RUN make", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if result := HasHeredoc(tt.line); result != tt.expected {
+				t.Errorf("HasHeredoc(%q) = %v, want %v", tt.line, result, tt.expected)
+			}
+		})
+	}
+}
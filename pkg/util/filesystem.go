@@ -51,3 +51,15 @@ func (OSFS) WalkDir(root string, fn fs.WalkDirFunc) error {
 func DefaultFS() WalkableFS {
 	return OSFS{}
 }
+
+type DryRunFS struct {
+	WritableFS
+}
+
+func (DryRunFS) WriteFile(string, []byte, fs.FileMode) error {
+	return nil
+}
+
+func (DryRunFS) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
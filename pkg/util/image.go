@@ -25,3 +25,14 @@ func NormalizeDigest(digest string) string {
 func FormatFullRef(imageName, digest string) string {
 	return fmt.Sprintf("%s@%s", imageName, digest)
 }
+
+func DigestOnlyRef(ref string) string {
+	name, digest, ok := strings.Cut(ref, "@")
+	if !ok {
+		return ref
+	}
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		name = name[:idx]
+	}
+	return fmt.Sprintf("%s@%s", name, digest)
+}
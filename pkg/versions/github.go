@@ -0,0 +1,120 @@
+package versions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	githubReleaseURLTemplate = "https://api.github.com/repos/%s/releases/tags/%s"
+	githubAPITimeout         = 30 * time.Second
+)
+
+type ReleaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// GitHubRelease is a release asset and its checksums file, resolved at
+// generate time so the build does not have to query the GitHub API.
+type GitHubRelease struct {
+	Tag         string
+	Asset       ReleaseAsset
+	ChecksumURL string
+}
+
+var githubHTTPClient = &http.Client{Timeout: githubAPITimeout}
+
+// ResolveGitHubRelease finds the assets matching assetPattern and
+// checksumPattern in the ownerRepo release for tag. An empty tag resolves to
+// the latest tag of the repository.
+func (r *Resolver) ResolveGitHubRelease(ownerRepo, tag, assetPattern, checksumPattern string) (GitHubRelease, error) {
+	if tag == "" {
+		latestTag, err := r.resolveGitTag("https://github.com/" + ownerRepo)
+		if err != nil {
+			return GitHubRelease{}, err
+		}
+		tag = latestTag
+	}
+
+	assets, err := r.githubReleaseClient(r.ctx, ownerRepo, tag)
+	if err != nil {
+		return GitHubRelease{}, fmt.Errorf("fetching %s release %s: %w", ownerRepo, tag, err)
+	}
+
+	asset, err := MatchReleaseAsset(assets, assetPattern)
+	if err != nil {
+		return GitHubRelease{}, fmt.Errorf("%s release %s: %w", ownerRepo, tag, err)
+	}
+	checksums, err := MatchReleaseAsset(assets, checksumPattern)
+	if err != nil {
+		return GitHubRelease{}, fmt.Errorf("%s release %s: %w", ownerRepo, tag, err)
+	}
+
+	return GitHubRelease{Tag: tag, Asset: asset, ChecksumURL: checksums.URL}, nil
+}
+
+// MatchReleaseAsset returns the single asset whose name matches pattern.
+func MatchReleaseAsset(assets []ReleaseAsset, pattern string) (ReleaseAsset, error) {
+	var matches []ReleaseAsset
+	for _, asset := range assets {
+		ok, err := path.Match(pattern, asset.Name)
+		if err != nil {
+			return ReleaseAsset{}, fmt.Errorf("invalid asset pattern %q: %w", pattern, err)
+		}
+		if ok {
+			matches = append(matches, asset)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return ReleaseAsset{}, fmt.Errorf("no asset matches %q (available: %s)", pattern, strings.Join(assetNames(assets), ", "))
+	default:
+		return ReleaseAsset{}, fmt.Errorf("asset pattern %q is ambiguous (matches: %s)", pattern, strings.Join(assetNames(matches), ", "))
+	}
+}
+
+func assetNames(assets []ReleaseAsset) []string {
+	names := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		names = append(names, asset.Name)
+	}
+	return names
+}
+
+func (r *Resolver) fetchGitHubReleaseAssets(ctx context.Context, ownerRepo, tag string) ([]ReleaseAsset, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(githubReleaseURLTemplate, ownerRepo, tag), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if r.gitPass != "" {
+		req.SetBasicAuth(r.gitUser, r.gitPass)
+	}
+
+	resp, err := githubHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, req.URL)
+	}
+
+	var release struct {
+		Assets []ReleaseAsset `json:"assets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("decoding release: %w", err)
+	}
+	return release.Assets, nil
+}
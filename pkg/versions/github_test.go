@@ -0,0 +1,162 @@
+package versions
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testReleaseAssets = []ReleaseAsset{
+	{Name: "tool_1.2.0_linux_amd64.tar.gz", URL: "https://github.com/owner/tool/releases/download/v1.2.0/tool_1.2.0_linux_amd64.tar.gz"},
+	{Name: "tool_1.2.0_linux_arm64.tar.gz", URL: "https://github.com/owner/tool/releases/download/v1.2.0/tool_1.2.0_linux_arm64.tar.gz"},
+	{Name: "tool_1.2.0_darwin_amd64.zip", URL: "https://github.com/owner/tool/releases/download/v1.2.0/tool_1.2.0_darwin_amd64.zip"},
+	{Name: "tool_1.2.0_checksums.txt", URL: "https://github.com/owner/tool/releases/download/v1.2.0/tool_1.2.0_checksums.txt"},
+}
+
+func TestMatchReleaseAsset(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "single match",
+			pattern:  "*_linux_amd64.tar.gz",
+			expected: "tool_1.2.0_linux_amd64.tar.gz",
+		},
+		{
+			name:     "character class",
+			pattern:  "tool_*_linux_[a]rm64.tar.gz",
+			expected: "tool_1.2.0_linux_arm64.tar.gz",
+		},
+		{
+			name:     "checksums",
+			pattern:  "*checksums.txt",
+			expected: "tool_1.2.0_checksums.txt",
+		},
+		{
+			name:        "no match",
+			pattern:     "*_windows_amd64.zip",
+			expectedErr: "no asset matches",
+		},
+		{
+			name:        "ambiguous",
+			pattern:     "*_linux_*.tar.gz",
+			expectedErr: "ambiguous",
+		},
+		{
+			name:        "invalid pattern",
+			pattern:     "tool_[",
+			expectedErr: "invalid asset pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asset, err := MatchReleaseAsset(testReleaseAssets, tt.pattern)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("MatchReleaseAsset() error = %v, want error containing %q", err, tt.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MatchReleaseAsset() unexpected error: %v", err)
+			}
+			if asset.Name != tt.expected {
+				t.Errorf("MatchReleaseAsset() = %q, want %q", asset.Name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolver_ResolveGitHubRelease(t *testing.T) {
+	tests := []struct {
+		name        string
+		tag         string
+		latestTag   string
+		expectedTag string
+	}{
+		{
+			name:        "explicit tag",
+			tag:         "v1.2.0",
+			expectedTag: "v1.2.0",
+		},
+		{
+			name:        "latest tag",
+			latestTag:   "v1.2.0",
+			expectedTag: "v1.2.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitClient := &mockGitTagClient{tag: tt.latestTag}
+			resolver := NewWithClients(context.Background(), "", "", gitClient.GitTag, nil, nil, nil)
+
+			var requested string
+			resolver.githubReleaseClient = func(_ context.Context, ownerRepo, tag string) ([]ReleaseAsset, error) {
+				requested = ownerRepo + "@" + tag
+				return testReleaseAssets, nil
+			}
+
+			release, err := resolver.ResolveGitHubRelease("owner/tool", tt.tag, "*_linux_amd64.tar.gz", "*checksums.txt")
+			if err != nil {
+				t.Fatalf("ResolveGitHubRelease() error = %v", err)
+			}
+
+			if requested != "owner/tool@"+tt.expectedTag {
+				t.Errorf("requested release %q, want owner/tool@%s", requested, tt.expectedTag)
+			}
+			if release.Tag != tt.expectedTag {
+				t.Errorf("Tag = %q, want %q", release.Tag, tt.expectedTag)
+			}
+			if release.Asset != testReleaseAssets[0] {
+				t.Errorf("Asset = %+v, want %+v", release.Asset, testReleaseAssets[0])
+			}
+			if release.ChecksumURL != testReleaseAssets[3].URL {
+				t.Errorf("ChecksumURL = %q, want %q", release.ChecksumURL, testReleaseAssets[3].URL)
+			}
+		})
+	}
+}
+
+func TestResolver_ResolveGitHubReleaseErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		gitErr    error
+		assetsErr error
+		pattern   string
+	}{
+		{
+			name:    "latest tag lookup fails",
+			gitErr:  errors.New("no tags"),
+			pattern: "*_linux_amd64.tar.gz",
+		},
+		{
+			name:      "release lookup fails",
+			assetsErr: errors.New("HTTP 404"),
+			pattern:   "*_linux_amd64.tar.gz",
+		},
+		{
+			name:    "asset not found",
+			pattern: "*_windows_amd64.zip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitClient := &mockGitTagClient{tag: "v1.2.0", err: tt.gitErr}
+			resolver := NewWithClients(context.Background(), "", "", gitClient.GitTag, nil, nil, nil)
+			resolver.githubReleaseClient = func(context.Context, string, string) ([]ReleaseAsset, error) {
+				return testReleaseAssets, tt.assetsErr
+			}
+
+			if _, err := resolver.ResolveGitHubRelease("owner/tool", "", tt.pattern, "*checksums.txt"); err == nil {
+				t.Error("ResolveGitHubRelease() expected error")
+			}
+		})
+	}
+}
@@ -18,6 +18,8 @@ type Resolver struct {
 	goReleaseClient func(ctx context.Context, options *latest.GoOptions) (latestVersion string, downloadUrl string, downloadChecksum string, err error)
 	postgresClient  func(ctx context.Context, options *latest.TagOptions) (latest string, url string, checksum string, err error)
 	alpineClient    func(ctx context.Context, options *latest.AlpineReleaseOptions) (latestVersion string, downloadUrl string, downloadChecksum string, err error)
+
+	githubReleaseClient func(ctx context.Context, ownerRepo, tag string) ([]ReleaseAsset, error)
 }
 
 func New(ctx context.Context, gitUser, gitPass string) *Resolver {
@@ -29,7 +31,7 @@ func NewWithClients(ctx context.Context, gitUser, gitPass string,
 	goClient func(ctx context.Context, options *latest.GoOptions) (latestVersion string, downloadUrl string, downloadChecksum string, err error),
 	postgresClient func(ctx context.Context, options *latest.TagOptions) (latest string, url string, checksum string, err error),
 	alpineClient func(ctx context.Context, options *latest.AlpineReleaseOptions) (latestVersion string, downloadUrl string, downloadChecksum string, err error)) *Resolver {
-	return &Resolver{
+	r := &Resolver{
 		ctx:             ctx,
 		gitUser:         gitUser,
 		gitPass:         gitPass,
@@ -38,6 +40,8 @@ func NewWithClients(ctx context.Context, gitUser, gitPass string,
 		postgresClient:  postgresClient,
 		alpineClient:    alpineClient,
 	}
+	r.githubReleaseClient = r.fetchGitHubReleaseAssets
+	return r
 }
 
 func (r *Resolver) Resolve(key, value string) (VersionMetadata, error) {